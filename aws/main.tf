@@ -34,6 +34,7 @@ resource "aws_iam_policy" "dynamodb_policy" {
         {
         "Effect": "Allow",
         "Action": [
+            "dynamodb:DescribeTable",
             "dynamodb:GetItem",
             "dynamodb:PutItem",
             "dynamodb:DeleteItem",
@@ -0,0 +1,372 @@
+// Package backup writes and restores encrypted, signed snapshots of a
+// realm's user records.
+//
+// A snapshot is a sequence of CBOR items: a header, the chunks of an
+// encrypted record archive, and an Ed25519 signature over everything before
+// it. The archive is encrypted with XChaCha20-Poly1305 in fixed size chunks,
+// each bound to the header and its position, so that chunks can't be
+// reordered, dropped or moved between snapshots.
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/cipher"
+	"crypto/ed25519"
+	cryptoRand "crypto/rand"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const snapshotFormat string = "jb-sw-realm-backup"
+
+// SchemaVersion is the version of the snapshot format written by Write.
+const SchemaVersion uint64 = 1
+
+// The amount of the archive encrypted in each chunk.
+const chunkSize = 64 * 1024
+
+// ErrRealmMismatch is returned when restoring a snapshot taken from another
+// realm.
+var ErrRealmMismatch = errors.New("snapshot is from a different realm")
+
+// Header describes a snapshot. It isn't encrypted, but it is signed.
+type Header struct {
+	Format        string        `cbor:"format"`
+	SchemaVersion uint64        `cbor:"schema_version"`
+	RealmID       types.RealmID `cbor:"realm_id"`
+	Created       time.Time     `cbor:"created"`
+	NoncePrefix   []byte        `cbor:"nonce_prefix"`
+}
+
+type signature struct {
+	Signature []byte `cbor:"signature"`
+}
+
+// Exactly one field is set in each item of a snapshot.
+type snapshotItem struct {
+	Header    *Header    `cbor:"1,keyasint,omitempty"`
+	Chunk     []byte     `cbor:"2,keyasint,omitempty"`
+	Signature *signature `cbor:"3,keyasint,omitempty"`
+}
+
+// Write writes a snapshot of every record held by store to w, returning the
+// number of records it contains.
+func Write(ctx context.Context, w io.Writer, store records.RecordScanner, realmID types.RealmID, keys *Keys) (uint64, error) {
+	ctx, span := otel.StartSpan(ctx, "WriteBackup")
+	defer span.End()
+
+	if keys.SigningKey == nil {
+		return 0, otel.RecordOutcome(errors.New("a signing key is needed to write a backup"), span)
+	}
+
+	header := Header{
+		Format:        snapshotFormat,
+		SchemaVersion: SchemaVersion,
+		RealmID:       realmID,
+		Created:       time.Now().UTC().Truncate(time.Second),
+		NoncePrefix:   make([]byte, chacha20poly1305.NonceSizeX-8),
+	}
+	if _, err := cryptoRand.Read(header.NoncePrefix); err != nil {
+		return 0, otel.RecordOutcome(err, span)
+	}
+	headerItem, err := cbor.Marshal(snapshotItem{Header: &header})
+	if err != nil {
+		return 0, otel.RecordOutcome(err, span)
+	}
+
+	// everything before the signature is signed
+	digest := sha512.New()
+	signed := io.MultiWriter(w, digest)
+	if _, err := signed.Write(headerItem); err != nil {
+		return 0, otel.RecordOutcome(err, span)
+	}
+
+	sealer, err := newSealer(signed, keys.EncryptionKey, headerItem, header.NoncePrefix)
+	if err != nil {
+		return 0, otel.RecordOutcome(err, span)
+	}
+	count, err := records.ExportArchive(ctx, store, realmID, sealer)
+	if err != nil {
+		return count, otel.RecordOutcome(err, span)
+	}
+	if err := sealer.Close(); err != nil {
+		return count, otel.RecordOutcome(err, span)
+	}
+
+	sig, err := keys.SigningKey.Sign(nil, digest.Sum(nil), &ed25519.Options{Hash: crypto.SHA512})
+	if err != nil {
+		return count, otel.RecordOutcome(err, span)
+	}
+	err = cbor.NewEncoder(w).Encode(snapshotItem{Signature: &signature{Signature: sig}})
+	return count, otel.RecordOutcome(err, span)
+}
+
+// Verify checks the signature of the snapshot read from r, returning its
+// header.
+func Verify(r io.Reader, keys *Keys) (*Header, error) {
+	decoder := cbor.NewDecoder(r)
+	digest := sha512.New()
+
+	header, _, err := readHeader(decoder, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		var raw cbor.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return header, errors.New("snapshot is truncated")
+			}
+			return header, fmt.Errorf("error reading snapshot: %w", err)
+		}
+		var item snapshotItem
+		if err := cbor.Unmarshal(raw, &item); err != nil {
+			return header, fmt.Errorf("error reading snapshot: %w", err)
+		}
+
+		switch {
+		case item.Chunk != nil:
+			digest.Write(raw)
+		case item.Signature != nil:
+			opts := &ed25519.Options{Hash: crypto.SHA512}
+			if err := ed25519.VerifyWithOptions(keys.VerifyingKey, digest.Sum(nil), item.Signature.Signature, opts); err != nil {
+				return header, errors.New("snapshot signature is invalid")
+			}
+			return header, nil
+		default:
+			return header, errors.New("snapshot contains an unexpected item")
+		}
+	}
+}
+
+// Restore verifies the snapshot returned by open, then imports its records
+// into store. It refuses snapshots taken from a different realm and, unless
+// force is set, snapshots containing records that are already stored.
+func Restore(ctx context.Context, store records.RecordStore, realmID types.RealmID, open func() (io.ReadCloser, error), keys *Keys, force bool) (*Header, records.ImportStats, error) {
+	ctx, span := otel.StartSpan(ctx, "RestoreBackup")
+	defer span.End()
+
+	var stats records.ImportStats
+
+	r, err := open()
+	if err != nil {
+		return nil, stats, otel.RecordOutcome(err, span)
+	}
+	header, err := Verify(r, keys)
+	r.Close()
+	if err != nil {
+		return header, stats, otel.RecordOutcome(err, span)
+	}
+	if header.RealmID != realmID {
+		err := fmt.Errorf("%w: it was taken from realm %s", ErrRealmMismatch, header.RealmID)
+		return header, stats, otel.RecordOutcome(err, span)
+	}
+
+	openArchive := func() (io.ReadCloser, error) {
+		r, err := open()
+		if err != nil {
+			return nil, err
+		}
+		opener, err := newOpener(r, keys.EncryptionKey)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		return opener, nil
+	}
+	archiveHeader, stats, err := records.ImportArchive(ctx, store, openArchive, force)
+	if err == nil && archiveHeader.RealmID != realmID {
+		// the signature covers the archive, so this can only be a bug
+		err = fmt.Errorf("%w: its records were taken from realm %s", ErrRealmMismatch, archiveHeader.RealmID)
+	}
+	return header, stats, otel.RecordOutcome(err, span)
+}
+
+func readHeader(decoder *cbor.Decoder, digest hash.Hash) (*Header, []byte, error) {
+	var raw cbor.RawMessage
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, nil, fmt.Errorf("error reading snapshot header: %w", err)
+	}
+	var item snapshotItem
+	if err := cbor.Unmarshal(raw, &item); err != nil {
+		return nil, nil, fmt.Errorf("error reading snapshot header: %w", err)
+	}
+	header := item.Header
+	if header == nil || header.Format != snapshotFormat {
+		return nil, nil, errors.New("not a backup snapshot")
+	}
+	if header.SchemaVersion != SchemaVersion {
+		return nil, nil, fmt.Errorf("unsupported snapshot schema version %d", header.SchemaVersion)
+	}
+	if len(header.NoncePrefix) != chacha20poly1305.NonceSizeX-8 {
+		return nil, nil, errors.New("snapshot header has an invalid nonce prefix")
+	}
+	if digest != nil {
+		digest.Write(raw)
+	}
+	return header, raw, nil
+}
+
+// Each chunk's nonce is the snapshot's random prefix followed by the chunk's
+// index, and its associated data is the encoded header followed by a byte
+// marking the last chunk, so that truncation is detected.
+func chunkNonce(prefix []byte, index uint64) []byte {
+	return binary.BigEndian.AppendUint64(bytes.Clone(prefix), index)
+}
+
+func chunkAssociatedData(headerItem []byte, last bool) []byte {
+	ad := bytes.Clone(headerItem)
+	if last {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+// sealer encrypts what's written to it into chunk items.
+type sealer struct {
+	encoder     *cbor.Encoder
+	aead        cipher.AEAD
+	headerItem  []byte
+	noncePrefix []byte
+	index       uint64
+	buffer      []byte
+}
+
+func newSealer(w io.Writer, key []byte, headerItem []byte, noncePrefix []byte) (*sealer, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	return &sealer{
+		encoder:     cbor.NewEncoder(w),
+		aead:        aead,
+		headerItem:  headerItem,
+		noncePrefix: noncePrefix,
+		buffer:      make([]byte, 0, chunkSize),
+	}, nil
+}
+
+func (s *sealer) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(chunkSize-len(s.buffer), len(p))
+		s.buffer = append(s.buffer, p[:n]...)
+		p = p[n:]
+		// a full chunk is only sealed once more arrives, as the last chunk
+		// has to be marked as such
+		if len(s.buffer) == chunkSize && len(p) > 0 {
+			if err := s.seal(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close seals the last chunk.
+func (s *sealer) Close() error {
+	return s.seal(true)
+}
+
+func (s *sealer) seal(last bool) error {
+	ciphertext := s.aead.Seal(
+		nil,
+		chunkNonce(s.noncePrefix, s.index),
+		s.buffer,
+		chunkAssociatedData(s.headerItem, last),
+	)
+	s.index++
+	s.buffer = s.buffer[:0]
+	return s.encoder.Encode(snapshotItem{Chunk: ciphertext})
+}
+
+// opener decrypts the chunks of a snapshot. It doesn't check the signature,
+// which Verify does.
+type opener struct {
+	closer      io.Closer
+	decoder     *cbor.Decoder
+	aead        cipher.AEAD
+	headerItem  []byte
+	noncePrefix []byte
+	index       uint64
+	plaintext   []byte
+	done        bool
+}
+
+func newOpener(r io.ReadCloser, key []byte) (*opener, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	decoder := cbor.NewDecoder(r)
+	header, headerItem, err := readHeader(decoder, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &opener{
+		closer:      r,
+		decoder:     decoder,
+		aead:        aead,
+		headerItem:  headerItem,
+		noncePrefix: header.NoncePrefix,
+	}, nil
+}
+
+func (o *opener) Read(p []byte) (int, error) {
+	for len(o.plaintext) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		if err := o.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.plaintext)
+	o.plaintext = o.plaintext[n:]
+	return n, nil
+}
+
+func (o *opener) open() error {
+	var item snapshotItem
+	if err := o.decoder.Decode(&item); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return errors.New("snapshot is truncated")
+		}
+		return err
+	}
+	if item.Chunk == nil {
+		return errors.New("snapshot is missing its last chunk")
+	}
+
+	nonce := chunkNonce(o.noncePrefix, o.index)
+	o.index++
+	// the last chunk is only known by whether it opens as the last chunk
+	plaintext, err := o.aead.Open(nil, nonce, item.Chunk, chunkAssociatedData(o.headerItem, false))
+	if err != nil {
+		plaintext, err = o.aead.Open(nil, nonce, item.Chunk, chunkAssociatedData(o.headerItem, true))
+		if err != nil {
+			return errors.New("snapshot could not be decrypted")
+		}
+		o.done = true
+	}
+	o.plaintext = plaintext
+	return nil
+}
+
+func (o *opener) Close() error {
+	return o.closer.Close()
+}
@@ -0,0 +1,154 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeRepeatingByteArray(value byte, length int) []byte {
+	return bytes.Repeat([]byte{value}, length)
+}
+
+func testKeys(t *testing.T) *Keys {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	contents := `{"encryption_key":"` + strings.Repeat("01", 32) + `","signing_key":"` + strings.Repeat("02", 32) + `"}`
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	keys, err := LoadKeys(path)
+	assert.NoError(t, err)
+	return keys
+}
+
+func open(contents []byte) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(contents)), nil
+	}
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	realmID := types.RealmID(makeRepeatingByteArray(5, 16))
+	keys := testKeys(t)
+
+	source := records.NewMemoryRecordStore()
+	registered := records.UserRecord{
+		RegistrationState: records.Registered{
+			OprfPrivateKey: types.OprfPrivateKey(makeRepeatingByteArray(7, 32)),
+			Policy:         types.Policy{NumGuesses: 5},
+		},
+	}
+	// enough records to need a few chunks
+	var recordIDs []records.UserRecordID
+	for i := 0; i < 1000; i++ {
+		recordID, err := records.CreateUserRecordID("acme", strings.Repeat("x", i))
+		assert.NoError(t, err)
+		recordIDs = append(recordIDs, recordID)
+		assert.NoError(t, source.WriteRecord(ctx, recordID, registered, nil))
+	}
+
+	var snapshot bytes.Buffer
+	count, err := Write(ctx, &snapshot, source.(records.RecordScanner), realmID, keys)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1000), count)
+	assert.Greater(t, snapshot.Len(), 2*chunkSize)
+
+	// records aren't readable without the encryption key
+	assert.False(t, bytes.Contains(snapshot.Bytes(), makeRepeatingByteArray(7, 32)))
+
+	header, err := Verify(bytes.NewReader(snapshot.Bytes()), keys)
+	assert.NoError(t, err)
+	assert.Equal(t, realmID, header.RealmID)
+	assert.Equal(t, SchemaVersion, header.SchemaVersion)
+
+	destination := records.NewMemoryRecordStore()
+	_, stats, err := Restore(ctx, destination, realmID, open(snapshot.Bytes()), keys, false)
+	assert.NoError(t, err)
+	assert.Equal(t, records.ImportStats{Created: 1000}, stats)
+	for _, recordID := range recordIDs {
+		record, _, err := destination.GetRecord(ctx, recordID)
+		assert.NoError(t, err)
+		assert.Equal(t, registered, record)
+	}
+
+	// restoring again needs to be forced
+	_, _, err = Restore(ctx, destination, realmID, open(snapshot.Bytes()), keys, false)
+	assert.ErrorIs(t, err, records.ErrRecordExists)
+	_, stats, err = Restore(ctx, destination, realmID, open(snapshot.Bytes()), keys, true)
+	assert.NoError(t, err)
+	assert.Equal(t, records.ImportStats{Overwritten: 1000}, stats)
+}
+
+func TestRestoreRejects(t *testing.T) {
+	ctx := context.Background()
+	realmID := types.RealmID(makeRepeatingByteArray(5, 16))
+	keys := testKeys(t)
+
+	source := records.NewMemoryRecordStore()
+	assert.NoError(t, source.WriteRecord(ctx, "a", records.DefaultUserRecord(), nil))
+	var snapshot bytes.Buffer
+	_, err := Write(ctx, &snapshot, source.(records.RecordScanner), realmID, keys)
+	assert.NoError(t, err)
+
+	destination := records.NewMemoryRecordStore()
+	otherRealm := types.RealmID(makeRepeatingByteArray(6, 16))
+	_, _, err = Restore(ctx, destination, otherRealm, open(snapshot.Bytes()), keys, true)
+	assert.ErrorIs(t, err, ErrRealmMismatch)
+
+	// a snapshot signed by someone else
+	otherKeys := *keys
+	otherKeys.VerifyingKey = ed25519.NewKeyFromSeed(makeRepeatingByteArray(3, 32)).Public().(ed25519.PublicKey)
+	_, _, err = Restore(ctx, destination, realmID, open(snapshot.Bytes()), &otherKeys, true)
+	assert.EqualError(t, err, "snapshot signature is invalid")
+
+	tampered := bytes.Clone(snapshot.Bytes())
+	tampered[len(tampered)/2] ^= 1
+	_, _, err = Restore(ctx, destination, realmID, open(tampered), keys, true)
+	assert.Error(t, err)
+
+	truncated := snapshot.Bytes()[:snapshot.Len()-80]
+	_, _, err = Restore(ctx, destination, realmID, open(truncated), keys, true)
+	assert.EqualError(t, err, "snapshot is truncated")
+
+	_, readRecord, err := destination.GetRecord(ctx, "a")
+	assert.NoError(t, err)
+	assert.Nil(t, readRecord)
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+	load := func(contents string) (*Keys, error) {
+		path := filepath.Join(dir, "keys.json")
+		assert.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+		return LoadKeys(path)
+	}
+	encryptionKey := strings.Repeat("01", 32)
+	signingKey := ed25519.NewKeyFromSeed(makeRepeatingByteArray(2, 32))
+	verifyingKey := hex.EncodeToString(signingKey.Public().(ed25519.PublicKey))
+
+	keys, err := load(`{"encryption_key":"` + encryptionKey + `","verifying_key":"` + verifyingKey + `"}`)
+	assert.NoError(t, err)
+	assert.Nil(t, keys.SigningKey)
+	assert.Equal(t, signingKey.Public(), keys.VerifyingKey)
+
+	_, err = load(`{"encryption_key":"` + encryptionKey + `"}`)
+	assert.EqualError(t, err, "either signing_key or verifying_key is required")
+	_, err = load(`{"encryption_key":"0102","signing_key":"` + strings.Repeat("02", 32) + `"}`)
+	assert.EqualError(t, err, "encryption_key must be 32 hex encoded bytes")
+	_, err = load(`{"encryption_key":"` + encryptionKey + `","signing_key":"` + strings.Repeat("03", 32) + `","verifying_key":"` + verifyingKey + `"}`)
+	assert.EqualError(t, err, "verifying_key doesn't match signing_key")
+
+	// a verifying key alone can't write snapshots
+	var snapshot bytes.Buffer
+	_, err = Write(context.Background(), &snapshot, records.NewMemoryRecordStore().(records.RecordScanner), types.RealmID{}, keys)
+	assert.EqualError(t, err, "a signing key is needed to write a backup")
+}
@@ -0,0 +1,73 @@
+package backup
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Keys encrypt and sign snapshots. Restoring only needs the verifying key,
+// so that the machine restoring a snapshot can't forge one.
+type Keys struct {
+	EncryptionKey []byte
+	// Only needed to write snapshots.
+	SigningKey   ed25519.PrivateKey
+	VerifyingKey ed25519.PublicKey
+}
+
+type keysFile struct {
+	EncryptionKey string `json:"encryption_key"`
+	SigningKey    string `json:"signing_key,omitempty"`
+	VerifyingKey  string `json:"verifying_key,omitempty"`
+}
+
+// LoadKeys reads keys from a JSON file holding a 32-byte hex encryption key,
+// and either a 32-byte hex Ed25519 signing key seed or a verifying key, for
+// example {"encryption_key":"0011...","signing_key":"2233..."}.
+func LoadKeys(path string) (*Keys, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keysFile
+	if err := json.Unmarshal(contents, &file); err != nil {
+		return nil, fmt.Errorf("error parsing key file: %w", err)
+	}
+
+	keys := &Keys{}
+	keys.EncryptionKey, err = hex.DecodeString(file.EncryptionKey)
+	if err != nil || len(keys.EncryptionKey) != chacha20poly1305.KeySize {
+		return nil, fmt.Errorf("encryption_key must be %d hex encoded bytes", chacha20poly1305.KeySize)
+	}
+
+	if file.SigningKey != "" {
+		seed, err := hex.DecodeString(file.SigningKey)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("signing_key must be %d hex encoded bytes", ed25519.SeedSize)
+		}
+		keys.SigningKey = ed25519.NewKeyFromSeed(seed)
+		keys.VerifyingKey = keys.SigningKey.Public().(ed25519.PublicKey)
+	}
+
+	if file.VerifyingKey != "" {
+		verifyingKey, err := hex.DecodeString(file.VerifyingKey)
+		if err != nil || len(verifyingKey) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("verifying_key must be %d hex encoded bytes", ed25519.PublicKeySize)
+		}
+		if keys.VerifyingKey != nil && !keys.VerifyingKey.Equal(ed25519.PublicKey(verifyingKey)) {
+			return nil, errors.New("verifying_key doesn't match signing_key")
+		}
+		keys.VerifyingKey = verifyingKey
+	}
+
+	if keys.VerifyingKey == nil {
+		return nil, errors.New("either signing_key or verifying_key is required")
+	}
+	return keys, nil
+}
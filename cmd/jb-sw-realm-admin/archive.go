@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+)
+
+func exportRecords(ctx context.Context, realm *realm, args []string) int {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "", "The archive file to write. It must not already exist.")
+	flags.Parse(args)
+
+	if *out == "" {
+		fmt.Fprintf(os.Stderr, "\nMissing -out, exiting...\n")
+		return 2
+	}
+	scanner, ok := realm.records.(records.RecordScanner)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "\n%s, exiting...\n", records.ErrScanUnsupported)
+		return 5
+	}
+
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n%s, exiting...\n", err)
+		return 4
+	}
+
+	count, err := records.ExportArchive(ctx, scanner, realm.realmID, file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*out)
+		fmt.Fprintf(os.Stderr, "\nExport failed: %s, exiting...\n", err)
+		return 7
+	}
+
+	fmt.Printf("Exported %d records to %s.\n", count, *out)
+	return 0
+}
+
+func importRecords(ctx context.Context, realm *realm, args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	in := flags.String("in", "", "The archive file to read.")
+	force := flags.Bool("force", false, "Overwrite records that are already stored.")
+	flags.Parse(args)
+
+	if *in == "" {
+		fmt.Fprintf(os.Stderr, "\nMissing -in, exiting...\n")
+		return 2
+	}
+
+	open := func() (io.ReadCloser, error) {
+		return os.Open(*in)
+	}
+	header, stats, err := records.ImportArchive(ctx, realm.records, open, *force)
+	if header != nil && header.RealmID != realm.realmID {
+		fmt.Printf("Note: the archive was exported from realm %s.\n", header.RealmID)
+	}
+	fmt.Printf("Created %d records, overwrote %d.\n", stats.Created, stats.Overwritten)
+	if errors.Is(err, records.ErrRecordExists) {
+		fmt.Fprintf(os.Stderr, "\n%s. %s, exiting...\n", err, recordExistsAdvice(stats, "imported"))
+		return 8
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nImport failed: %s, exiting...\n", err)
+		return 7
+	}
+	return 0
+}
+
+// Describes what was written before records.ErrRecordExists stopped an
+// import. The check before the import refuses it before anything is written,
+// but a record stored by someone else during the import stops it part way.
+func recordExistsAdvice(stats records.ImportStats, written string) string {
+	if stats.Created == 0 && stats.Overwritten == 0 {
+		return "Nothing was " + written + ", use -force to overwrite them"
+	}
+	return fmt.Sprintf("Only %d records were %s before it, use -force to overwrite it and write the rest", stats.Created+stats.Overwritten, written)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/juicebox-systems/juicebox-software-realm/backup"
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+)
+
+func backupRecords(ctx context.Context, realm *realm, args []string) int {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	keysPath := flags.String("keys", "", "A JSON file holding the backup encryption and signing keys.")
+	out := flags.String("out", "", "The snapshot file to write. It must not already exist.")
+	flags.Parse(args)
+
+	if *keysPath == "" || *out == "" {
+		fmt.Fprintf(os.Stderr, "\nMissing -keys or -out, exiting...\n")
+		return 2
+	}
+	keys, err := backup.LoadKeys(*keysPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nFailed to load backup keys: %s, exiting...\n", err)
+		return 4
+	}
+	scanner, ok := realm.records.(records.RecordScanner)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "\n%s, exiting...\n", records.ErrScanUnsupported)
+		return 5
+	}
+
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n%s, exiting...\n", err)
+		return 4
+	}
+
+	count, err := backup.Write(ctx, file, scanner, realm.realmID, keys)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*out)
+		fmt.Fprintf(os.Stderr, "\nBackup failed: %s, exiting...\n", err)
+		return 7
+	}
+
+	fmt.Printf("Backed up %d records to %s.\n", count, *out)
+	return 0
+}
+
+func restoreRecords(ctx context.Context, realm *realm, args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	keysPath := flags.String("keys", "", "A JSON file holding the backup encryption and verifying keys.")
+	in := flags.String("in", "", "The snapshot file to read.")
+	force := flags.Bool("force", false, "Overwrite records that are already stored.")
+	flags.Parse(args)
+
+	if *keysPath == "" || *in == "" {
+		fmt.Fprintf(os.Stderr, "\nMissing -keys or -in, exiting...\n")
+		return 2
+	}
+	keys, err := backup.LoadKeys(*keysPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nFailed to load backup keys: %s, exiting...\n", err)
+		return 4
+	}
+
+	open := func() (io.ReadCloser, error) {
+		return os.Open(*in)
+	}
+	header, stats, err := backup.Restore(ctx, realm.records, realm.realmID, open, keys, *force)
+	if header != nil {
+		fmt.Printf("Snapshot taken %s.\n", header.Created.Format("2006-01-02 15:04:05 MST"))
+	}
+	fmt.Printf("Created %d records, overwrote %d.\n", stats.Created, stats.Overwritten)
+	if errors.Is(err, records.ErrRecordExists) {
+		fmt.Fprintf(os.Stderr, "\n%s. %s, exiting...\n", err, recordExistsAdvice(stats, "restored"))
+		return 8
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nRestore failed: %s, exiting...\n", err)
+		return 7
+	}
+	return 0
+}
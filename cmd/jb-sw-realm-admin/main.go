@@ -0,0 +1,157 @@
+// jb-sw-realm-admin runs maintenance tasks against a realm's record store. It
+// reads the same configuration as jb-sw-realm.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/juicebox-systems/juicebox-software-realm/config"
+	"github.com/juicebox-systems/juicebox-software-realm/providers"
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+)
+
+type command struct {
+	description string
+	run         func(ctx context.Context, realm *realm, args []string) int
+}
+
+var commands = map[string]command{
+	"backup": {
+		description: "Write an encrypted, signed snapshot of every record.",
+		run:         backupRecords,
+	},
+	"export": {
+		description: "Write every record to a portable archive.",
+		run:         exportRecords,
+	},
+	"import": {
+		description: "Write the records in an archive to the record store.",
+		run:         importRecords,
+	},
+	"restore": {
+		description: "Write the records in a snapshot to the record store.",
+		run:         restoreRecords,
+	},
+	"rotate-keys": {
+		description: "Re-encrypt every record with the current key encryption key.",
+		run:         rotateKeys,
+	},
+}
+
+// realm is what the commands operate on.
+type realm struct {
+	cfg     *config.Config
+	realmID types.RealmID
+	records records.RecordStore
+}
+
+func main() {
+	flag.Usage = usage
+	configPath := flag.String(
+		"config",
+		"",
+		`A YAML file to read the realm configuration from.
+
+Environment variables take precedence over the file. See README.md for the
+format.`,
+	)
+	idString := flag.String(
+		"id",
+		"",
+		"A 16-byte hex string identifying this realm. (default stored)",
+	)
+	name := flag.String(
+		"name",
+		"",
+		"The name the realm's id is stored under.",
+	)
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(1)
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(1)
+	}
+
+	if envConfigPath := os.Getenv("CONFIG_FILE"); envConfigPath != "" && *configPath == "" {
+		*configPath = envConfigPath
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n%s, exiting...\n", err)
+		os.Exit(1)
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		fmt.Fprintf(os.Stderr, "\n%s, exiting...\n", err)
+		os.Exit(1)
+	}
+	if *idString != "" {
+		cfg.RealmID = *idString
+	}
+	if *name != "" {
+		cfg.RealmName = *name
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "\nInvalid configuration:\n%s\nexiting...\n", err)
+		os.Exit(2)
+	}
+
+	// Validate has already checked these
+	configuredID, _ := cfg.ParseRealmID()
+	providerNames, _ := cfg.ProviderNames()
+	records.SetWriteSchemaVersion(cfg.Records.WriteSchemaVersion)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, cfg, providerNames, configuredID, cmd, flag.Args()[1:])
+	stop()
+	os.Exit(code)
+}
+
+func run(ctx context.Context, cfg *config.Config, providerNames providers.Names, configuredID *types.RealmID, cmd command, args []string) int {
+	// this only reads the stored id, so that a mistyped name doesn't store
+	// a new one
+	realmID, err := providers.LookupRealmID(ctx, providerNames, cfg.ProviderOptions(), cfg.RealmName, configuredID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n%s, exiting...\n", err)
+		return 3
+	}
+	fmt.Printf("Realm ID: %s\n", realmID)
+
+	recordStore, err := providers.NewRecordStore(ctx, providerNames, cfg.ProviderOptions(), realmID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nFailed to connect to record store: %s, exiting...\n", err)
+		return 6
+	}
+	if closer, ok := recordStore.(interface{ Close() }); ok {
+		defer closer.Close()
+	}
+
+	return cmd.run(ctx, &realm{cfg: cfg, realmID: realmID, records: recordStore}, args)
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] <command> [command flags]\n\nCommands:\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-12s %s\n", name, commands[name].description)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/juicebox-systems/juicebox-software-realm/kms"
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+)
+
+func rotateKeys(ctx context.Context, realm *realm, args []string) int {
+	flags := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	checkpointPath := flags.String(
+		"checkpoint",
+		"rotate-keys.checkpoint",
+		`A file recording how far the rotation has got. If it exists, the
+rotation resumes from where it was interrupted. It's removed once every
+record has been rotated.`,
+	)
+	flags.Parse(args)
+
+	if !realm.cfg.EncryptionEnabled() {
+		fmt.Fprintf(os.Stderr, "\nRecord encryption isn't configured, exiting...\n")
+		return 2
+	}
+	kek, err := kms.NewKMS(realm.cfg.Encryption.KMS, realm.cfg.KMSOptions())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nError initializing kms: %s, exiting...\n", err)
+		return 6
+	}
+	store := records.NewEncryptedRecordStore(realm.records, kek)
+
+	after, err := readCheckpoint(*checkpointPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nError reading checkpoint: %s, exiting...\n", err)
+		return 4
+	}
+	if after != "" {
+		fmt.Printf("Resuming after record %s\n", after)
+	}
+
+	stats, err := records.RotateKeys(ctx, store, after, func(recordID records.UserRecordID) error {
+		return writeCheckpoint(*checkpointPath, recordID)
+	})
+	fmt.Printf("Scanned %d records, rotated %d.\n", stats.Scanned, stats.Rotated)
+	if errors.Is(err, records.ErrScanUnsupported) {
+		fmt.Fprintf(os.Stderr, "\nThe %s record store can't be scanned, exiting...\n", realm.cfg.Providers.Records)
+		return 5
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\nRotation stopped: %s. Run again to resume, exiting...\n", err)
+		return 7
+	}
+
+	if err := os.Remove(*checkpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Error removing checkpoint: %s\n", err)
+	}
+	fmt.Println("Every record uses the current key.")
+	return 0
+}
+
+func readCheckpoint(path string) (records.UserRecordID, error) {
+	contents, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return records.UserRecordID(strings.TrimSpace(string(contents))), nil
+}
+
+// The checkpoint is replaced atomically, so that it's never left half
+// written if the rotation is killed.
+func writeCheckpoint(path string, recordID records.UserRecordID) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(string(recordID) + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -2,12 +2,14 @@ package main
 
 import (
 	"context"
-	"encoding/hex"
 	"flag"
 	"fmt"
 	"os"
-	"strconv"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/juicebox-systems/juicebox-software-realm/config"
 	"github.com/juicebox-systems/juicebox-software-realm/otel"
 	"github.com/juicebox-systems/juicebox-software-realm/pubsub"
 	"github.com/juicebox-systems/juicebox-software-realm/router"
@@ -17,7 +19,15 @@ import (
 
 // / This is a stand-alone service for the tenant log API. It's used by the HSM realm.
 
+// How long to wait for buffered traces and metrics to be exported on exit.
+const telemetryFlushTimeout = 5 * time.Second
+
 func main() {
+	configPath := flag.String(
+		"config",
+		"",
+		"A YAML file to read the realm configuration from.",
+	)
 	idString := flag.String(
 		"id",
 		"",
@@ -30,57 +40,87 @@ func main() {
 	)
 	flag.Parse()
 
-	if envPortString := os.Getenv("PORT"); envPortString != "" && *port == 0 {
-		envPort, err := strconv.ParseUint(envPortString, 10, 64)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "\nInvalid PORT env: %s, exiting...\n", err)
-			os.Exit(1)
-		}
-		*port = envPort
-	}
-	if *port == 0 {
-		*port = 8080
+	if envConfigPath := os.Getenv("CONFIG_FILE"); envConfigPath != "" && *configPath == "" {
+		*configPath = envConfigPath
 	}
-	// if no id was provided, check if the REALM_ID env
-	if envIDString := os.Getenv("REALM_ID"); envIDString != "" && *idString == "" {
-		*idString = envIDString
-	}
-	parsedID, err := hex.DecodeString(*idString)
+	cfg, err := config.Load(*configPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "\n%s, exiting...\n", err)
+		os.Exit(1)
+	}
+	if err := cfg.ApplyEnv(); err != nil {
+		fmt.Fprintf(os.Stderr, "\n%s, exiting...\n", err)
+		os.Exit(1)
+	}
+	if *idString != "" {
+		cfg.RealmID = *idString
+	}
+	if *port != 0 {
+		cfg.Listener.Port = *port
+	}
+	// This service always uses GCP for secrets and pub/sub.
+	cfg.Providers.Default = "gcp"
+	cfg.Providers.Records = "memory"
+	cfg.Providers.Secrets = ""
+	cfg.Providers.PubSub = ""
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "\nInvalid configuration:\n%s\nexiting...\n", err)
 		os.Exit(2)
 	}
-	if len(parsedID) != 16 {
-		fmt.Fprintf(os.Stderr, "\nInvalid realm id length %d, exiting...\n", len(parsedID))
+	parsedID, _ := cfg.ParseRealmID()
+	if parsedID == nil {
+		fmt.Fprintf(os.Stderr, "\nMissing realm id, exiting...\n")
 		os.Exit(3)
 	}
-	realmID := types.RealmID(parsedID)
+	realmID := *parsedID
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, cfg, realmID)
+	stop()
+	os.Exit(code)
+}
 
-	ctx := context.Background()
-	tp := otel.InitTraceProvider(ctx, "tenant-log", realmID)
+// run serves the tenant log API until ctx is cancelled, returning the
+// process exit code once everything has been shut down.
+func run(ctx context.Context, cfg *config.Config, realmID types.RealmID) int {
+	tp := otel.InitTraceProvider(ctx, "tenant-log", realmID, cfg.Telemetry.Endpoint)
 	defer func() {
-		if err := tp.Shutdown(ctx); err != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), telemetryFlushTimeout)
+		defer cancel()
+		if err := tp.Shutdown(shutdownCtx); err != nil {
 			fmt.Fprintf(os.Stderr, "Error shutting down tracer provider: %v\n", err)
 		}
 	}()
 
-	mp := otel.InitMeterProvider(ctx, "tenant-log", realmID)
+	mp := otel.InitMeterProvider(ctx, "tenant-log", realmID, cfg.Telemetry.Endpoint)
 	defer func() {
-		if err := mp.Shutdown(ctx); err != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), telemetryFlushTimeout)
+		defer cancel()
+		if err := mp.Shutdown(shutdownCtx); err != nil {
 			fmt.Fprintf(os.Stderr, "Error shutting down meter provider: %v\n", err)
 		}
 	}()
 
-	secretsManager, err := secrets.NewGcpSecretsManager(ctx)
+	secretsManager, err := secrets.NewGcpSecretsManager(ctx, cfg.Providers.GCP.ProjectID)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error initializing secrets manager:  %v\n", err)
-		os.Exit(6)
+		return 6
 	}
-	pubSub, err := pubsub.NewPubSub(ctx, types.GCP, types.ProviderOptions{}, realmID)
+	defer secretsManager.Close()
+
+	pubSub, err := pubsub.NewPubSub(ctx, types.GCP, cfg.ProviderOptions(), realmID)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error initializing pub/sub connection: %v\n", err)
-		os.Exit(7)
+		return 7
+	}
+	if closer, ok := pubSub.(interface{ Close() }); ok {
+		defer closer.Close()
 	}
+
 	e := router.NewTenantAPIServer(realmID, secretsManager, pubSub)
-	e.Logger.Fatal(e.Start(fmt.Sprintf(":%d", *port)))
+	if err := router.Serve(ctx, e, cfg.Listener.Port, cfg.Listener.DrainTimeout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error running server: %v\n", err)
+		return 8
+	}
+	return 0
 }
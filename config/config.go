@@ -0,0 +1,729 @@
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/juicebox-systems/juicebox-software-realm/kms"
+	"github.com/juicebox-systems/juicebox-software-realm/providers"
+	"github.com/juicebox-systems/juicebox-software-realm/ratelimit"
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+	"github.com/juicebox-systems/juicebox-software-realm/replay"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	gommonBytes "github.com/labstack/gommon/bytes"
+	"gopkg.in/yaml.v3"
+)
+
+// Config describes everything needed to run a realm. It can be read from a
+// YAML file, and environment variables take precedence over the file.
+type Config struct {
+	// A 16-byte hex string identifying this realm.
+	RealmID string `yaml:"realm_id"`
+	// A name for this realm, unique among the realms sharing its record
+	// store provider, that its realm ID is stored under.
+	RealmName  string           `yaml:"realm_name"`
+	Listener   ListenerConfig   `yaml:"listener"`
+	Providers  ProvidersConfig  `yaml:"providers"`
+	Limits     LimitsConfig     `yaml:"limits"`
+	RateLimits RateLimitsConfig `yaml:"rate_limits"`
+	Tokens     TokensConfig     `yaml:"tokens"`
+	Records    RecordsConfig    `yaml:"records"`
+	Encryption EncryptionConfig `yaml:"encryption"`
+	Telemetry  TelemetryConfig  `yaml:"telemetry"`
+}
+
+type ListenerConfig struct {
+	Port uint64 `yaml:"port"`
+	// How long to wait for in-flight requests to finish when shutting down.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+}
+
+type ProvidersConfig struct {
+	// The provider used for anything that doesn't specify its own.
+	Default string `yaml:"default"`
+	Records string `yaml:"records"`
+	Secrets string `yaml:"secrets"`
+	PubSub  string `yaml:"pubsub"`
+
+	GCP      GCPConfig      `yaml:"gcp"`
+	AWS      AWSConfig      `yaml:"aws"`
+	Mongo    MongoConfig    `yaml:"mongo"`
+	Postgres PostgresConfig `yaml:"postgres"`
+	Local    LocalConfig    `yaml:"local"`
+	Redis    RedisConfig    `yaml:"redis"`
+	S3       S3Config       `yaml:"s3"`
+	Vault    VaultConfig    `yaml:"vault"`
+	File     FileConfig     `yaml:"file"`
+	JWKS     JWKSConfig     `yaml:"jwks"`
+
+	// The versioned tenant secrets used by the memory and local providers.
+	TenantSecrets map[string]map[uint64]string `yaml:"tenant_secrets"`
+}
+
+type GCPConfig struct {
+	ProjectID          string `yaml:"project_id"`
+	BigtableInstanceID string `yaml:"bigtable_instance_id"`
+}
+
+type AWSConfig struct {
+	Region string `yaml:"region"`
+	// Don't create the realm's DynamoDB table, for deployments that
+	// provision it themselves and don't grant permission to create it.
+	SkipTableCreation bool `yaml:"skip_table_creation"`
+}
+
+type MongoConfig struct {
+	URL string `yaml:"url"`
+}
+
+type PostgresConfig struct {
+	URL string `yaml:"url"`
+}
+
+type LocalConfig struct {
+	DataPath string `yaml:"data_path"`
+}
+
+type RedisConfig struct {
+	URL string `yaml:"url"`
+}
+
+type S3Config struct {
+	Bucket string `yaml:"bucket"`
+	// The URL of an S3-compatible service, such as MinIO, to use instead of
+	// AWS S3.
+	Endpoint string `yaml:"endpoint"`
+	// Address the bucket in the path rather than the hostname, which most
+	// S3-compatible services need.
+	ForcePathStyle bool `yaml:"force_path_style"`
+}
+
+type VaultConfig struct {
+	Address string `yaml:"address"`
+	// The mount path of the KV v2 secrets engine holding the tenant secrets.
+	Mount string `yaml:"mount"`
+	// Added to the name of each secret to find its path.
+	PathPrefix string          `yaml:"path_prefix"`
+	Auth       VaultAuthConfig `yaml:"auth"`
+}
+
+type FileConfig struct {
+	// A file, or a directory of files, holding tenant secrets in the same
+	// layout as tenant_secrets.
+	Path string `yaml:"path"`
+	// How often to check the files for changes.
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+type JWKSConfig struct {
+	// The URL of each tenant's JSON Web Key Set, keyed by tenant name.
+	Tenants map[string]string `yaml:"tenants"`
+	// The longest a key set is kept before it's fetched again, however long
+	// its cache headers allow.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// Allow key sets to be fetched over plain http, for testing. Anyone on
+	// the network path could substitute their own keys.
+	AllowInsecureHTTP bool `yaml:"allow_insecure_http"`
+}
+
+type VaultAuthConfig struct {
+	// Either "token", "approle" or "kubernetes".
+	Method string `yaml:"method"`
+	// Where the auth method is mounted, if not at its default path.
+	Mount               string `yaml:"mount"`
+	Token               string `yaml:"token"`
+	RoleID              string `yaml:"role_id"`
+	SecretID            string `yaml:"secret_id"`
+	KubernetesRole      string `yaml:"kubernetes_role"`
+	KubernetesTokenPath string `yaml:"kubernetes_token_path"`
+}
+
+type LimitsConfig struct {
+	// The largest request body accepted by /req, such as "2K".
+	RequestBody string `yaml:"request_body"`
+	// How long to keep idle client connections open.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+}
+
+type RateLimitsConfig struct {
+	// Where to count requests, either "memory" or "shared".
+	Backend string `yaml:"backend"`
+	// The limits for tenants that don't have their own.
+	Default RateLimitPolicy `yaml:"default"`
+	// Limits for specific tenants, keyed by tenant name.
+	Tenants map[string]RateLimitPolicy `yaml:"tenants"`
+}
+
+type RateLimitPolicy struct {
+	// Limits the requests across all of a tenant's users.
+	Tenant RateLimit `yaml:"tenant"`
+	// Limits the requests from each of a tenant's users.
+	User RateLimit `yaml:"user"`
+}
+
+// RateLimit allows a number of requests in each window. Zero requests
+// disables the limit.
+type RateLimit struct {
+	Requests uint64        `yaml:"requests"`
+	Window   time.Duration `yaml:"window"`
+}
+
+func (l RateLimit) validate(field string) error {
+	if l.Requests == 0 {
+		return nil
+	}
+	if l.Window < time.Second || l.Window > ratelimit.MaxWindow {
+		return fmt.Errorf("%s.window must be between 1s and %s, got %s", field, ratelimit.MaxWindow, l.Window)
+	}
+	return nil
+}
+
+func (p RateLimitPolicy) toPolicy() ratelimit.Policy {
+	return ratelimit.Policy{
+		Tenant: ratelimit.Limit(p.Tenant),
+		User:   ratelimit.Limit(p.User),
+	}
+}
+
+type TokensConfig struct {
+	// Where to remember used jti's, either "memory" or "records".
+	ReplayStore string `yaml:"replay_store"`
+	// The policy for tenants that don't have their own.
+	Default TokenPolicy `yaml:"default"`
+	// Policies for specific tenants, keyed by tenant name.
+	Tenants map[string]TokenPolicy `yaml:"tenants"`
+}
+
+type TokenPolicy struct {
+	// Require a jti claim, and reject any token whose jti has been used.
+	RequireJTI bool `yaml:"require_jti"`
+	// The longest exp - iat allowed, or zero for no limit.
+	MaxLifetime time.Duration `yaml:"max_lifetime"`
+}
+
+func (p TokenPolicy) validate(field string) error {
+	if p.MaxLifetime < 0 {
+		return fmt.Errorf("%s.max_lifetime must not be negative", field)
+	}
+	return nil
+}
+
+type RecordsConfig struct {
+	// The schema version user records are written with. It should only be
+	// raised once every instance sharing the record store can read the new
+	// version.
+	WriteSchemaVersion uint64 `yaml:"write_schema_version"`
+}
+
+type EncryptionConfig struct {
+	// The KMS holding the keys that user records are encrypted with, or
+	// empty to store records unencrypted.
+	KMS   string         `yaml:"kms"`
+	Local LocalKMSConfig `yaml:"local"`
+}
+
+type LocalKMSConfig struct {
+	// A JSON file mapping each key version to a 32-byte hex key.
+	KeyFile string `yaml:"key_file"`
+}
+
+type TelemetryConfig struct {
+	// An OpenTelemetry gRPC endpoint to export traces and metrics to.
+	Endpoint string `yaml:"endpoint"`
+}
+
+// Default returns the configuration used when no config file is given.
+func Default() *Config {
+	return &Config{
+		Listener: ListenerConfig{
+			Port:         8080,
+			DrainTimeout: 20 * time.Second,
+		},
+		Limits: LimitsConfig{
+			RequestBody: "2K",
+			// This needs to be larger than the 650 second timeout set for NGINX in GCP.
+			IdleTimeout: 11 * time.Minute,
+		},
+	}
+}
+
+// Load reads the config file at path on top of the defaults. An empty path
+// returns the defaults.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	// catch typos rather than silently ignoring them
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// ApplyEnv overrides any values that have been set in the environment.
+func (c *Config) ApplyEnv() error {
+	overrides := []struct {
+		name  string
+		value *string
+	}{
+		{"REALM_ID", &c.RealmID},
+		{"REALM_NAME", &c.RealmName},
+		{"PROVIDER", &c.Providers.Default},
+		{"RECORDS_PROVIDER", &c.Providers.Records},
+		{"SECRETS_PROVIDER", &c.Providers.Secrets},
+		{"PUBSUB_PROVIDER", &c.Providers.PubSub},
+		{"GCP_PROJECT_ID", &c.Providers.GCP.ProjectID},
+		{"BIGTABLE_INSTANCE_ID", &c.Providers.GCP.BigtableInstanceID},
+		{"AWS_REGION_NAME", &c.Providers.AWS.Region},
+		{"MONGO_URL", &c.Providers.Mongo.URL},
+		{"POSTGRES_URL", &c.Providers.Postgres.URL},
+		{"DATA_PATH", &c.Providers.Local.DataPath},
+		{"REDIS_URL", &c.Providers.Redis.URL},
+		{"S3_BUCKET", &c.Providers.S3.Bucket},
+		{"S3_ENDPOINT", &c.Providers.S3.Endpoint},
+		{"TENANT_SECRETS_PATH", &c.Providers.File.Path},
+		{"VAULT_ADDR", &c.Providers.Vault.Address},
+		{"VAULT_KV_MOUNT", &c.Providers.Vault.Mount},
+		{"VAULT_PATH_PREFIX", &c.Providers.Vault.PathPrefix},
+		{"VAULT_AUTH_METHOD", &c.Providers.Vault.Auth.Method},
+		{"VAULT_AUTH_MOUNT", &c.Providers.Vault.Auth.Mount},
+		{"VAULT_TOKEN", &c.Providers.Vault.Auth.Token},
+		{"VAULT_ROLE_ID", &c.Providers.Vault.Auth.RoleID},
+		{"VAULT_SECRET_ID", &c.Providers.Vault.Auth.SecretID},
+		{"VAULT_KUBERNETES_ROLE", &c.Providers.Vault.Auth.KubernetesRole},
+		{"VAULT_KUBERNETES_TOKEN_PATH", &c.Providers.Vault.Auth.KubernetesTokenPath},
+		{"RECORDS_KMS", &c.Encryption.KMS},
+		{"RECORDS_KEY_FILE", &c.Encryption.Local.KeyFile},
+		{"OPENTELEMETRY_ENDPOINT", &c.Telemetry.Endpoint},
+	}
+	for _, o := range overrides {
+		if env := os.Getenv(o.name); env != "" {
+			*o.value = env
+		}
+	}
+
+	if env := os.Getenv("PORT"); env != "" {
+		port, err := strconv.ParseUint(env, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid PORT env: %w", err)
+		}
+		c.Listener.Port = port
+	}
+
+	if env := os.Getenv("DRAIN_TIMEOUT"); env != "" {
+		drainTimeout, err := time.ParseDuration(env)
+		if err != nil {
+			return fmt.Errorf("invalid DRAIN_TIMEOUT env: %w", err)
+		}
+		c.Listener.DrainTimeout = drainTimeout
+	}
+
+	if env := os.Getenv("TENANT_SECRETS_RELOAD_INTERVAL"); env != "" {
+		reloadInterval, err := time.ParseDuration(env)
+		if err != nil {
+			return fmt.Errorf("invalid TENANT_SECRETS_RELOAD_INTERVAL env: %w", err)
+		}
+		c.Providers.File.ReloadInterval = reloadInterval
+	}
+
+	if env := os.Getenv("JWKS_REFRESH_INTERVAL"); env != "" {
+		refreshInterval, err := time.ParseDuration(env)
+		if err != nil {
+			return fmt.Errorf("invalid JWKS_REFRESH_INTERVAL env: %w", err)
+		}
+		c.Providers.JWKS.RefreshInterval = refreshInterval
+	}
+
+	if env := os.Getenv("JWKS_ALLOW_INSECURE_HTTP"); env != "" {
+		allow, err := strconv.ParseBool(env)
+		if err != nil {
+			return fmt.Errorf("invalid JWKS_ALLOW_INSECURE_HTTP env: %w", err)
+		}
+		c.Providers.JWKS.AllowInsecureHTTP = allow
+	}
+
+	if env := os.Getenv("AWS_SKIP_TABLE_CREATION"); env != "" {
+		skip, err := strconv.ParseBool(env)
+		if err != nil {
+			return fmt.Errorf("invalid AWS_SKIP_TABLE_CREATION env: %w", err)
+		}
+		c.Providers.AWS.SkipTableCreation = skip
+	}
+
+	if env := os.Getenv("S3_FORCE_PATH_STYLE"); env != "" {
+		forcePathStyle, err := strconv.ParseBool(env)
+		if err != nil {
+			return fmt.Errorf("invalid S3_FORCE_PATH_STYLE env: %w", err)
+		}
+		c.Providers.S3.ForcePathStyle = forcePathStyle
+	}
+
+	if env := os.Getenv("RECORDS_WRITE_SCHEMA_VERSION"); env != "" {
+		version, err := strconv.ParseUint(env, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid RECORDS_WRITE_SCHEMA_VERSION env: %w", err)
+		}
+		c.Records.WriteSchemaVersion = version
+	}
+
+	if env := os.Getenv("TENANT_SECRETS"); env != "" {
+		var tenantSecrets map[string]map[uint64]string
+		if err := json.Unmarshal([]byte(env), &tenantSecrets); err != nil {
+			return fmt.Errorf("invalid TENANT_SECRETS env: %w", err)
+		}
+		c.Providers.TenantSecrets = tenantSecrets
+	}
+
+	if env := os.Getenv("JWKS_URLS"); env != "" {
+		var urls map[string]string
+		if err := json.Unmarshal([]byte(env), &urls); err != nil {
+			return fmt.Errorf("invalid JWKS_URLS env: %w", err)
+		}
+		c.Providers.JWKS.Tenants = urls
+	}
+
+	return nil
+}
+
+// Validate checks that the config is complete and consistent, returning an
+// error describing every problem found.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, err := c.ParseRealmID(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.RealmName != "" && !realmNameRegex.MatchString(c.RealmName) {
+		errs = append(errs, fmt.Errorf("realm_name must be 1-64 letters, digits, hyphens or underscores, got %q", c.RealmName))
+	}
+
+	if c.Listener.Port == 0 || c.Listener.Port > 65535 {
+		errs = append(errs, fmt.Errorf("listener.port must be between 1 and 65535, got %d", c.Listener.Port))
+	}
+	if c.Listener.DrainTimeout < 0 {
+		errs = append(errs, errors.New("listener.drain_timeout must not be negative"))
+	}
+
+	if _, err := gommonBytes.Parse(c.Limits.RequestBody); err != nil {
+		errs = append(errs, fmt.Errorf("limits.request_body is invalid: %w", err))
+	}
+	if c.Limits.IdleTimeout < 0 {
+		errs = append(errs, errors.New("limits.idle_timeout must not be negative"))
+	}
+
+	if _, err := ratelimit.ParseBackend(c.RateLimits.Backend); err != nil {
+		errs = append(errs, fmt.Errorf("rate_limits.backend: %w", err))
+	}
+	errs = append(errs, c.RateLimits.Default.Tenant.validate("rate_limits.default.tenant"))
+	errs = append(errs, c.RateLimits.Default.User.validate("rate_limits.default.user"))
+	for _, tenant := range sortedKeys(c.RateLimits.Tenants) {
+		policy := c.RateLimits.Tenants[tenant]
+		errs = append(errs, policy.Tenant.validate("rate_limits.tenants."+tenant+".tenant"))
+		errs = append(errs, policy.User.validate("rate_limits.tenants."+tenant+".user"))
+	}
+
+	switch c.Tokens.ReplayStore {
+	case "", "memory", "records":
+	default:
+		errs = append(errs, fmt.Errorf("tokens.replay_store must be memory or records, got %s", c.Tokens.ReplayStore))
+	}
+	errs = append(errs, c.Tokens.Default.validate("tokens.default"))
+	for _, tenant := range sortedKeys(c.Tokens.Tenants) {
+		errs = append(errs, c.Tokens.Tenants[tenant].validate("tokens.tenants."+tenant))
+	}
+
+	if c.Records.WriteSchemaVersion > records.SchemaVersion {
+		errs = append(errs, fmt.Errorf("records.write_schema_version must be at most %d, got %d", records.SchemaVersion, c.Records.WriteSchemaVersion))
+	}
+
+	switch c.Encryption.KMS {
+	case "":
+	case kms.Local:
+		if c.Encryption.Local.KeyFile == "" {
+			errs = append(errs, errors.New("encryption.local.key_file (or RECORDS_KEY_FILE) is required when using the local kms"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("encryption.kms must be local, got %s", c.Encryption.KMS))
+	}
+
+	names, err := c.ProviderNames()
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		errs = append(errs, c.Providers.validate(names)...)
+		if backend, err := ratelimit.ParseBackend(c.RateLimits.Backend); err == nil && backend == ratelimit.Shared && !ratelimit.SharedSupported(names.RecordStore) {
+			errs = append(errs, fmt.Errorf("rate_limits.backend shared isn't supported when storing records with the %s provider", names.RecordStore))
+		}
+		if c.Tokens.ReplayStore == "records" && !replay.SharedSupported(names.RecordStore) {
+			errs = append(errs, fmt.Errorf("tokens.replay_store records isn't supported when storing records with the %s provider", names.RecordStore))
+		}
+		// without either, a realm sharing the provider with others can't
+		// find its records again
+		if c.RealmID == "" && !records.RealmIDOptional(names.RecordStore, c.RealmName) {
+			errs = append(errs, fmt.Errorf("realm_id (or REALM_ID) or realm_name (or REALM_NAME) is required when storing records with the %s provider", names.RecordStore))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (p *ProvidersConfig) validate(names providers.Names) []error {
+	var errs []error
+	require := func(value string, field string, env string, provider string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("providers.%s (or %s) is required when using the %s provider", field, env, provider))
+		}
+	}
+
+	uses := func(name types.ProviderName) bool {
+		return names.RecordStore == name || names.SecretsManager == name || names.PubSub == name
+	}
+
+	if uses(types.GCP) {
+		require(p.GCP.ProjectID, "gcp.project_id", "GCP_PROJECT_ID", "gcp")
+	}
+	if names.RecordStore == types.GCP {
+		require(p.GCP.BigtableInstanceID, "gcp.bigtable_instance_id", "BIGTABLE_INSTANCE_ID", "gcp")
+	}
+	if uses(types.AWS) {
+		require(p.AWS.Region, "aws.region", "AWS_REGION_NAME", "aws")
+	}
+	if names.RecordStore == types.S3 {
+		require(p.AWS.Region, "aws.region", "AWS_REGION_NAME", "s3")
+		require(p.S3.Bucket, "s3.bucket", "S3_BUCKET", "s3")
+	}
+	if uses(types.Mongo) {
+		require(p.Mongo.URL, "mongo.url", "MONGO_URL", "mongo")
+	}
+	if uses(types.Postgres) {
+		require(p.Postgres.URL, "postgres.url", "POSTGRES_URL", "postgres")
+	}
+	if names.RecordStore == types.Local {
+		require(p.Local.DataPath, "local.data_path", "DATA_PATH", "local")
+	}
+	if names.RecordStore == types.Redis {
+		require(p.Redis.URL, "redis.url", "REDIS_URL", "redis")
+	}
+	if names.SecretsManager == types.Vault {
+		require(p.Vault.Address, "vault.address", "VAULT_ADDR", "vault")
+		switch p.Vault.Auth.Method {
+		case "", "token":
+			require(p.Vault.Auth.Token, "vault.auth.token", "VAULT_TOKEN", "vault")
+		case "approle":
+			require(p.Vault.Auth.RoleID, "vault.auth.role_id", "VAULT_ROLE_ID", "vault")
+			require(p.Vault.Auth.SecretID, "vault.auth.secret_id", "VAULT_SECRET_ID", "vault")
+		case "kubernetes":
+			require(p.Vault.Auth.KubernetesRole, "vault.auth.kubernetes_role", "VAULT_KUBERNETES_ROLE", "vault")
+		default:
+			errs = append(errs, fmt.Errorf("providers.vault.auth.method must be token, approle or kubernetes, got %s", p.Vault.Auth.Method))
+		}
+	}
+	if names.SecretsManager == types.File {
+		require(p.File.Path, "file.path", "TENANT_SECRETS_PATH", "file")
+		if p.File.ReloadInterval < 0 {
+			errs = append(errs, fmt.Errorf("providers.file.reload_interval must not be negative, got %s", p.File.ReloadInterval))
+		}
+	}
+	if names.SecretsManager == types.JWKS {
+		if len(p.JWKS.Tenants) == 0 {
+			errs = append(errs, errors.New("providers.jwks.tenants (or JWKS_URLS) is required when using the jwks provider"))
+		}
+		tenants := make([]string, 0, len(p.JWKS.Tenants))
+		for tenant := range p.JWKS.Tenants {
+			tenants = append(tenants, tenant)
+		}
+		sort.Strings(tenants)
+		for _, tenant := range tenants {
+			u, err := url.Parse(p.JWKS.Tenants[tenant])
+			if p.JWKS.AllowInsecureHTTP {
+				if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+					errs = append(errs, fmt.Errorf("providers.jwks.tenants.%s must be an http or https url, got %q", tenant, p.JWKS.Tenants[tenant]))
+				}
+			} else if err != nil || u.Scheme != "https" || u.Host == "" {
+				errs = append(errs, fmt.Errorf("providers.jwks.tenants.%s must be an https url, got %q", tenant, p.JWKS.Tenants[tenant]))
+			}
+		}
+		if p.JWKS.RefreshInterval < 0 {
+			errs = append(errs, fmt.Errorf("providers.jwks.refresh_interval must not be negative, got %s", p.JWKS.RefreshInterval))
+		}
+	}
+	// these only store secrets, the records and pub/sub need another provider
+	for _, name := range []types.ProviderName{types.Vault, types.File, types.JWKS} {
+		if names.RecordStore == name {
+			errs = append(errs, fmt.Errorf("providers.records must be set to another provider when using the %s provider", name))
+		}
+		if names.PubSub == name {
+			errs = append(errs, fmt.Errorf("providers.pubsub must be set to another provider when using the %s provider", name))
+		}
+	}
+	// these only store records, the secrets and pub/sub need another provider
+	for _, name := range []types.ProviderName{types.Redis, types.S3} {
+		if names.SecretsManager == name {
+			errs = append(errs, fmt.Errorf("providers.secrets must be set to another provider when using the %s provider", name))
+		}
+		if names.PubSub == name {
+			errs = append(errs, fmt.Errorf("providers.pubsub must be set to another provider when using the %s provider", name))
+		}
+	}
+	if (names.SecretsManager == types.Memory || names.SecretsManager == types.Local) && len(p.TenantSecrets) == 0 {
+		errs = append(errs, errors.New("providers.tenant_secrets (or TENANT_SECRETS) is required when reading secrets from the memory or local provider"))
+	}
+
+	return errs
+}
+
+var realmNameRegex = regexp.MustCompile("^[a-zA-Z0-9_-]{1,64}$")
+
+// ParseRealmID returns the configured realm ID, or nil if there isn't one.
+func (c *Config) ParseRealmID() (*types.RealmID, error) {
+	if c.RealmID == "" {
+		return nil, nil
+	}
+	parsedID, err := hex.DecodeString(c.RealmID)
+	if err != nil {
+		return nil, fmt.Errorf("realm_id is not valid hex: %w", err)
+	}
+	if len(parsedID) != 16 {
+		return nil, fmt.Errorf("realm_id must be 16 bytes, got %d", len(parsedID))
+	}
+	realmID := types.RealmID(parsedID)
+	return &realmID, nil
+}
+
+// ProviderNames returns the provider selected for each part of the realm.
+func (c *Config) ProviderNames() (providers.Names, error) {
+	var names providers.Names
+
+	fallback := types.Memory
+	if c.Providers.Default != "" {
+		name, err := providers.Parse(c.Providers.Default)
+		if err != nil {
+			return names, fmt.Errorf("providers.default: %w", err)
+		}
+		fallback = name
+	}
+
+	parse := func(field string, value string) (types.ProviderName, error) {
+		if value == "" {
+			return fallback, nil
+		}
+		name, err := providers.Parse(value)
+		if err != nil {
+			return name, fmt.Errorf("providers.%s: %w", field, err)
+		}
+		return name, nil
+	}
+
+	var err error
+	if names.RecordStore, err = parse("records", c.Providers.Records); err != nil {
+		return names, err
+	}
+	if names.SecretsManager, err = parse("secrets", c.Providers.Secrets); err != nil {
+		return names, err
+	}
+	if names.PubSub, err = parse("pubsub", c.Providers.PubSub); err != nil {
+		return names, err
+	}
+	return names, nil
+}
+
+// ProviderOptions returns the settings the providers need to connect.
+func (c *Config) ProviderOptions() types.ProviderOptions {
+	return types.ProviderOptions{
+		GcpProjectID:                c.Providers.GCP.ProjectID,
+		BigtableInstanceID:          c.Providers.GCP.BigtableInstanceID,
+		AwsRegion:                   c.Providers.AWS.Region,
+		AwsSkipTableCreation:        c.Providers.AWS.SkipTableCreation,
+		MongoURL:                    c.Providers.Mongo.URL,
+		PostgresURL:                 c.Providers.Postgres.URL,
+		RedisURL:                    c.Providers.Redis.URL,
+		S3Bucket:                    c.Providers.S3.Bucket,
+		S3Endpoint:                  c.Providers.S3.Endpoint,
+		S3ForcePathStyle:            c.Providers.S3.ForcePathStyle,
+		DataPath:                    c.Providers.Local.DataPath,
+		TenantSecrets:               c.Providers.TenantSecrets,
+		TenantSecretsPath:           c.Providers.File.Path,
+		TenantSecretsReloadInterval: c.Providers.File.ReloadInterval,
+		JWKSURLs:                    c.Providers.JWKS.Tenants,
+		JWKSRefreshInterval:         c.Providers.JWKS.RefreshInterval,
+		JWKSAllowInsecureHTTP:       c.Providers.JWKS.AllowInsecureHTTP,
+		Vault: types.VaultOptions{
+			Address:             c.Providers.Vault.Address,
+			Mount:               c.Providers.Vault.Mount,
+			PathPrefix:          c.Providers.Vault.PathPrefix,
+			AuthMethod:          c.Providers.Vault.Auth.Method,
+			AuthMount:           c.Providers.Vault.Auth.Mount,
+			Token:               c.Providers.Vault.Auth.Token,
+			RoleID:              c.Providers.Vault.Auth.RoleID,
+			SecretID:            c.Providers.Vault.Auth.SecretID,
+			KubernetesRole:      c.Providers.Vault.Auth.KubernetesRole,
+			KubernetesTokenPath: c.Providers.Vault.Auth.KubernetesTokenPath,
+		},
+	}
+}
+
+// RateLimitsEnabled reports whether any rate limit has been configured.
+func (c *Config) RateLimitsEnabled() bool {
+	return c.RateLimits.Default != (RateLimitPolicy{}) || len(c.RateLimits.Tenants) > 0
+}
+
+// RateLimitPolicies returns the fallback policy and the per tenant policies.
+func (c *Config) RateLimitPolicies() (ratelimit.Policy, map[string]ratelimit.Policy) {
+	tenants := make(map[string]ratelimit.Policy, len(c.RateLimits.Tenants))
+	for tenant, policy := range c.RateLimits.Tenants {
+		tenants[tenant] = policy.toPolicy()
+	}
+	return c.RateLimits.Default.toPolicy(), tenants
+}
+
+// TokenPoliciesEnabled reports whether any token policy has been configured.
+func (c *Config) TokenPoliciesEnabled() bool {
+	return c.Tokens.Default != (TokenPolicy{}) || len(c.Tokens.Tenants) > 0
+}
+
+// TokenPolicies returns the fallback policy and the per tenant policies.
+func (c *Config) TokenPolicies() (replay.Policy, map[string]replay.Policy) {
+	tenants := make(map[string]replay.Policy, len(c.Tokens.Tenants))
+	for tenant, policy := range c.Tokens.Tenants {
+		tenants[tenant] = replay.Policy(policy)
+	}
+	return replay.Policy(c.Tokens.Default), tenants
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// EncryptionEnabled reports whether user records should be encrypted.
+func (c *Config) EncryptionEnabled() bool {
+	return c.Encryption.KMS != ""
+}
+
+// KMSOptions returns the options used to create the configured KMS.
+func (c *Config) KMSOptions() kms.Options {
+	return kms.Options{KeyFile: c.Encryption.Local.KeyFile}
+}
@@ -0,0 +1,300 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juicebox-systems/juicebox-software-realm/ratelimit"
+	"github.com/juicebox-systems/juicebox-software-realm/replay"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "realm.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+realm_id: 0102030405060708090a0b0c0d0e0f10
+listener:
+  port: 9000
+providers:
+  default: postgres
+  secrets: memory
+  postgres:
+    url: postgres://localhost/realm
+  tenant_secrets:
+    acme:
+      1: acme-tenant-key
+limits:
+  request_body: 4K
+telemetry:
+  endpoint: localhost:4317
+`)
+
+	cfg, err := Load(path)
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.Validate())
+
+	assert.Equal(t, uint64(9000), cfg.Listener.Port)
+	assert.Equal(t, "4K", cfg.Limits.RequestBody)
+	// unset values keep their defaults
+	assert.Equal(t, 11*time.Minute, cfg.Limits.IdleTimeout)
+	assert.Equal(t, "localhost:4317", cfg.Telemetry.Endpoint)
+
+	realmID, err := cfg.ParseRealmID()
+	assert.NoError(t, err)
+	assert.Equal(t, "0102030405060708090a0b0c0d0e0f10", realmID.String())
+
+	names, err := cfg.ProviderNames()
+	assert.NoError(t, err)
+	assert.Equal(t, types.Postgres, names.RecordStore)
+	assert.Equal(t, types.Memory, names.SecretsManager)
+	assert.Equal(t, types.Postgres, names.PubSub)
+
+	opts := cfg.ProviderOptions()
+	assert.Equal(t, "postgres://localhost/realm", opts.PostgresURL)
+	assert.Equal(t, "acme-tenant-key", opts.TenantSecrets["acme"][1])
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	path := writeConfig(t, "listner:\n  port: 9000\n")
+	_, err := Load(path)
+	assert.ErrorContains(t, err, "field listner not found")
+}
+
+func TestApplyEnv(t *testing.T) {
+	path := writeConfig(t, `
+providers:
+  default: mongo
+  mongo:
+    url: mongodb://file
+`)
+	t.Setenv("MONGO_URL", "mongodb://env")
+	t.Setenv("REALM_NAME", "acme-prod")
+	t.Setenv("PORT", "9001")
+	t.Setenv("DRAIN_TIMEOUT", "5s")
+	t.Setenv("TENANT_SECRETS", `{"acme":{"1":"acme-tenant-key"}}`)
+	t.Setenv("RECORDS_WRITE_SCHEMA_VERSION", "1")
+	t.Setenv("AWS_SKIP_TABLE_CREATION", "true")
+	t.Setenv("S3_FORCE_PATH_STYLE", "true")
+	t.Setenv("VAULT_AUTH_METHOD", "approle")
+	t.Setenv("TENANT_SECRETS_RELOAD_INTERVAL", "1m")
+	t.Setenv("JWKS_URLS", `{"acme":"https://acme.example/.well-known/jwks.json"}`)
+	t.Setenv("JWKS_REFRESH_INTERVAL", "5m")
+	t.Setenv("JWKS_ALLOW_INSECURE_HTTP", "true")
+
+	cfg, err := Load(path)
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.ApplyEnv())
+
+	assert.Equal(t, "mongodb://env", cfg.Providers.Mongo.URL)
+	assert.Equal(t, "acme-prod", cfg.RealmName)
+	assert.Equal(t, uint64(9001), cfg.Listener.Port)
+	assert.Equal(t, 5*time.Second, cfg.Listener.DrainTimeout)
+	assert.Equal(t, "acme-tenant-key", cfg.Providers.TenantSecrets["acme"][1])
+	assert.Equal(t, uint64(1), cfg.Records.WriteSchemaVersion)
+	assert.True(t, cfg.ProviderOptions().AwsSkipTableCreation)
+	assert.True(t, cfg.ProviderOptions().S3ForcePathStyle)
+	assert.Equal(t, "approle", cfg.ProviderOptions().Vault.AuthMethod)
+	assert.Equal(t, time.Minute, cfg.ProviderOptions().TenantSecretsReloadInterval)
+	assert.Equal(t, "https://acme.example/.well-known/jwks.json", cfg.ProviderOptions().JWKSURLs["acme"])
+	assert.Equal(t, 5*time.Minute, cfg.ProviderOptions().JWKSRefreshInterval)
+	assert.True(t, cfg.ProviderOptions().JWKSAllowInsecureHTTP)
+	assert.NoError(t, cfg.Validate())
+
+	t.Setenv("PORT", "http")
+	assert.ErrorContains(t, cfg.ApplyEnv(), "invalid PORT env")
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.RealmID = "abcd"
+	cfg.Listener.Port = 70000
+	cfg.Limits.RequestBody = "lots"
+	cfg.Providers.Records = "gcp"
+	cfg.Providers.Secrets = "aws"
+	cfg.Providers.PubSub = "carrier-pigeon"
+
+	assert.EqualError(t, cfg.Validate(), `realm_id must be 16 bytes, got 2
+listener.port must be between 1 and 65535, got 70000
+limits.request_body is invalid: error parsing value=lots
+providers.pubsub: invalid ProviderName: carrier-pigeon`)
+
+	cfg.Providers.PubSub = ""
+	err := cfg.Validate()
+	assert.ErrorContains(t, err, "providers.gcp.project_id (or GCP_PROJECT_ID) is required when using the gcp provider")
+	assert.ErrorContains(t, err, "providers.gcp.bigtable_instance_id (or BIGTABLE_INSTANCE_ID) is required when using the gcp provider")
+	assert.ErrorContains(t, err, "providers.aws.region (or AWS_REGION_NAME) is required when using the aws provider")
+
+	cfg.Providers.Secrets = "memory"
+	assert.ErrorContains(t, cfg.Validate(), "providers.tenant_secrets (or TENANT_SECRETS) is required when reading secrets from the memory or local provider")
+
+	cfg.Encryption.KMS = "local"
+	assert.ErrorContains(t, cfg.Validate(), "encryption.local.key_file (or RECORDS_KEY_FILE) is required when using the local kms")
+	cfg.Encryption.KMS = "vault"
+	assert.ErrorContains(t, cfg.Validate(), "encryption.kms must be local, got vault")
+
+	cfg.Encryption.KMS = ""
+	cfg.Providers.Default = "redis"
+	cfg.Providers.Records = ""
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "providers.redis.url (or REDIS_URL) is required when using the redis provider")
+	assert.ErrorContains(t, err, "providers.pubsub must be set to another provider when using the redis provider")
+	assert.NotContains(t, err.Error(), "providers.secrets must be set")
+	cfg.Providers.Secrets = "redis"
+	assert.ErrorContains(t, cfg.Validate(), "providers.secrets must be set to another provider when using the redis provider")
+
+	cfg.Providers.Default = "memory"
+	cfg.Providers.Records = "s3"
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "providers.aws.region (or AWS_REGION_NAME) is required when using the s3 provider")
+	assert.ErrorContains(t, err, "providers.s3.bucket (or S3_BUCKET) is required when using the s3 provider")
+
+	cfg.Providers.Default = "vault"
+	cfg.Providers.Records = "memory"
+	cfg.Providers.Secrets = ""
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "providers.vault.address (or VAULT_ADDR) is required when using the vault provider")
+	assert.ErrorContains(t, err, "providers.vault.auth.token (or VAULT_TOKEN) is required when using the vault provider")
+	assert.ErrorContains(t, err, "providers.pubsub must be set to another provider when using the vault provider")
+	cfg.Providers.Vault.Auth.Method = "approle"
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "providers.vault.auth.role_id (or VAULT_ROLE_ID) is required when using the vault provider")
+	assert.ErrorContains(t, err, "providers.vault.auth.secret_id (or VAULT_SECRET_ID) is required when using the vault provider")
+	cfg.Providers.Vault.Auth.Method = "kubernetes"
+	assert.ErrorContains(t, cfg.Validate(), "providers.vault.auth.kubernetes_role (or VAULT_KUBERNETES_ROLE) is required when using the vault provider")
+	cfg.Providers.Vault.Auth.Method = "ldap"
+	assert.ErrorContains(t, cfg.Validate(), "providers.vault.auth.method must be token, approle or kubernetes, got ldap")
+
+	cfg.Providers.Default = "memory"
+	cfg.Providers.Secrets = "file"
+	cfg.Providers.PubSub = "file"
+	cfg.Providers.File.ReloadInterval = -time.Second
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "providers.file.path (or TENANT_SECRETS_PATH) is required when using the file provider")
+	assert.ErrorContains(t, err, "providers.file.reload_interval must not be negative, got -1s")
+	assert.ErrorContains(t, err, "providers.pubsub must be set to another provider when using the file provider")
+	cfg.Providers.PubSub = ""
+
+	cfg.Providers.Secrets = "jwks"
+	cfg.Providers.Records = "jwks"
+	cfg.Providers.JWKS.RefreshInterval = -time.Second
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "providers.jwks.tenants (or JWKS_URLS) is required when using the jwks provider")
+	assert.ErrorContains(t, err, "providers.jwks.refresh_interval must not be negative, got -1s")
+	assert.ErrorContains(t, err, "providers.records must be set to another provider when using the jwks provider")
+	cfg.Providers.Records = "memory"
+	cfg.Providers.JWKS.Tenants = map[string]string{"acme": "acme.example/jwks.json"}
+	assert.ErrorContains(t, cfg.Validate(), `providers.jwks.tenants.acme must be an https url, got "acme.example/jwks.json"`)
+	cfg.Providers.JWKS.Tenants = map[string]string{"acme": "http://acme.example/jwks.json"}
+	assert.ErrorContains(t, cfg.Validate(), `providers.jwks.tenants.acme must be an https url, got "http://acme.example/jwks.json"`)
+	cfg.Providers.JWKS.AllowInsecureHTTP = true
+	assert.NotContains(t, cfg.Validate().Error(), "providers.jwks.tenants.acme")
+	cfg.Providers.JWKS.Tenants = map[string]string{"acme": "acme.example/jwks.json"}
+	assert.ErrorContains(t, cfg.Validate(), `providers.jwks.tenants.acme must be an http or https url, got "acme.example/jwks.json"`)
+	cfg.Providers.Secrets = "memory"
+
+	cfg.Records.WriteSchemaVersion = 2
+	assert.ErrorContains(t, cfg.Validate(), "records.write_schema_version must be at most 1, got 2")
+	cfg.Records.WriteSchemaVersion = 0
+
+	// realms sharing a provider need an id or a name to find their records
+	cfg.RealmID = ""
+	cfg.Providers.Records = "postgres"
+	cfg.Providers.Postgres.URL = "postgres://localhost/realm"
+	assert.ErrorContains(t, cfg.Validate(), "realm_id (or REALM_ID) or realm_name (or REALM_NAME) is required when storing records with the postgres provider")
+	cfg.RealmName = "acme/prod"
+	assert.ErrorContains(t, cfg.Validate(), `realm_name must be 1-64 letters, digits, hyphens or underscores, got "acme/prod"`)
+	cfg.RealmName = "acme-prod"
+	assert.NotContains(t, cfg.Validate().Error(), "realm_")
+}
+
+func TestRateLimits(t *testing.T) {
+	path := writeConfig(t, `
+providers:
+  tenant_secrets:
+    acme:
+      1: acme-tenant-key
+rate_limits:
+  backend: shared
+  default:
+    user:
+      requests: 10
+      window: 1m
+  tenants:
+    acme:
+      tenant:
+        requests: 1000
+        window: 1s
+`)
+	cfg, err := Load(path)
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.Validate())
+	assert.True(t, cfg.RateLimitsEnabled())
+
+	fallback, tenants := cfg.RateLimitPolicies()
+	assert.Equal(t, ratelimit.Policy{User: ratelimit.Limit{Requests: 10, Window: time.Minute}}, fallback)
+	assert.Equal(t, map[string]ratelimit.Policy{
+		"acme": {Tenant: ratelimit.Limit{Requests: 1000, Window: time.Second}},
+	}, tenants)
+
+	cfg.RateLimits.Backend = "redis"
+	cfg.RateLimits.Tenants["acme"] = RateLimitPolicy{User: RateLimit{Requests: 5, Window: 48 * time.Hour}}
+	assert.EqualError(t, cfg.Validate(), `rate_limits.backend: invalid rate limit backend: redis
+rate_limits.tenants.acme.user.window must be between 1s and 24h0m0s, got 48h0m0s`)
+
+	cfg.RateLimits.Backend = "shared"
+	cfg.RateLimits.Tenants = nil
+	cfg.RealmID = "0102030405060708090a0b0c0d0e0f10"
+	cfg.Providers.Records = "s3"
+	cfg.Providers.AWS.Region = "us-west-2"
+	cfg.Providers.S3.Bucket = "realm-records"
+	assert.EqualError(t, cfg.Validate(), "rate_limits.backend shared isn't supported when storing records with the s3 provider")
+
+	assert.False(t, Default().RateLimitsEnabled())
+}
+
+func TestTokens(t *testing.T) {
+	path := writeConfig(t, `
+providers:
+  tenant_secrets:
+    acme:
+      1: acme-tenant-key
+tokens:
+  replay_store: records
+  default:
+    max_lifetime: 10m
+  tenants:
+    acme:
+      require_jti: true
+      max_lifetime: 1m
+`)
+	cfg, err := Load(path)
+	assert.NoError(t, err)
+	assert.NoError(t, cfg.Validate())
+	assert.True(t, cfg.TokenPoliciesEnabled())
+
+	fallback, tenants := cfg.TokenPolicies()
+	assert.Equal(t, replay.Policy{MaxLifetime: 10 * time.Minute}, fallback)
+	assert.Equal(t, map[string]replay.Policy{
+		"acme": {RequireJTI: true, MaxLifetime: time.Minute},
+	}, tenants)
+
+	cfg.Tokens.ReplayStore = "redis"
+	assert.EqualError(t, cfg.Validate(), "tokens.replay_store must be memory or records, got redis")
+
+	cfg.Tokens.ReplayStore = "records"
+	cfg.RealmID = "0102030405060708090a0b0c0d0e0f10"
+	cfg.Providers.Records = "s3"
+	cfg.Providers.AWS.Region = "us-west-2"
+	cfg.Providers.S3.Bucket = "realm-records"
+	assert.EqualError(t, cfg.Validate(), "tokens.replay_store records isn't supported when storing records with the s3 provider")
+
+	assert.False(t, Default().TokenPoliciesEnabled())
+}
@@ -0,0 +1,47 @@
+// Package kms wraps the data keys used to encrypt user records at rest with
+// key encryption keys (KEKs) held by a key management service.
+package kms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// KeyVersion identifies the KEK a data key was wrapped with, so that records
+// wrapped with an older KEK can still be read after it has been rotated.
+type KeyVersion uint32
+
+// ErrUnknownKeyVersion is returned when unwrapping a data key with a KEK that
+// the KMS doesn't have.
+var ErrUnknownKeyVersion = errors.New("unknown key encryption key version")
+
+// KMS represents a generic interface into the key management service of
+// your choice.
+type KMS interface {
+	// Returns the version of the KEK that WrapKey currently uses.
+	CurrentVersion(ctx context.Context) (KeyVersion, error)
+	// Encrypts dataKey with the current KEK. The same associatedData must
+	// be given to UnwrapKey.
+	WrapKey(ctx context.Context, dataKey []byte, associatedData []byte) (KeyVersion, []byte, error)
+	// Decrypts a data key previously returned by WrapKey.
+	UnwrapKey(ctx context.Context, version KeyVersion, wrappedKey []byte, associatedData []byte) ([]byte, error)
+}
+
+// Names of the supported KMS's.
+const Local = "local"
+
+// Options holds the configuration used by each KMS.
+type Options struct {
+	// The file LocalKMS reads its KEKs from.
+	KeyFile string
+}
+
+// NewKMS returns the KMS with the given name.
+func NewKMS(name string, opts Options) (KMS, error) {
+	switch name {
+	case Local:
+		return NewLocalKMS(opts.KeyFile)
+	}
+	return nil, fmt.Errorf("unexpected kms %q", name)
+}
@@ -0,0 +1,119 @@
+package kms
+
+import (
+	"context"
+	cryptoRand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// LocalKMS holds its KEKs in memory, having read them from a file. It's
+// intended for testing and for realms that have no KMS available.
+type LocalKMS struct {
+	keys    map[KeyVersion][]byte
+	current KeyVersion
+}
+
+// NewLocalKMS reads KEKs from a JSON file mapping each version to a 32-byte
+// hex key, for example {"1":"00112233..."}. The highest version is used to
+// wrap new data keys.
+func NewLocalKMS(path string) (*LocalKMS, error) {
+	if path == "" {
+		return nil, errors.New("unexpectedly missing key file")
+	}
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var hexKeys map[string]string
+	if err := json.Unmarshal(contents, &hexKeys); err != nil {
+		return nil, fmt.Errorf("error parsing key file: %w", err)
+	}
+
+	keys := make(map[KeyVersion][]byte, len(hexKeys))
+	for versionString, hexKey := range hexKeys {
+		version, err := strconv.ParseUint(versionString, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key version %q", versionString)
+		}
+		key, err := hex.DecodeString(hexKey)
+		if err != nil || len(key) != chacha20poly1305.KeySize {
+			return nil, fmt.Errorf("key version %d must be %d hex encoded bytes", version, chacha20poly1305.KeySize)
+		}
+		keys[KeyVersion(version)] = key
+	}
+	return NewLocalKMSWithKeys(keys)
+}
+
+// NewLocalKMSWithKeys returns a LocalKMS holding the given 32-byte KEKs.
+func NewLocalKMSWithKeys(keys map[KeyVersion][]byte) (*LocalKMS, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no key encryption keys given")
+	}
+
+	var current KeyVersion
+	for version, key := range keys {
+		if len(key) != chacha20poly1305.KeySize {
+			return nil, fmt.Errorf("key version %d must be %d bytes", version, chacha20poly1305.KeySize)
+		}
+		if version > current {
+			current = version
+		}
+	}
+	return &LocalKMS{keys: keys, current: current}, nil
+}
+
+func (l *LocalKMS) CurrentVersion(_ context.Context) (KeyVersion, error) {
+	return l.current, nil
+}
+
+func (l *LocalKMS) WrapKey(ctx context.Context, dataKey []byte, associatedData []byte) (KeyVersion, []byte, error) {
+	_, span := otel.StartSpan(ctx, "WrapKey")
+	defer span.End()
+
+	aead, err := chacha20poly1305.NewX(l.keys[l.current])
+	if err != nil {
+		return 0, nil, otel.RecordOutcome(err, span)
+	}
+
+	// the nonce is prepended to the wrapped key
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(dataKey)+aead.Overhead())
+	if _, err := cryptoRand.Read(nonce); err != nil {
+		return 0, nil, otel.RecordOutcome(err, span)
+	}
+	return l.current, aead.Seal(nonce, nonce, dataKey, associatedData), nil
+}
+
+func (l *LocalKMS) UnwrapKey(ctx context.Context, version KeyVersion, wrappedKey []byte, associatedData []byte) ([]byte, error) {
+	_, span := otel.StartSpan(ctx, "UnwrapKey")
+	defer span.End()
+
+	key, ok := l.keys[version]
+	if !ok {
+		return nil, otel.RecordOutcome(fmt.Errorf("%w: %d", ErrUnknownKeyVersion, version), span)
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, otel.RecordOutcome(errors.New("wrapped key is too short"), span)
+	}
+
+	nonce, ciphertext := wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():]
+	dataKey, err := aead.Open(nil, nonce, ciphertext, associatedData)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+	return dataKey, nil
+}
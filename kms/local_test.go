@@ -0,0 +1,43 @@
+package kms
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalKMS(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "kek.json")
+	contents := `{"1":"` + strings.Repeat("01", 32) + `","3":"` + strings.Repeat("03", 32) + `"}`
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0600))
+
+	kms, err := NewLocalKMS(path)
+	assert.NoError(t, err)
+
+	current, err := kms.CurrentVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, KeyVersion(3), current)
+
+	dataKey := []byte("0123456789abcdef0123456789abcdef")
+	version, wrapped, err := kms.WrapKey(ctx, dataKey, []byte("artemis"))
+	assert.NoError(t, err)
+	assert.Equal(t, KeyVersion(3), version)
+
+	unwrapped, err := kms.UnwrapKey(ctx, version, wrapped, []byte("artemis"))
+	assert.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	_, err = kms.UnwrapKey(ctx, version, wrapped, []byte("apollo"))
+	assert.Error(t, err)
+	_, err = kms.UnwrapKey(ctx, 2, wrapped, []byte("artemis"))
+	assert.ErrorIs(t, err, ErrUnknownKeyVersion)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"1":"abcd"}`), 0600))
+	_, err = NewLocalKMS(path)
+	assert.EqualError(t, err, "key version 1 must be 32 hex encoded bytes")
+}
@@ -3,7 +3,6 @@ package otel
 import (
 	"context"
 	"log"
-	"os"
 
 	"github.com/juicebox-systems/juicebox-software-realm/types"
 	"go.opentelemetry.io/otel"
@@ -62,7 +61,7 @@ func initResource(realmID types.RealmID) *resource.Resource {
 	return resource
 }
 
-func InitTraceProvider(ctx context.Context, serviceName string, realmID types.RealmID) *sdktrace.TracerProvider {
+func InitTraceProvider(ctx context.Context, serviceName string, realmID types.RealmID, endpoint string) *sdktrace.TracerProvider {
 	tracerName = serviceName
 
 	resource := initResource(realmID)
@@ -71,7 +70,6 @@ func InitTraceProvider(ctx context.Context, serviceName string, realmID types.Re
 		sdktrace.WithResource(resource),
 	}
 
-	endpoint := os.Getenv("OPENTELEMETRY_ENDPOINT")
 	if endpoint != "" {
 		exporter, err := otlptrace.New(ctx, otlptracegrpc.NewClient(
 			otlptracegrpc.WithEndpoint(endpoint),
@@ -89,7 +87,7 @@ func InitTraceProvider(ctx context.Context, serviceName string, realmID types.Re
 	return tp
 }
 
-func InitMeterProvider(ctx context.Context, serviceName string, realmID types.RealmID) *sdkmetric.MeterProvider {
+func InitMeterProvider(ctx context.Context, serviceName string, realmID types.RealmID, endpoint string) *sdkmetric.MeterProvider {
 	metricsName = serviceName
 
 	resource := initResource(realmID)
@@ -97,7 +95,6 @@ func InitMeterProvider(ctx context.Context, serviceName string, realmID types.Re
 		sdkmetric.WithResource(resource),
 	}
 
-	endpoint := os.Getenv("OPENTELEMETRY_ENDPOINT")
 	if endpoint != "" {
 		exporter, err := otlpmetricgrpc.New(
 			ctx,
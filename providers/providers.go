@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -19,10 +18,34 @@ import (
 // Provider represents a generic interface into the
 // record and secrets storage of your choice
 type Provider struct {
-	Name           types.ProviderName
+	Names          Names
 	RecordStore    records.RecordStore
 	SecretsManager secrets.SecretsManager
 	PubSub         pubsub.PubSub
+	// The options the provider was created with, including any client
+	// config loaded for them, for connecting to it elsewhere.
+	Options types.ProviderOptions
+}
+
+// Names selects the provider used for each part of a realm, allowing
+// for example records to be kept in one provider and secrets in another.
+type Names struct {
+	RecordStore    types.ProviderName
+	SecretsManager types.ProviderName
+	PubSub         types.ProviderName
+}
+
+// Close releases the clients held by each part of the provider.
+func (p *Provider) Close() {
+	for _, component := range []interface{}{p.RecordStore, p.SecretsManager, p.PubSub} {
+		if closer, ok := component.(interface{ Close() }); ok {
+			closer.Close()
+		}
+	}
+}
+
+func (n Names) uses(name types.ProviderName) bool {
+	return n.RecordStore == name || n.SecretsManager == name || n.PubSub == name
 }
 
 func Parse(nameString string) (types.ProviderName, error) {
@@ -35,18 +58,68 @@ func Parse(nameString string) (types.ProviderName, error) {
 		return types.Mongo, nil
 	case "memory":
 		return types.Memory, nil
+	case "postgres":
+		return types.Postgres, nil
+	case "local":
+		return types.Local, nil
+	case "redis":
+		return types.Redis, nil
+	case "s3":
+		return types.S3, nil
+	case "vault":
+		return types.Vault, nil
+	case "file":
+		return types.File, nil
+	case "jwks":
+		return types.JWKS, nil
 	default:
 		return -1, fmt.Errorf("invalid ProviderName: %s", nameString)
 	}
 }
 
-func NewProvider(ctx context.Context, name types.ProviderName, realmID types.RealmID) (*Provider, error) {
+// ResolveRealmID returns the ID of the named realm stored alongside the user
+// records, storing the configured or a random ID if this is the first boot.
+func ResolveRealmID(ctx context.Context, names Names, opts types.ProviderOptions, name string, configured *types.RealmID) (types.RealmID, error) {
+	ctx, span := otel.StartSpan(ctx, "ResolveRealmID")
+	defer span.End()
+
+	options, err := newOptions(ctx, names, opts)
+	if err != nil {
+		return types.RealmID{}, otel.RecordOutcome(err, span)
+	}
+
+	realmID, err := records.ResolveRealmID(ctx, names.RecordStore, *options, name, configured)
+	if err != nil {
+		return realmID, otel.RecordOutcome(err, span)
+	}
+	return realmID, nil
+}
+
+// LookupRealmID returns the ID of the named realm stored alongside the user
+// records, without storing one if there isn't one yet.
+func LookupRealmID(ctx context.Context, names Names, opts types.ProviderOptions, name string, configured *types.RealmID) (types.RealmID, error) {
+	ctx, span := otel.StartSpan(ctx, "LookupRealmID")
+	defer span.End()
+
+	options, err := newOptions(ctx, names, opts)
+	if err != nil {
+		return types.RealmID{}, otel.RecordOutcome(err, span)
+	}
+
+	realmID, err := records.LookupRealmID(ctx, names.RecordStore, *options, name, configured)
+	if err != nil {
+		return realmID, otel.RecordOutcome(err, span)
+	}
+	return realmID, nil
+}
+
+func NewProvider(ctx context.Context, names Names, opts types.ProviderOptions, realmID types.RealmID) (*Provider, error) {
 	ctx, span := otel.StartSpan(ctx, "NewProvider")
 	defer span.End()
 
 	fmt.Printf("Realm ID: %s\n\n", realmID.String())
 
-	options, err := newOptions(ctx, name)
+	options, err := newOptions(ctx, names, opts)
 	if err != nil {
 		fmt.Printf("Failed to configure provider: %s\n", err)
 		return nil, otel.RecordOutcome(err, span)
@@ -54,7 +127,7 @@ func NewProvider(ctx context.Context, name types.ProviderName, realmID types.Rea
 
 	fmt.Print("Connecting to secrets manager...")
 
-	secretsManager, err := secrets.NewSecretsManager(ctx, name, *options, realmID)
+	secretsManager, err := secrets.NewSecretsManager(ctx, names.SecretsManager, *options, realmID)
 	if err != nil {
 		fmt.Printf("\rFailed to connect to secrets manager: %s.\n", err)
 		return nil, otel.RecordOutcome(err, span)
@@ -64,7 +137,7 @@ func NewProvider(ctx context.Context, name types.ProviderName, realmID types.Rea
 
 	fmt.Print("Connecting to record store...")
 
-	recordStore, err := records.NewRecordStore(ctx, name, *options, realmID)
+	recordStore, err := records.NewRecordStore(ctx, names.RecordStore, *options, realmID)
 	if err != nil {
 		fmt.Printf("\rFailed to connect to record store: %s.\n", err)
 		return nil, otel.RecordOutcome(err, span)
@@ -73,7 +146,7 @@ func NewProvider(ctx context.Context, name types.ProviderName, realmID types.Rea
 	fmt.Print("\rEstablished connection to record store.\n")
 
 	fmt.Print("Connecting to pub/sub...")
-	pubsub, err := pubsub.NewPubSub(ctx, name, *options, realmID)
+	pubsub, err := pubsub.NewPubSub(ctx, names.PubSub, *options, realmID)
 	if err != nil {
 		fmt.Printf("\rFailed to connect to pubsub system: %s.\n", err)
 		return nil, otel.RecordOutcome(err, span)
@@ -81,29 +154,50 @@ func NewProvider(ctx context.Context, name types.ProviderName, realmID types.Rea
 	fmt.Print("\rEstablished connection to pub/sub system.\n\n")
 
 	return &Provider{
-		Name:           name,
+		Names:          names,
 		RecordStore:    recordStore,
 		SecretsManager: secretsManager,
 		PubSub:         pubsub,
+		Options:        *options,
 	}, nil
 }
 
-func newOptions(ctx context.Context, name types.ProviderName) (*types.ProviderOptions, error) {
-	if name == types.AWS {
-		return newAwsOptions(ctx)
+// NewRecordStore connects to just the record store, for tools that don't
+// need the rest of the provider.
+func NewRecordStore(ctx context.Context, names Names, opts types.ProviderOptions, realmID types.RealmID) (records.RecordStore, error) {
+	ctx, span := otel.StartSpan(ctx, "NewRecordStore")
+	defer span.End()
+
+	options, err := newOptions(ctx, names, opts)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	recordStore, err := records.NewRecordStore(ctx, names.RecordStore, *options, realmID)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+	return recordStore, nil
+}
+
+func newOptions(ctx context.Context, names Names, opts types.ProviderOptions) (*types.ProviderOptions, error) {
+	// the s3 provider uses the AWS region and credentials, even when it's
+	// talking to another S3-compatible service
+	if names.uses(types.AWS) || names.uses(types.S3) {
+		return newAwsOptions(ctx, opts)
 	}
-	return &types.ProviderOptions{}, nil
+	return &opts, nil
 }
 
-func newAwsOptions(ctx context.Context) (*types.ProviderOptions, error) {
-	region := os.Getenv("AWS_REGION_NAME")
-	if region == "" {
-		return nil, errors.New("unexpectedly missing AWS_REGION_NAME")
+func newAwsOptions(ctx context.Context, opts types.ProviderOptions) (*types.ProviderOptions, error) {
+	if opts.AwsRegion == "" {
+		return nil, errors.New("unexpectedly missing AWS region")
 	}
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(opts.AwsRegion))
 	if err != nil {
 		return nil, err
 	}
 	cfg.ClientLogMode |= aws.LogRetries
-	return &types.ProviderOptions{Config: cfg}, nil
+	opts.Config = cfg
+	return &opts, nil
 }
@@ -28,4 +28,12 @@ func TestParse(t *testing.T) {
 	provider, err = Parse("mongo")
 	assert.NoError(t, err)
 	assert.Equal(t, provider, types.Mongo)
+
+	provider, err = Parse("postgres")
+	assert.NoError(t, err)
+	assert.Equal(t, provider, types.Postgres)
+
+	provider, err = Parse("local")
+	assert.NoError(t, err)
+	assert.Equal(t, provider, types.Local)
 }
@@ -13,6 +13,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
 	sqsTypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
 	"github.com/juicebox-systems/juicebox-software-realm/otel"
 	"github.com/juicebox-systems/juicebox-software-realm/responses"
 	"github.com/juicebox-systems/juicebox-software-realm/types"
@@ -44,6 +45,24 @@ func newSqsPubSub(ctx context.Context, cfg aws.Config) (PubSub, attribute.KeyVal
 	}, msgType, nil
 }
 
+func (s *sqsClient) CheckHealth(ctx context.Context) error {
+	// Looking up a queue that doesn't exist is enough to know SQS is
+	// reachable and accepts the realm's credentials.
+	_, err := s.client.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: aws.String(healthCheckName)})
+	var qne *sqsTypes.QueueDoesNotExist
+	if errors.As(err, &qne) {
+		return nil
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "AccessDenied", "AccessDeniedException":
+			return nil
+		}
+	}
+	return err
+}
+
 func (s *sqsClient) Ack(ctx context.Context, realmID types.RealmID, tenant string, ids []string) error {
 	queueURL, err := s.queueURL(ctx, realmID, tenant)
 	if err != nil {
@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
 
 	gcp_pubsub "cloud.google.com/go/pubsub/apiv1"
 	"cloud.google.com/go/pubsub/apiv1/pubsubpb"
@@ -28,7 +27,11 @@ type gcpPubSub struct {
 
 var msgType = semconv.MessagingSystemKey.String("GCP pub/sub")
 
-func newGcpPubSub(ctx context.Context) (PubSub, attribute.KeyValue, error) {
+// The name of a topic or queue that's looked up to check the pub/sub system
+// can be reached. It doesn't need to exist.
+const healthCheckName = "jb-sw-realm-healthcheck"
+
+func newGcpPubSub(ctx context.Context, projectID string) (PubSub, attribute.KeyValue, error) {
 	ctx, span := otel.StartSpan(
 		ctx,
 		"newGcpPubSub",
@@ -37,9 +40,8 @@ func newGcpPubSub(ctx context.Context) (PubSub, attribute.KeyValue, error) {
 	)
 	defer span.End()
 
-	projectID := os.Getenv("GCP_PROJECT_ID")
 	if projectID == "" {
-		return nil, msgType, otel.RecordOutcome(errors.New("unexpectedly missing GCP_PROJECT_ID"), span)
+		return nil, msgType, otel.RecordOutcome(errors.New("unexpectedly missing GCP project ID"), span)
 	}
 	subClient, err := gcp_pubsub.NewSubscriberClient(ctx)
 	if err != nil {
@@ -56,6 +58,24 @@ func newGcpPubSub(ctx context.Context) (PubSub, attribute.KeyValue, error) {
 	}, msgType, nil
 }
 
+func (c *gcpPubSub) Close() {
+	c.subClient.Close()
+	c.pubClient.Close()
+}
+
+func (c *gcpPubSub) CheckHealth(ctx context.Context) error {
+	// The realm may only be allowed to use its tenants' topics, so being
+	// told the topic is missing or off limits still shows Pub/Sub is
+	// reachable and accepts the realm's credentials.
+	_, err := c.pubClient.GetTopic(ctx, &pubsubpb.GetTopicRequest{
+		Topic: fmt.Sprintf("projects/%s/topics/%s", c.project, healthCheckName),
+	})
+	if errorHasCode(err, codes.NotFound) || errorHasCode(err, codes.PermissionDenied) {
+		return nil
+	}
+	return err
+}
+
 func (c *gcpPubSub) Ack(ctx context.Context, realm types.RealmID, tenant string, ids []string) error {
 	err := c.subClient.Acknowledge(ctx, &pubsubpb.AcknowledgeRequest{
 		Subscription: subscriptionName(c.project, realm, tenant),
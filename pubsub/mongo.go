@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"os"
 	"time"
 
 	"github.com/juicebox-systems/juicebox-software-realm/otel"
@@ -16,6 +15,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"go.opentelemetry.io/otel/attribute"
 	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
 	"go.opentelemetry.io/otel/trace"
@@ -28,7 +28,7 @@ type mongoPubSub struct {
 	db     *mongo.Database
 }
 
-func newMongoPubSub(ctx context.Context, realmID types.RealmID) (PubSub, attribute.KeyValue, error) {
+func newMongoPubSub(ctx context.Context, urlString string, realmID types.RealmID) (PubSub, attribute.KeyValue, error) {
 	ctx, span := otel.StartSpan(
 		ctx,
 		"newMongoPubSub",
@@ -37,9 +37,8 @@ func newMongoPubSub(ctx context.Context, realmID types.RealmID) (PubSub, attribu
 	)
 	defer span.End()
 
-	urlString := os.Getenv("MONGO_URL")
 	if urlString == "" {
-		err := errors.New("unexpectedly missing MONGO_URL")
+		err := errors.New("unexpectedly missing mongo URL")
 		return nil, semconv.DBSystemMongoDB, otel.RecordOutcome(err, span)
 	}
 
@@ -69,6 +68,14 @@ func newMongoPubSub(ctx context.Context, realmID types.RealmID) (PubSub, attribu
 	}, semconv.DBSystemMongoDB, nil
 }
 
+func (m *mongoPubSub) Close() {
+	m.client.Disconnect(context.Background())
+}
+
+func (m *mongoPubSub) CheckHealth(ctx context.Context) error {
+	return m.client.Ping(ctx, readpref.Primary())
+}
+
 func (m *mongoPubSub) Ack(ctx context.Context, _ types.RealmID, tenant string, ids []string) error {
 	collection := m.db.Collection(tenant + collectionSuffix)
 	objectIDs := make([]primitive.ObjectID, len(ids))
@@ -0,0 +1,196 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/responses"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const eventsTable = "tenant_events"
+
+type postgresPubSub struct {
+	pool      *pgxpool.Pool
+	tableName string
+}
+
+func newPostgresPubSub(ctx context.Context, urlString string, realmID types.RealmID) (PubSub, attribute.KeyValue, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"newPostgresPubSub",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	if urlString == "" {
+		err := errors.New("unexpectedly missing postgres URL")
+		return nil, semconv.DBSystemPostgreSQL, otel.RecordOutcome(err, span)
+	}
+
+	pool, err := pgxpool.New(ctx, urlString)
+	if err != nil {
+		return nil, semconv.DBSystemPostgreSQL, otel.RecordOutcome(err, span)
+	}
+
+	schemaName := pgx.Identifier{types.JuiceboxRealmDatabasePrefix + realmID.String()}.Sanitize()
+	tableName := schemaName + "." + pgx.Identifier{eventsTable}.Sanitize()
+
+	_, err = pool.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+schemaName)
+	if err != nil {
+		pool.Close()
+		return nil, semconv.DBSystemPostgreSQL, otel.RecordOutcome(err, span)
+	}
+
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (
+			id BIGSERIAL PRIMARY KEY,
+			tenant TEXT NOT NULL,
+			event JSONB NOT NULL,
+			created TIMESTAMPTZ NOT NULL,
+			last_pulled TIMESTAMPTZ
+		)`,
+		tableName,
+	))
+	if err != nil {
+		pool.Close()
+		return nil, semconv.DBSystemPostgreSQL, otel.RecordOutcome(err, span)
+	}
+
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		"CREATE INDEX IF NOT EXISTS %s ON %s (tenant, id)",
+		pgx.Identifier{eventsTable + "_tenant_idx"}.Sanitize(),
+		tableName,
+	))
+	if err != nil {
+		pool.Close()
+		return nil, semconv.DBSystemPostgreSQL, otel.RecordOutcome(err, span)
+	}
+
+	return &postgresPubSub{
+		pool:      pool,
+		tableName: tableName,
+	}, semconv.DBSystemPostgreSQL, nil
+}
+
+func (p *postgresPubSub) Close() {
+	p.pool.Close()
+}
+
+func (p *postgresPubSub) CheckHealth(ctx context.Context) error {
+	return p.pool.Ping(ctx)
+}
+
+func (p *postgresPubSub) Ack(ctx context.Context, _ types.RealmID, tenant string, ids []string) error {
+	eventIDs := make([]int64, len(ids))
+	var err error
+	for i := range ids {
+		eventIDs[i], err = strconv.ParseInt(ids[i], 10, 64)
+		if err != nil {
+			return types.NewHTTPError(http.StatusBadRequest, fmt.Errorf("invalid ack id '%s': %s", ids[i], err))
+		}
+	}
+	tag, err := p.pool.Exec(ctx, "DELETE FROM "+p.tableName+" WHERE tenant = $1 AND id = ANY($2)", tenant, eventIDs)
+	if err != nil {
+		return types.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	missing := int64(len(eventIDs)) - tag.RowsAffected()
+	if missing != 0 {
+		return types.NewHTTPError(http.StatusBadRequest, fmt.Errorf("%d ack id's were invalid", missing))
+	}
+	return nil
+}
+
+func (p *postgresPubSub) Publish(ctx context.Context, _ types.RealmID, tenant string, event EventMessage) error {
+	enc, err := json.Marshal(event)
+	if err != nil {
+		return types.NewHTTPError(http.StatusBadRequest, err)
+	}
+	_, err = p.pool.Exec(
+		ctx,
+		"INSERT INTO "+p.tableName+" (tenant, event, created) VALUES ($1, $2, $3)",
+		tenant,
+		string(enc),
+		time.Now(),
+	)
+	if err != nil {
+		return types.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return nil
+}
+
+func (p *postgresPubSub) Pull(ctx context.Context, _ types.RealmID, tenant string, max uint16) ([]responses.TenantLogEntry, error) {
+	// Postgres has no equivalent of mongo's TTL indexes, so expire any
+	// events older than a week before we look for new ones.
+	_, err := p.pool.Exec(
+		ctx,
+		"DELETE FROM "+p.tableName+" WHERE tenant = $1 AND created < $2",
+		tenant,
+		time.Now().Add(-7*24*time.Hour),
+	)
+	if err != nil {
+		return nil, types.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	// Skip anything that has been pulled in the last 10 seconds.
+	ackWindow := time.Now().Add(-time.Second * 10)
+	rows, err := p.pool.Query(
+		ctx,
+		`WITH pulled AS (
+			UPDATE `+p.tableName+` SET last_pulled = now() WHERE id IN (
+				SELECT id FROM `+p.tableName+`
+				WHERE tenant = $1 AND (last_pulled IS NULL OR last_pulled < $2)
+				ORDER BY id
+				LIMIT $3
+				FOR UPDATE SKIP LOCKED
+			) RETURNING id, event, created
+		) SELECT id, event, created FROM pulled ORDER BY id`,
+		tenant,
+		ackWindow,
+		int64(max),
+	)
+	if err != nil {
+		return nil, types.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	defer rows.Close()
+
+	results := []responses.TenantLogEntry{}
+	for rows.Next() {
+		var id int64
+		var enc []byte
+		var created time.Time
+		if err := rows.Scan(&id, &enc, &created); err != nil {
+			return nil, types.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		em := EventMessage{}
+		if err := json.Unmarshal(enc, &em); err != nil {
+			return nil, types.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		idString := strconv.FormatInt(id, 10)
+		results = append(results, responses.TenantLogEntry{
+			ID:         idString,
+			Ack:        idString,
+			When:       created,
+			UserID:     em.User,
+			Event:      em.Event,
+			NumGuesses: em.NumGuesses,
+			GuessCount: em.GuessCount,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, types.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return results, nil
+}
@@ -30,13 +30,15 @@ func NewPubSub(ctx context.Context, provider types.ProviderName, opts types.Prov
 	var msgType attribute.KeyValue
 	switch provider {
 	case types.GCP:
-		ps, msgType, err = newGcpPubSub(ctx)
-	case types.Memory:
+		ps, msgType, err = newGcpPubSub(ctx, opts.GcpProjectID)
+	case types.Memory, types.Local:
 		ps, msgType = newMemPubSub()
 	case types.AWS:
 		ps, msgType, err = newSqsPubSub(ctx, opts.Config.(aws.Config))
 	case types.Mongo:
-		ps, msgType, err = newMongoPubSub(ctx, realmID)
+		ps, msgType, err = newMongoPubSub(ctx, opts.MongoURL, realmID)
+	case types.Postgres:
+		ps, msgType, err = newPostgresPubSub(ctx, opts.PostgresURL, realmID)
 	default:
 		err = fmt.Errorf("unexpected provider %v", provider)
 	}
@@ -82,6 +84,25 @@ func (s *spannedPubSub) Pull(ctx context.Context, realm types.RealmID, tenant st
 	return events, otel.RecordOutcome(err, span)
 }
 
+func (s *spannedPubSub) Close() {
+	if closer, ok := s.inner.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+func (s *spannedPubSub) CheckHealth(ctx context.Context) error {
+	checker, ok := s.inner.(types.HealthChecker)
+	if !ok {
+		return types.ErrHealthCheckUnsupported
+	}
+
+	ctx, span := s.startSpan(ctx, "CheckHealth")
+	defer span.End()
+
+	err := checker.CheckHealth(ctx)
+	return otel.RecordOutcome(err, span)
+}
+
 func (s *spannedPubSub) startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
 	ctx, span := otel.StartSpan(
 		ctx,
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Bigtable table ids are limited to 50 characters, which rules out adding a
+// suffix to the realm's records table name.
+const bigtablePrefix string = "jb-sw-rl-"
+const bigtableFamily string = "c"
+const bigtableColumn string = "count"
+
+type bigtableStore struct {
+	client    *bigtable.Client
+	tableName string
+}
+
+func newBigtableStore(ctx context.Context, projectID string, instanceID string, realmID types.RealmID) (*bigtableStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"newBigtableRateLimitStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemKey.String("bigtable")),
+	)
+	defer span.End()
+
+	if projectID == "" {
+		err := errors.New("unexpectedly missing GCP project ID")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	if instanceID == "" {
+		err := errors.New("unexpectedly missing Bigtable instance ID")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	admin, err := bigtable.NewAdminClient(ctx, projectID, instanceID)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+	defer admin.Close()
+
+	tableName := bigtablePrefix + realmID.String()
+
+	config := bigtable.TableConf{
+		TableID: tableName,
+		Families: map[string]bigtable.GCPolicy{
+			// Bigtable garbage collects counters once no window could still
+			// be using them.
+			bigtableFamily: bigtable.MaxAgePolicy(MaxWindow),
+		},
+	}
+
+	if err := admin.CreateTableFromConf(ctx, &config); err != nil {
+		if status.Code(err) != grpccodes.AlreadyExists {
+			return nil, otel.RecordOutcome(err, span)
+		}
+	}
+
+	client, err := bigtable.NewClient(ctx, projectID, instanceID)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	return &bigtableStore{
+		client:    client,
+		tableName: tableName,
+	}, nil
+}
+
+func (bt *bigtableStore) Close() {
+	bt.client.Close()
+}
+
+func (bt *bigtableStore) Increment(ctx context.Context, key string, _ time.Time) (uint64, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"Increment",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemKey.String("bigtable")),
+	)
+	defer span.End()
+
+	rmw := bigtable.NewReadModifyWrite()
+	rmw.Increment(bigtableFamily, bigtableColumn, 1)
+
+	row, err := bt.client.Open(bt.tableName).ApplyReadModifyWrite(ctx, key, rmw)
+	if err != nil {
+		return 0, otel.RecordOutcome(err, span)
+	}
+
+	items := row[bigtableFamily]
+	if len(items) == 0 || len(items[0].Value) != 8 {
+		err := errors.New("unexpected counter value")
+		return 0, otel.RecordOutcome(err, span)
+	}
+	return binary.BigEndian.Uint64(items[0].Value), nil
+}
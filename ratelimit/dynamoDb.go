@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const dynamoDbTableSuffix string = "-rate-limits"
+const dynamoDbKeyName string = "key"
+const dynamoDbCountName string = "count"
+
+// DynamoDB deletes each counter some time after the time in this attribute.
+const dynamoDbExpiresName string = "expires"
+
+type dynamoDbStore struct {
+	svc       *dynamodb.Client
+	tableName string
+}
+
+func newDynamoDbStore(ctx context.Context, cfg aws.Config, realmID types.RealmID, skipTableCreation bool) (*dynamoDbStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"newDynamoDbRateLimitStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemDynamoDB),
+	)
+	defer span.End()
+
+	svc := dynamodb.NewFromConfig(cfg)
+	tableName := types.JuiceboxRealmDatabasePrefix + realmID.String() + dynamoDbTableSuffix
+
+	if !skipTableCreation {
+		err := records.CreateDynamoDbTable(ctx, svc, tableName, dynamoDbKeyName, dynamoDbExpiresName)
+		if err != nil {
+			return nil, otel.RecordOutcome(err, span)
+		}
+	}
+
+	return &dynamoDbStore{
+		svc:       svc,
+		tableName: tableName,
+	}, nil
+}
+
+func (db *dynamoDbStore) Increment(ctx context.Context, key string, expires time.Time) (uint64, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"Increment",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemDynamoDB),
+	)
+	defer span.End()
+
+	// DynamoDB TTLs are in whole seconds, so round up
+	expiresAt := strconv.FormatInt(expires.Unix()+1, 10)
+	output, err := db.svc.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(db.tableName),
+		Key: map[string]ddbTypes.AttributeValue{
+			dynamoDbKeyName: &ddbTypes.AttributeValueMemberS{Value: key},
+		},
+		UpdateExpression: aws.String("ADD #count :one SET #expires = if_not_exists(#expires, :expires)"),
+		ExpressionAttributeNames: map[string]string{
+			"#count":   dynamoDbCountName,
+			"#expires": dynamoDbExpiresName,
+		},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":one":     &ddbTypes.AttributeValueMemberN{Value: "1"},
+			":expires": &ddbTypes.AttributeValueMemberN{Value: expiresAt},
+		},
+		ReturnValues: ddbTypes.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return 0, otel.RecordOutcome(err, span)
+	}
+
+	count, ok := output.Attributes[dynamoDbCountName].(*ddbTypes.AttributeValueMemberN)
+	if !ok {
+		err := errors.New("unexpected counter value")
+		return 0, otel.RecordOutcome(err, span)
+	}
+	value, err := strconv.ParseUint(count.Value, 10, 64)
+	if err != nil {
+		return 0, otel.RecordOutcome(err, span)
+	}
+	return value, nil
+}
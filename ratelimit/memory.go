@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryCounter struct {
+	count   uint64
+	expires time.Time
+}
+
+// How often the memory store sweeps out expired counters.
+const memorySweepInterval = time.Minute
+
+type MemoryStore struct {
+	lock      sync.Mutex
+	counters  map[string]memoryCounter
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: make(map[string]memoryCounter),
+		now:      time.Now,
+	}
+}
+
+func (m *MemoryStore) Increment(_ context.Context, key string, expires time.Time) (uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) >= memorySweepInterval {
+		m.sweep(now)
+	}
+
+	counter, ok := m.counters[key]
+	if !ok {
+		counter.expires = expires
+	}
+	counter.count++
+	m.counters[key] = counter
+	return counter.count, nil
+}
+
+func (m *MemoryStore) sweep(now time.Time) {
+	m.lastSweep = now
+	for key, counter := range m.counters {
+		if !counter.expires.After(now) {
+			delete(m.counters, key)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const rateLimitsCollection string = "rateLimits"
+
+type mongoStore struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func newMongoStore(ctx context.Context, urlString string, realmID types.RealmID) (*mongoStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"newMongoRateLimitStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemMongoDB),
+	)
+	defer span.End()
+
+	if urlString == "" {
+		err := errors.New("unexpectedly missing mongo URL")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	url, err := url.Parse(urlString)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	databaseName := types.JuiceboxRealmDatabasePrefix + realmID.String()
+	if len(url.Path) > 1 {
+		databaseName = url.Path[1:]
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(urlString))
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	collection := client.Database(databaseName).Collection(rateLimitsCollection)
+
+	// mongo deletes each counter once its window has expired
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		client.Disconnect(ctx)
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	return &mongoStore{
+		client:     client,
+		collection: collection,
+	}, nil
+}
+
+func (m *mongoStore) Close() {
+	m.client.Disconnect(context.Background())
+}
+
+func (m *mongoStore) Increment(ctx context.Context, key string, expires time.Time) (uint64, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"Increment",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemMongoDB),
+	)
+	defer span.End()
+
+	var result struct {
+		Count int64 `bson:"count"`
+	}
+	err := m.collection.FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": key},
+		bson.M{
+			"$inc":         bson.M{"count": int64(1)},
+			"$setOnInsert": bson.M{"expires": expires},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&result)
+	if err != nil {
+		return 0, otel.RecordOutcome(err, span)
+	}
+	return uint64(result.Count), nil
+}
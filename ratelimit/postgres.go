@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const rateLimitsTable string = "rate_limits"
+
+// Postgres has no TTLs, so expired counters are deleted this often, and the
+// deletion is given this long to run.
+const postgresSweepInterval = time.Minute
+const postgresSweepTimeout = 30 * time.Second
+
+type postgresStore struct {
+	pool      *pgxpool.Pool
+	tableName string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newPostgresStore(ctx context.Context, urlString string, realmID types.RealmID) (*postgresStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"newPostgresRateLimitStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	if urlString == "" {
+		err := errors.New("unexpectedly missing postgres URL")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	pool, err := pgxpool.New(ctx, urlString)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	schemaName := pgx.Identifier{types.JuiceboxRealmDatabasePrefix + realmID.String()}.Sanitize()
+	tableName := schemaName + "." + pgx.Identifier{rateLimitsTable}.Sanitize()
+
+	_, err = pool.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+schemaName)
+	if err != nil {
+		pool.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (
+			key TEXT PRIMARY KEY,
+			count BIGINT NOT NULL,
+			expires TIMESTAMPTZ NOT NULL
+		)`,
+		tableName,
+	))
+	if err != nil {
+		pool.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		"CREATE INDEX IF NOT EXISTS %s ON %s (expires)",
+		pgx.Identifier{rateLimitsTable + "_expires_idx"}.Sanitize(),
+		tableName,
+	))
+	if err != nil {
+		pool.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	p := &postgresStore{
+		pool:      pool,
+		tableName: tableName,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go p.sweep()
+	return p, nil
+}
+
+func (p *postgresStore) Close() {
+	close(p.stop)
+	<-p.done
+	p.pool.Close()
+}
+
+// Deletes expired counters in the background, rather than slowing down the
+// requests that start new windows.
+func (p *postgresStore) sweep() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(postgresSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), postgresSweepTimeout)
+			_, err := p.pool.Exec(ctx, "DELETE FROM "+p.tableName+" WHERE expires < now()")
+			cancel()
+			if err != nil {
+				fmt.Printf("Failed to delete expired rate limit counters: %v\n", err)
+			}
+		}
+	}
+}
+
+func (p *postgresStore) Increment(ctx context.Context, key string, expires time.Time) (uint64, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"Increment",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	var count int64
+	err := p.pool.QueryRow(
+		ctx,
+		"INSERT INTO "+p.tableName+" (key, count, expires) VALUES ($1, 1, $2) "+
+			"ON CONFLICT (key) DO UPDATE SET count = "+p.tableName+".count + 1 RETURNING count",
+		key,
+		expires,
+	).Scan(&count)
+	if err != nil {
+		return 0, otel.RecordOutcome(err, span)
+	}
+	return uint64(count), nil
+}
@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+)
+
+// Limit allows up to Requests requests in each fixed Window. A zero limit
+// allows any number of requests.
+type Limit struct {
+	Requests uint64
+	Window   time.Duration
+}
+
+func (l Limit) enabled() bool {
+	return l.Requests > 0 && l.Window > 0
+}
+
+// Policy is the set of limits applied to one tenant. Tenant limits the
+// requests across all of the tenant's users, while User limits the requests
+// for each individual user.
+type Policy struct {
+	Tenant Limit
+	User   Limit
+}
+
+// The longest window supported. The shared backends expire counters after
+// this long.
+const MaxWindow = 24 * time.Hour
+
+// Store counts requests in fixed windows.
+type Store interface {
+	// Increment adds one to the count for key, returning the new count. The
+	// key is unique to a window, and the count can be discarded after
+	// expires.
+	Increment(ctx context.Context, key string, expires time.Time) (uint64, error)
+}
+
+type Backend int
+
+const (
+	// Counts requests in the realm's memory, so each realm process applies
+	// the limits separately.
+	Memory Backend = iota
+	// Counts requests in the configured provider so that the limits apply
+	// across all of the realm's processes.
+	Shared
+)
+
+func ParseBackend(name string) (Backend, error) {
+	switch name {
+	case "", "memory":
+		return Memory, nil
+	case "shared":
+		return Shared, nil
+	default:
+		return -1, fmt.Errorf("invalid rate limit backend: %s", name)
+	}
+}
+
+// SharedSupported reports whether the shared backend can keep its counts in
+// the provider. S3 isn't suited to a write on every request, and has no way
+// to expire the counts.
+func SharedSupported(provider types.ProviderName) bool {
+	return provider != types.S3
+}
+
+// NewStore creates a store for the backend. Shared stores keep their counts
+// in the provider that stores the realm's records.
+func NewStore(ctx context.Context, backend Backend, provider types.ProviderName, opts types.ProviderOptions, realmID types.RealmID) (Store, error) {
+	ctx, span := otel.StartSpan(ctx, "NewRateLimitStore")
+	defer span.End()
+
+	if backend == Memory {
+		return NewMemoryStore(), nil
+	}
+
+	switch provider {
+	case types.GCP:
+		return newBigtableStore(ctx, opts.GcpProjectID, opts.BigtableInstanceID, realmID)
+	case types.AWS:
+		return newDynamoDbStore(ctx, opts.Config.(aws.Config), realmID, opts.AwsSkipTableCreation)
+	case types.Mongo:
+		return newMongoStore(ctx, opts.MongoURL, realmID)
+	case types.Postgres:
+		return newPostgresStore(ctx, opts.PostgresURL, realmID)
+	case types.Redis:
+		return newRedisStore(ctx, opts.RedisURL, realmID)
+	case types.Memory, types.Local:
+		// these only ever run as a single process
+		return NewMemoryStore(), nil
+	}
+
+	err := fmt.Errorf("the shared rate limit backend isn't supported by the %s provider", provider)
+	return nil, otel.RecordOutcome(err, span)
+}
+
+// Limiter applies per tenant and per user rate limits.
+type Limiter struct {
+	store    Store
+	fallback Policy
+	tenants  map[string]Policy
+	now      func() time.Time
+}
+
+// NewLimiter returns a limiter that applies the tenant's policy, or the
+// fallback policy for tenants without one of their own.
+func NewLimiter(store Store, fallback Policy, tenants map[string]Policy) *Limiter {
+	return &Limiter{
+		store:    store,
+		fallback: fallback,
+		tenants:  tenants,
+		now:      time.Now,
+	}
+}
+
+// Close releases any clients held by the limiter's store.
+func (l *Limiter) Close() {
+	if closer, ok := l.store.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Rejection describes which limit a request exceeded.
+type Rejection struct {
+	// "tenant" or "user"
+	Scope string
+	// How long until the limit's window ends.
+	RetryAfter time.Duration
+}
+
+// Allow counts a request from the user of a tenant. It returns a rejection
+// if the request exceeds the tenant's limits.
+func (l *Limiter) Allow(ctx context.Context, tenant string, userID string) (*Rejection, error) {
+	policy, ok := l.tenants[tenant]
+	if !ok {
+		policy = l.fallback
+	}
+
+	// The user's limit is checked first, so that the requests it rejects
+	// aren't counted against the tenant, and a single user can't use up the
+	// limit of all of the tenant's users.
+	now := l.now()
+	if rejection, err := l.check(ctx, now, "user", tenant+":"+userID, policy.User); rejection != nil || err != nil {
+		return rejection, err
+	}
+	return l.check(ctx, now, "tenant", tenant, policy.Tenant)
+}
+
+func (l *Limiter) check(ctx context.Context, now time.Time, scope string, id string, limit Limit) (*Rejection, error) {
+	if !limit.enabled() {
+		return nil, nil
+	}
+
+	windowStart := now.Truncate(limit.Window)
+	windowEnd := windowStart.Add(limit.Window)
+	key := fmt.Sprintf("%s:%s:%d", scope, id, windowStart.Unix())
+
+	count, err := l.store.Increment(ctx, key, windowEnd)
+	if err != nil {
+		return nil, err
+	}
+	if count <= limit.Requests {
+		return nil, nil
+	}
+	return &Rejection{
+		Scope:      scope,
+		RetryAfter: windowEnd.Sub(now),
+	}, nil
+}
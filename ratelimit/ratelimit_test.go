@@ -0,0 +1,178 @@
+package ratelimit
+
+import (
+	"context"
+	cryptoRand "crypto/rand"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limiter := NewLimiter(
+		store,
+		Policy{
+			Tenant: Limit{Requests: 3, Window: time.Minute},
+			User:   Limit{Requests: 2, Window: time.Minute},
+		},
+		map[string]Policy{
+			// no tenant limit, and a tighter user limit
+			"acme": {User: Limit{Requests: 1, Window: 10 * time.Second}},
+		},
+	)
+	limiter.now = func() time.Time { return now }
+
+	allow := func(tenant string, user string) *Rejection {
+		rejection, err := limiter.Allow(ctx, tenant, user)
+		assert.NoError(t, err)
+		return rejection
+	}
+
+	// the default policy limits each user
+	assert.Nil(t, allow("test", "artemis"))
+	assert.Nil(t, allow("test", "artemis"))
+	assert.Equal(t, &Rejection{Scope: "user", RetryAfter: time.Minute}, allow("test", "artemis"))
+	assert.Equal(t, &Rejection{Scope: "user", RetryAfter: time.Minute}, allow("test", "artemis"))
+
+	// without counting the requests it rejects against the tenant
+	tenantKey := "tenant:test:" + strconv.FormatInt(now.Unix(), 10)
+	assert.Equal(t, uint64(2), store.counters[tenantKey].count)
+
+	// and the tenant as a whole
+	now = now.Add(15 * time.Second)
+	assert.Nil(t, allow("test", "apollo"))
+	assert.Equal(t, &Rejection{Scope: "tenant", RetryAfter: 45 * time.Second}, allow("test", "hermes"))
+
+	// tenants with their own policy don't use the default
+	assert.Nil(t, allow("acme", "artemis"))
+	assert.Equal(t, &Rejection{Scope: "user", RetryAfter: 5 * time.Second}, allow("acme", "artemis"))
+	assert.Nil(t, allow("acme", "apollo"))
+	assert.Nil(t, allow("acme", "hermes"))
+	assert.Nil(t, allow("acme", "athena"))
+
+	// the limits reset when the window ends
+	now = now.Add(45 * time.Second)
+	assert.Nil(t, allow("test", "artemis"))
+	assert.Nil(t, allow("acme", "artemis"))
+
+	// and expired windows are eventually forgotten
+	now = now.Add(memorySweepInterval)
+	assert.Nil(t, allow("test", "apollo"))
+	assert.Len(t, store.counters, 2)
+}
+
+func TestUnlimited(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), Policy{}, nil)
+	for i := 0; i < 100; i++ {
+		rejection, err := limiter.Allow(context.Background(), "test", "artemis")
+		assert.NoError(t, err)
+		assert.Nil(t, rejection)
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	backend, err := ParseBackend("")
+	assert.NoError(t, err)
+	assert.Equal(t, Memory, backend)
+
+	backend, err = ParseBackend("shared")
+	assert.NoError(t, err)
+	assert.Equal(t, Shared, backend)
+
+	_, err = ParseBackend("redis")
+	assert.EqualError(t, err, "invalid rate limit backend: redis")
+}
+
+// The shared stores are tested when an emulator for them is configured, in
+// the same way as the record stores.
+
+func TestBigtableStore(t *testing.T) {
+	if os.Getenv("BIGTABLE_EMULATOR_HOST") == "" {
+		t.Skip("BIGTABLE_EMULATOR_HOST isn't set")
+	}
+	testSharedStore(t, types.GCP, types.ProviderOptions{GcpProjectID: "test-project", BigtableInstanceID: "test-instance"})
+}
+
+func TestDynamoDbStore(t *testing.T) {
+	endpoint := os.Getenv("TEST_DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_DYNAMODB_ENDPOINT isn't set")
+	}
+	cfg := aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(string, string, ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: endpoint}, nil
+		}),
+	}
+	testSharedStore(t, types.AWS, types.ProviderOptions{Config: cfg})
+}
+
+func TestMongoStore(t *testing.T) {
+	url := os.Getenv("TEST_MONGO_URL")
+	if url == "" {
+		t.Skip("TEST_MONGO_URL isn't set")
+	}
+	testSharedStore(t, types.Mongo, types.ProviderOptions{MongoURL: url})
+}
+
+func TestPostgresStore(t *testing.T) {
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL isn't set")
+	}
+	testSharedStore(t, types.Postgres, types.ProviderOptions{PostgresURL: url})
+}
+
+func TestRedisStore(t *testing.T) {
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL isn't set")
+	}
+	testSharedStore(t, types.Redis, types.ProviderOptions{RedisURL: url})
+}
+
+func TestSharedUnsupported(t *testing.T) {
+	_, err := NewStore(context.Background(), Shared, types.S3, types.ProviderOptions{}, types.RealmID{})
+	assert.EqualError(t, err, "the shared rate limit backend isn't supported by the s3 provider")
+}
+
+// testSharedStore checks the counts kept by the provider's shared store.
+// Each run uses its own realm, so that runs against a shared emulator don't
+// see each other's counts.
+func testSharedStore(t *testing.T, provider types.ProviderName, opts types.ProviderOptions) {
+	ctx := context.Background()
+	var realmID types.RealmID
+	_, err := cryptoRand.Read(realmID[:])
+	assert.NoError(t, err)
+
+	store, err := NewStore(ctx, Shared, provider, opts, realmID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if closer, ok := store.(interface{ Close() }); ok {
+		defer closer.Close()
+	}
+
+	expires := time.Now().Add(time.Minute)
+	for i := uint64(1); i <= 3; i++ {
+		count, err := store.Increment(ctx, "test:artemis:1", expires)
+		assert.NoError(t, err)
+		assert.Equal(t, i, count)
+	}
+	count, err := store.Increment(ctx, "test:apollo:1", expires)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+}
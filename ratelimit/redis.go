@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/redis/go-redis/v9"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var dbSystemRedis = semconv.DBSystemKey.String("redis")
+
+type redisStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+func newRedisStore(ctx context.Context, urlString string, realmID types.RealmID) (*redisStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"newRedisRateLimitStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemRedis),
+	)
+	defer span.End()
+
+	if urlString == "" {
+		err := errors.New("unexpectedly missing redis URL")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	opts, err := redis.ParseURL(urlString)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+	client := redis.NewClient(opts)
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	return &redisStore{
+		client: client,
+		// the same prefix as the realm's records
+		keyPrefix: "{" + types.JuiceboxRealmDatabasePrefix + realmID.String() + "}:ratelimit:",
+	}, nil
+}
+
+func (r *redisStore) Close() {
+	r.client.Close()
+}
+
+func (r *redisStore) Increment(ctx context.Context, key string, expires time.Time) (uint64, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"Increment",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemRedis),
+	)
+	defer span.End()
+
+	// redis deletes each counter once its window has expired
+	pipe := r.client.TxPipeline()
+	count := pipe.Incr(ctx, r.keyPrefix+key)
+	pipe.PExpireAt(ctx, r.keyPrefix+key, expires)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, otel.RecordOutcome(err, span)
+	}
+	return uint64(count.Val()), nil
+}
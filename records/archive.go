@@ -0,0 +1,239 @@
+package records
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// An archive holds user records in a form that doesn't depend on the
+// provider they were exported from. It's a sequence of CBOR items: a header,
+// one item per record, and a trailer holding the number of records and a
+// SHA-256 digest of the encoded record items, so that a truncated or
+// corrupted archive is rejected.
+const archiveFormat string = "jb-sw-realm-records"
+const archiveVersion uint64 = 1
+
+// ArchiveHeader describes where an archive came from.
+type ArchiveHeader struct {
+	Format  string        `cbor:"format"`
+	Version uint64        `cbor:"version"`
+	RealmID types.RealmID `cbor:"realm_id"`
+}
+
+// ArchiveRecord is a record serialized as it was stored, so encrypted
+// records stay encrypted.
+type ArchiveRecord struct {
+	RecordID             UserRecordID `cbor:"record_id"`
+	SerializedUserRecord []byte       `cbor:"record"`
+}
+
+type archiveTrailer struct {
+	Count  uint64 `cbor:"count"`
+	Digest []byte `cbor:"sha256"`
+}
+
+// Exactly one field is set in each item of an archive.
+type archiveItem struct {
+	Header  *ArchiveHeader  `cbor:"1,keyasint,omitempty"`
+	Record  *ArchiveRecord  `cbor:"2,keyasint,omitempty"`
+	Trailer *archiveTrailer `cbor:"3,keyasint,omitempty"`
+}
+
+// ErrRecordExists is returned by ImportArchive when a record in the archive
+// is already stored and overwriting wasn't forced.
+var ErrRecordExists = errors.New("record already exists")
+
+// ExportArchive writes every record held by store to w, returning the number
+// of records written.
+func ExportArchive(ctx context.Context, store RecordScanner, realmID types.RealmID, w io.Writer) (uint64, error) {
+	ctx, span := otel.StartSpan(ctx, "ExportArchive")
+	defer span.End()
+
+	encoder := cbor.NewEncoder(w)
+	err := encoder.Encode(archiveItem{Header: &ArchiveHeader{
+		Format:  archiveFormat,
+		Version: archiveVersion,
+		RealmID: realmID,
+	}})
+	if err != nil {
+		return 0, otel.RecordOutcome(err, span)
+	}
+
+	digest := sha256.New()
+	var count uint64
+	err = store.ScanRecords(ctx, "", func(recordID UserRecordID, serializedUserRecord []byte) error {
+		item, err := cbor.Marshal(archiveItem{Record: &ArchiveRecord{
+			RecordID:             recordID,
+			SerializedUserRecord: serializedUserRecord,
+		}})
+		if err != nil {
+			return err
+		}
+		digest.Write(item)
+		count++
+		_, err = w.Write(item)
+		return err
+	})
+	if err != nil {
+		return count, otel.RecordOutcome(err, span)
+	}
+	span.SetAttributes(attribute.Int64("count", int64(count)))
+
+	err = encoder.Encode(archiveItem{Trailer: &archiveTrailer{
+		Count:  count,
+		Digest: digest.Sum(nil),
+	}})
+	return count, otel.RecordOutcome(err, span)
+}
+
+// ReadArchive calls fn with each record in the archive read from r, and
+// returns the archive's header. The archive's digest can only be checked
+// once every record has been read, so it should be read once to verify it
+// before acting on any of its records.
+func ReadArchive(r io.Reader, fn func(ArchiveRecord) error) (*ArchiveHeader, error) {
+	decoder := cbor.NewDecoder(r)
+
+	var item archiveItem
+	if err := decoder.Decode(&item); err != nil {
+		return nil, fmt.Errorf("error reading archive header: %w", err)
+	}
+	header := item.Header
+	if header == nil || header.Format != archiveFormat {
+		return nil, errors.New("not a record archive")
+	}
+	if header.Version != archiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", header.Version)
+	}
+
+	digest := sha256.New()
+	var count uint64
+	for {
+		var raw cbor.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return header, errors.New("archive is truncated")
+			}
+			return header, fmt.Errorf("error reading archive: %w", err)
+		}
+
+		var item archiveItem
+		if err := cbor.Unmarshal(raw, &item); err != nil {
+			return header, fmt.Errorf("error reading archive: %w", err)
+		}
+
+		switch {
+		case item.Record != nil:
+			digest.Write(raw)
+			count++
+			if err := fn(*item.Record); err != nil {
+				return header, err
+			}
+		case item.Trailer != nil:
+			if item.Trailer.Count != count || !bytes.Equal(item.Trailer.Digest, digest.Sum(nil)) {
+				return header, errors.New("archive checksum mismatch")
+			}
+			if err := decoder.Skip(); !errors.Is(err, io.EOF) {
+				return header, errors.New("archive has unexpected data after its trailer")
+			}
+			return header, nil
+		default:
+			return header, errors.New("archive contains an unexpected item")
+		}
+	}
+}
+
+// ImportStats counts the records handled by ImportArchive.
+type ImportStats struct {
+	Created     uint64
+	Overwritten uint64
+}
+
+// ImportArchive verifies the archive returned by open, then writes its
+// records to store. Unless force is set, nothing is written if any of the
+// archive's records are already stored.
+func ImportArchive(ctx context.Context, store RecordStore, open func() (io.ReadCloser, error), force bool) (*ArchiveHeader, ImportStats, error) {
+	ctx, span := otel.StartSpan(ctx, "ImportArchive")
+	defer span.End()
+
+	var stats ImportStats
+
+	// the first pass checks the whole archive before anything is written
+	var existing uint64
+	header, err := readArchiveFrom(open, func(record ArchiveRecord) error {
+		var userRecord UserRecord
+		if err := cbor.Unmarshal(record.SerializedUserRecord, &userRecord); err != nil {
+			return fmt.Errorf("record %s is invalid: %w", record.RecordID, err)
+		}
+		if force {
+			return nil
+		}
+		_, readRecord, err := store.GetRecord(ctx, record.RecordID)
+		if err != nil {
+			return err
+		}
+		if readRecord != nil {
+			existing++
+		}
+		return nil
+	})
+	if err != nil {
+		return header, stats, otel.RecordOutcome(err, span)
+	}
+	if existing > 0 {
+		err := fmt.Errorf("%w: %d of the archive's records are already stored", ErrRecordExists, existing)
+		return header, stats, otel.RecordOutcome(err, span)
+	}
+
+	_, err = readArchiveFrom(open, func(record ArchiveRecord) error {
+		var userRecord UserRecord
+		if err := cbor.Unmarshal(record.SerializedUserRecord, &userRecord); err != nil {
+			return err
+		}
+
+		var readRecord interface{}
+		if force {
+			var err error
+			_, readRecord, err = store.GetRecord(ctx, record.RecordID)
+			if err != nil {
+				return err
+			}
+		}
+		// without force, a record created since the first pass fails the write
+		err := store.WriteRecord(ctx, record.RecordID, userRecord, readRecord)
+		if errors.Is(err, ErrRecordConflict) && !force {
+			return fmt.Errorf("%w: record %s was stored during the import", ErrRecordExists, record.RecordID)
+		}
+		if err != nil {
+			return fmt.Errorf("error writing record %s: %w", record.RecordID, err)
+		}
+		if readRecord == nil {
+			stats.Created++
+		} else {
+			stats.Overwritten++
+		}
+		return nil
+	})
+	span.SetAttributes(
+		attribute.Int64("created", int64(stats.Created)),
+		attribute.Int64("overwritten", int64(stats.Overwritten)),
+	)
+	return header, stats, otel.RecordOutcome(err, span)
+}
+
+func readArchiveFrom(open func() (io.ReadCloser, error), fn func(ArchiveRecord) error) (*ArchiveHeader, error) {
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ReadArchive(r, fn)
+}
@@ -0,0 +1,83 @@
+package records
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchive(t *testing.T) {
+	ctx := context.Background()
+	realmID := types.RealmID(makeRepeatingByteArray(9, 16))
+
+	source := NewMemoryRecordStore()
+	registered := UserRecord{
+		RegistrationState: Registered{
+			GuessCount: 2,
+			Policy:     types.Policy{NumGuesses: 5},
+		},
+	}
+	assert.NoError(t, source.WriteRecord(ctx, "a", registered, nil))
+	assert.NoError(t, source.WriteRecord(ctx, "b", UserRecord{RegistrationState: NoGuesses{}}, nil))
+
+	var archive bytes.Buffer
+	count, err := ExportArchive(ctx, source.(RecordScanner), realmID, &archive)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), count)
+
+	open := func(contents []byte) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(contents)), nil
+		}
+	}
+
+	destination := NewMemoryRecordStore()
+	header, stats, err := ImportArchive(ctx, destination, open(archive.Bytes()), false)
+	assert.NoError(t, err)
+	assert.Equal(t, realmID, header.RealmID)
+	assert.Equal(t, ImportStats{Created: 2}, stats)
+
+	record, _, err := destination.GetRecord(ctx, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, registered, record)
+
+	// importing again refuses to overwrite anything
+	assert.NoError(t, source.WriteRecord(ctx, "c", DefaultUserRecord(), nil))
+	archive.Reset()
+	_, err = ExportArchive(ctx, source.(RecordScanner), realmID, &archive)
+	assert.NoError(t, err)
+
+	_, _, err = ImportArchive(ctx, destination, open(archive.Bytes()), false)
+	assert.ErrorIs(t, err, ErrRecordExists)
+	assert.EqualError(t, err, "record already exists: 2 of the archive's records are already stored")
+	_, readRecord, err := destination.GetRecord(ctx, "c")
+	assert.NoError(t, err)
+	assert.Nil(t, readRecord)
+
+	// unless forced
+	_, stats, err = ImportArchive(ctx, destination, open(archive.Bytes()), true)
+	assert.NoError(t, err)
+	assert.Equal(t, ImportStats{Created: 1, Overwritten: 2}, stats)
+
+	// damaged archives are rejected before anything is written
+	empty := NewMemoryRecordStore()
+	truncated := archive.Bytes()[:archive.Len()-10]
+	_, _, err = ImportArchive(ctx, empty, open(truncated), false)
+	assert.Error(t, err)
+
+	corrupted := bytes.Clone(archive.Bytes())
+	corrupted[len(corrupted)-1] ^= 1
+	_, _, err = ImportArchive(ctx, empty, open(corrupted), false)
+	assert.EqualError(t, err, "archive checksum mismatch")
+
+	_, _, err = ImportArchive(ctx, empty, open([]byte{0xa0}), false)
+	assert.EqualError(t, err, "not a record archive")
+
+	_, readRecord, err = empty.GetRecord(ctx, "a")
+	assert.NoError(t, err)
+	assert.Nil(t, readRecord)
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 
@@ -25,7 +24,7 @@ type BigtableRecordStore struct {
 
 const familyName = "f"
 
-func NewBigtableRecordStore(ctx context.Context, realmID types.RealmID) (*BigtableRecordStore, error) {
+func NewBigtableRecordStore(ctx context.Context, projectID string, instanceID string, realmID types.RealmID) (*BigtableRecordStore, error) {
 	ctx, span := otel.StartSpan(
 		ctx,
 		"NewBigtableRecordStore",
@@ -34,15 +33,13 @@ func NewBigtableRecordStore(ctx context.Context, realmID types.RealmID) (*Bigtab
 	)
 	defer span.End()
 
-	projectID := os.Getenv("GCP_PROJECT_ID")
 	if projectID == "" {
-		err := errors.New("unexpectedly missing GCP_PROJECT_ID")
+		err := errors.New("unexpectedly missing GCP project ID")
 		return nil, otel.RecordOutcome(err, span)
 	}
 
-	instanceID := os.Getenv("BIGTABLE_INSTANCE_ID")
 	if instanceID == "" {
-		err := errors.New("unexpectedly missing BIGTABLE_INSTANCE_ID")
+		err := errors.New("unexpectedly missing Bigtable instance ID")
 		return nil, otel.RecordOutcome(err, span)
 	}
 
@@ -82,6 +79,20 @@ func (bt BigtableRecordStore) Close() {
 	bt.client.Close()
 }
 
+func (bt BigtableRecordStore) CheckHealth(ctx context.Context) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"CheckHealth",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemKey.String("bigtable")),
+	)
+	defer span.End()
+
+	// reading a row that doesn't exist is enough to know the table is reachable
+	_, err := bt.client.Open(bt.tableName).ReadRow(ctx, "healthcheck")
+	return otel.RecordOutcome(err, span)
+}
+
 func (bt BigtableRecordStore) GetRecord(ctx context.Context, recordID UserRecordID) (UserRecord, interface{}, error) {
 	ctx, span := otel.StartSpan(
 		ctx,
@@ -193,9 +204,47 @@ func (bt BigtableRecordStore) WriteRecord(ctx context.Context, recordID UserReco
 	// if we did not have a previous column, we want it to be false
 	desiredConditionalResult := previousColumnName != nil
 	if conditionalResult != desiredConditionalResult {
-		err := errors.New("failed to write to bigtable, record mutated since read")
+		err := fmt.Errorf("failed to write to bigtable, %w", ErrRecordConflict)
 		return otel.RecordOutcome(err, span)
 	}
 
 	return nil
 }
+
+func (bt BigtableRecordStore) ScanRecords(ctx context.Context, after UserRecordID, fn func(UserRecordID, []byte) error) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"ScanRecords",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemKey.String("bigtable")),
+	)
+	defer span.End()
+
+	table := bt.client.Open(bt.tableName)
+
+	// rows are returned in key order, and the smallest key following after
+	// is after with a zero byte appended
+	rowRange := bigtable.InfiniteRange("")
+	if after != "" {
+		rowRange = bigtable.InfiniteRange(string(after) + "\x00")
+	}
+
+	var fnErr error
+	err := table.ReadRows(
+		ctx,
+		rowRange,
+		func(row bigtable.Row) bool {
+			family, ok := row[familyName]
+			if !ok || len(family) == 0 {
+				return true
+			}
+			fnErr = fn(UserRecordID(row.Key()), family[0].Value)
+			return fnErr == nil
+		},
+		bigtable.RowFilter(bigtable.FamilyFilter(familyName)),
+	)
+	if fnErr != nil {
+		return otel.RecordOutcome(fnErr, span)
+	}
+	return otel.RecordOutcome(err, span)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -25,8 +26,15 @@ const primaryKeyName string = "recordId"
 const userRecordAttributeName string = "serializedUserRecord"
 const versionAttributeName string = "version"
 
-func NewDynamoDbRecordStore(ctx context.Context, cfg aws.Config, realmID types.RealmID) (*DynamoDbRecordStore, error) {
-	_, span := otel.StartSpan(
+// How long to wait for a newly created table to become active.
+const dynamoDbTableCreationTimeout = 5 * time.Minute
+
+// NewDynamoDbRecordStore connects to the realm's table, creating it first if
+// it doesn't exist, unless skipTableCreation is set. Creating the table needs
+// permission to describe and create it, which locked down deployments that
+// provision the table themselves may not grant.
+func NewDynamoDbRecordStore(ctx context.Context, cfg aws.Config, realmID types.RealmID, skipTableCreation bool) (*DynamoDbRecordStore, error) {
+	ctx, span := otel.StartSpan(
 		ctx,
 		"NewDynamoDbRecordStore",
 		trace.WithSpanKind(trace.SpanKindClient),
@@ -37,12 +45,124 @@ func NewDynamoDbRecordStore(ctx context.Context, cfg aws.Config, realmID types.R
 	svc := dynamodb.NewFromConfig(cfg)
 	tableName := types.JuiceboxRealmDatabasePrefix + realmID.String()
 
+	if !skipTableCreation {
+		if err := createDynamoDbTable(ctx, svc, tableName); err != nil {
+			return nil, otel.RecordOutcome(err, span)
+		}
+	}
+
 	return &DynamoDbRecordStore{
 		svc:       svc,
 		tableName: tableName,
 	}, nil
 }
 
+// Creates the table if it doesn't already exist, and waits for it to become
+// active.
+func createDynamoDbTable(ctx context.Context, svc *dynamodb.Client, tableName string) error {
+	return CreateDynamoDbTable(ctx, svc, tableName, primaryKeyName, "")
+}
+
+// CreateDynamoDbTable creates a table with a string partition key named
+// keyName if it doesn't already exist, and waits for it to become active. If
+// ttlAttribute is set, DynamoDB is asked to delete items once the time in
+// that attribute, in seconds since the epoch, has passed.
+func CreateDynamoDbTable(ctx context.Context, svc *dynamodb.Client, tableName string, keyName string, ttlAttribute string) error {
+	output, err := svc.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	var notFound *ddbTypes.ResourceNotFoundException
+	switch {
+	case err == nil:
+		// a table created by hand needs the same key as one we create
+		keySchema := output.Table.KeySchema
+		if len(keySchema) != 1 || aws.ToString(keySchema[0].AttributeName) != keyName || keySchema[0].KeyType != ddbTypes.KeyTypeHash {
+			return fmt.Errorf("table %s must have %s as its only key", tableName, keyName)
+		}
+		if output.Table.TableStatus == ddbTypes.TableStatusActive {
+			return enableDynamoDbTTL(ctx, svc, tableName, ttlAttribute)
+		}
+	case errors.As(err, &notFound):
+		_, err = svc.CreateTable(ctx, &dynamodb.CreateTableInput{
+			TableName: aws.String(tableName),
+			AttributeDefinitions: []ddbTypes.AttributeDefinition{
+				{AttributeName: aws.String(keyName), AttributeType: ddbTypes.ScalarAttributeTypeS},
+			},
+			KeySchema: []ddbTypes.KeySchemaElement{
+				{AttributeName: aws.String(keyName), KeyType: ddbTypes.KeyTypeHash},
+			},
+			BillingMode: ddbTypes.BillingModePayPerRequest,
+		})
+		// another realm process may have just created it
+		var inUse *ddbTypes.ResourceInUseException
+		if err != nil && !errors.As(err, &inUse) {
+			return fmt.Errorf("error creating table %s: %w", tableName, err)
+		}
+	default:
+		return fmt.Errorf("error describing table %s: %w", tableName, err)
+	}
+
+	err = dynamodb.NewTableExistsWaiter(svc).Wait(
+		ctx,
+		&dynamodb.DescribeTableInput{TableName: aws.String(tableName)},
+		dynamoDbTableCreationTimeout,
+	)
+	if err != nil {
+		return fmt.Errorf("error waiting for table %s to become active: %w", tableName, err)
+	}
+
+	return enableDynamoDbTTL(ctx, svc, tableName, ttlAttribute)
+}
+
+func enableDynamoDbTTL(ctx context.Context, svc *dynamodb.Client, tableName string, ttlAttribute string) error {
+	if ttlAttribute == "" {
+		return nil
+	}
+
+	output, err := svc.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("error describing TTL of table %s: %w", tableName, err)
+	}
+	if description := output.TimeToLiveDescription; description != nil {
+		switch description.TimeToLiveStatus {
+		case ddbTypes.TimeToLiveStatusEnabled, ddbTypes.TimeToLiveStatusEnabling:
+			if aws.ToString(description.AttributeName) != ttlAttribute {
+				return fmt.Errorf("table %s must use %s as its TTL attribute", tableName, ttlAttribute)
+			}
+			return nil
+		}
+	}
+
+	_, err = svc.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(tableName),
+		TimeToLiveSpecification: &ddbTypes.TimeToLiveSpecification{
+			AttributeName: aws.String(ttlAttribute),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error enabling TTL on table %s: %w", tableName, err)
+	}
+	return nil
+}
+
+func (db DynamoDbRecordStore) CheckHealth(ctx context.Context) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"CheckHealth",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemDynamoDB),
+	)
+	defer span.End()
+
+	_, err := db.svc.DescribeTable(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(db.tableName),
+	})
+	return otel.RecordOutcome(err, span)
+}
+
 func (db DynamoDbRecordStore) GetRecord(ctx context.Context, recordID UserRecordID) (UserRecord, interface{}, error) {
 	ctx, span := otel.StartSpan(
 		ctx,
@@ -175,5 +295,66 @@ func (db DynamoDbRecordStore) WriteRecord(ctx context.Context, recordID UserReco
 	}
 
 	_, err = db.svc.PutItem(ctx, input)
+	var conditionFailed *ddbTypes.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		err = fmt.Errorf("failed to write to dynamodb, %w", ErrRecordConflict)
+	}
 	return otel.RecordOutcome(err, span)
 }
+
+func (db DynamoDbRecordStore) ScanRecords(ctx context.Context, after UserRecordID, fn func(UserRecordID, []byte) error) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"ScanRecords",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemDynamoDB),
+	)
+	defer span.End()
+
+	// DynamoDB scans in its own order, but can resume from any key it has
+	// returned
+	var startKey map[string]ddbTypes.AttributeValue
+	if after != "" {
+		startKey = map[string]ddbTypes.AttributeValue{
+			primaryKeyName: &ddbTypes.AttributeValueMemberS{Value: string(after)},
+		}
+	}
+
+	for {
+		result, err := db.svc.Scan(ctx, &dynamodb.ScanInput{
+			TableName:            aws.String(db.tableName),
+			ProjectionExpression: aws.String("#primaryKey, #record"),
+			ExpressionAttributeNames: map[string]string{
+				"#primaryKey": primaryKeyName,
+				"#record":     userRecordAttributeName,
+			},
+			ExclusiveStartKey: startKey,
+			Limit:             aws.Int32(scanPageSize),
+			ConsistentRead:    aws.Bool(true),
+		})
+		if err != nil {
+			return otel.RecordOutcome(err, span)
+		}
+
+		for _, item := range result.Items {
+			recordID, ok := item[primaryKeyName].(*ddbTypes.AttributeValueMemberS)
+			if !ok {
+				err := errors.New("scanned item unexpectedly missing record id")
+				return otel.RecordOutcome(err, span)
+			}
+			serializedUserRecord, ok := item[userRecordAttributeName].(*ddbTypes.AttributeValueMemberB)
+			if !ok {
+				err := errors.New("scanned item unexpectedly missing user record")
+				return otel.RecordOutcome(err, span)
+			}
+			if err := fn(UserRecordID(recordID.Value), serializedUserRecord.Value); err != nil {
+				return otel.RecordOutcome(err, span)
+			}
+		}
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return nil
+		}
+		startKey = result.LastEvaluatedKey
+	}
+}
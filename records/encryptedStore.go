@@ -0,0 +1,156 @@
+package records
+
+import (
+	"context"
+	cryptoRand "crypto/rand"
+	"errors"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/juicebox-systems/juicebox-software-realm/kms"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// EncryptedRecordStore encrypts user records before they're written to
+// another RecordStore. Each write seals the record with a new random data
+// key, using the record ID as associated data so that records can't be
+// swapped between users. The data key is wrapped by a key encryption key
+// from a KMS, and stored alongside the record with the KEK's version.
+//
+// Records that were stored before encryption was enabled are read as they
+// are, and encrypted the next time they're written.
+type EncryptedRecordStore struct {
+	inner RecordStore
+	kms   kms.KMS
+}
+
+func NewEncryptedRecordStore(inner RecordStore, kms kms.KMS) *EncryptedRecordStore {
+	return &EncryptedRecordStore{inner: inner, kms: kms}
+}
+
+func (e *EncryptedRecordStore) GetRecord(ctx context.Context, recordID UserRecordID) (UserRecord, interface{}, error) {
+	ctx, span := otel.StartSpan(ctx, "GetEncryptedRecord")
+	defer span.End()
+
+	record, readRecord, err := e.inner.GetRecord(ctx, recordID)
+	if err != nil {
+		return record, readRecord, otel.RecordOutcome(err, span)
+	}
+
+	encrypted, ok := record.RegistrationState.(Encrypted)
+	if !ok {
+		return record, readRecord, nil
+	}
+
+	decrypted, err := e.decrypt(ctx, recordID, encrypted)
+	if err != nil {
+		return DefaultUserRecord(), readRecord, otel.RecordOutcome(err, span)
+	}
+	return decrypted, readRecord, nil
+}
+
+func (e *EncryptedRecordStore) WriteRecord(ctx context.Context, recordID UserRecordID, record UserRecord, readRecord interface{}) error {
+	ctx, span := otel.StartSpan(ctx, "WriteEncryptedRecord")
+	defer span.End()
+
+	encrypted, err := e.encrypt(ctx, recordID, record)
+	if err != nil {
+		return otel.RecordOutcome(err, span)
+	}
+
+	err = e.inner.WriteRecord(ctx, recordID, UserRecord{RegistrationState: *encrypted}, readRecord)
+	return otel.RecordOutcome(err, span)
+}
+
+// KeyVersion returns the version of the KEK the stored record was encrypted
+// with, or false if it isn't encrypted.
+func (e *EncryptedRecordStore) KeyVersion(ctx context.Context, recordID UserRecordID) (kms.KeyVersion, bool, error) {
+	record, _, err := e.inner.GetRecord(ctx, recordID)
+	if err != nil {
+		return 0, false, err
+	}
+	encrypted, ok := record.RegistrationState.(Encrypted)
+	return encrypted.KeyVersion, ok, nil
+}
+
+// ScanRecords returns the records as they're stored, still encrypted.
+func (e *EncryptedRecordStore) ScanRecords(ctx context.Context, after UserRecordID, fn func(UserRecordID, []byte) error) error {
+	if scanner, ok := e.inner.(RecordScanner); ok {
+		return scanner.ScanRecords(ctx, after, fn)
+	}
+	return ErrScanUnsupported
+}
+
+func (e *EncryptedRecordStore) Close() {
+	if closer, ok := e.inner.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+func (e *EncryptedRecordStore) CheckHealth(ctx context.Context) error {
+	if checker, ok := e.inner.(types.HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return types.ErrHealthCheckUnsupported
+}
+
+func (e *EncryptedRecordStore) encrypt(ctx context.Context, recordID UserRecordID, record UserRecord) (*Encrypted, error) {
+	plaintext, err := cbor.Marshal(&record)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := cryptoRand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := cryptoRand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	version, wrappedKey, err := e.kms.WrapKey(ctx, dataKey, []byte(recordID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Encrypted{
+		KeyVersion: version,
+		WrappedKey: wrappedKey,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, []byte(recordID)),
+	}, nil
+}
+
+func (e *EncryptedRecordStore) decrypt(ctx context.Context, recordID UserRecordID, encrypted Encrypted) (UserRecord, error) {
+	var record UserRecord
+
+	dataKey, err := e.kms.UnwrapKey(ctx, encrypted.KeyVersion, encrypted.WrappedKey, []byte(recordID))
+	if err != nil {
+		return record, err
+	}
+	aead, err := chacha20poly1305.NewX(dataKey)
+	if err != nil {
+		return record, err
+	}
+	if len(encrypted.Nonce) != aead.NonceSize() {
+		return record, errors.New("encrypted record has an invalid nonce")
+	}
+
+	plaintext, err := aead.Open(nil, encrypted.Nonce, encrypted.Ciphertext, []byte(recordID))
+	if err != nil {
+		return record, err
+	}
+	if err := cbor.Unmarshal(plaintext, &record); err != nil {
+		return record, err
+	}
+	if _, ok := record.RegistrationState.(Encrypted); ok {
+		return record, errors.New("encrypted record unexpectedly contains another encrypted record")
+	}
+	return record, nil
+}
@@ -0,0 +1,80 @@
+package records
+
+import (
+	"context"
+	"testing"
+
+	"github.com/juicebox-systems/juicebox-software-realm/kms"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedRecordStore(t *testing.T) {
+	ctx := context.Background()
+	keys := map[kms.KeyVersion][]byte{1: makeRepeatingByteArray(1, 32)}
+	kek, err := kms.NewLocalKMSWithKeys(keys)
+	assert.NoError(t, err)
+
+	inner := NewMemoryRecordStore()
+	store := NewEncryptedRecordStore(inner, kek)
+
+	registered := UserRecord{
+		RegistrationState: Registered{
+			OprfPrivateKey: types.OprfPrivateKey(makeRepeatingByteArray(7, 32)),
+			GuessCount:     1,
+			Policy:         types.Policy{NumGuesses: 5},
+		},
+	}
+
+	record, readRecord, err := store.GetRecord(ctx, "artemis")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultUserRecord(), record)
+	assert.NoError(t, store.WriteRecord(ctx, "artemis", registered, readRecord))
+
+	// the inner store only sees the sealed record
+	sealed, _, err := inner.GetRecord(ctx, "artemis")
+	assert.NoError(t, err)
+	encrypted, ok := sealed.RegistrationState.(Encrypted)
+	assert.True(t, ok)
+	assert.Equal(t, kms.KeyVersion(1), encrypted.KeyVersion)
+	assert.NotContains(t, string(encrypted.Ciphertext), string(makeRepeatingByteArray(7, 32)))
+
+	record, readRecord, err = store.GetRecord(ctx, "artemis")
+	assert.NoError(t, err)
+	assert.Equal(t, registered, record)
+
+	// a sealed record can't be moved to another user
+	assert.NoError(t, inner.WriteRecord(ctx, "apollo", sealed, nil))
+	_, _, err = store.GetRecord(ctx, "apollo")
+	assert.Error(t, err)
+
+	// after rotating the KEK, existing records can still be read and are
+	// rewritten with the new one
+	keys[2] = makeRepeatingByteArray(2, 32)
+	kek, err = kms.NewLocalKMSWithKeys(keys)
+	assert.NoError(t, err)
+	store = NewEncryptedRecordStore(inner, kek)
+
+	version, ok, err := store.KeyVersion(ctx, "artemis")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, kms.KeyVersion(1), version)
+
+	record, readRecord, err = store.GetRecord(ctx, "artemis")
+	assert.NoError(t, err)
+	assert.Equal(t, registered, record)
+	assert.NoError(t, store.WriteRecord(ctx, "artemis", record, readRecord))
+
+	version, _, err = store.KeyVersion(ctx, "artemis")
+	assert.NoError(t, err)
+	assert.Equal(t, kms.KeyVersion(2), version)
+
+	// records written before encryption was enabled are read as they are
+	assert.NoError(t, inner.WriteRecord(ctx, "hermes", registered, nil))
+	record, _, err = store.GetRecord(ctx, "hermes")
+	assert.NoError(t, err)
+	assert.Equal(t, registered, record)
+	_, ok, err = store.KeyVersion(ctx, "hermes")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
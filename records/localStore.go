@@ -0,0 +1,240 @@
+package records
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	bolt "go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// LocalRecordStore keeps user records in a single file on local disk. It's
+// intended for small self-hosted realms that run on a single machine.
+type LocalRecordStore struct {
+	db         *bolt.DB
+	bucketName []byte
+}
+
+var dbSystemLocal = semconv.DBSystemKey.String("bbolt")
+
+func NewLocalRecordStore(ctx context.Context, path string, realmID types.RealmID) (*LocalRecordStore, error) {
+	_, span := otel.StartSpan(
+		ctx,
+		"NewLocalRecordStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemLocal, attribute.String("path", path)),
+	)
+	defer span.End()
+
+	if path == "" {
+		err := errors.New("unexpectedly missing data path")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	// Only one process can have the file open at a time, so fail rather
+	// than wait forever if another realm is already using it.
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	bucketName := []byte(types.JuiceboxRealmDatabasePrefix + realmID.String())
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	return &LocalRecordStore{
+		db:         db,
+		bucketName: bucketName,
+	}, nil
+}
+
+func (l LocalRecordStore) Close() {
+	l.db.Close()
+}
+
+func (l LocalRecordStore) CheckHealth(ctx context.Context) error {
+	_, span := otel.StartSpan(
+		ctx,
+		"CheckHealth",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemLocal),
+	)
+	defer span.End()
+
+	err := l.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(l.bucketName) == nil {
+			return errors.New("records bucket unexpectedly missing")
+		}
+		return nil
+	})
+	return otel.RecordOutcome(err, span)
+}
+
+func (l LocalRecordStore) GetRecord(ctx context.Context, recordID UserRecordID) (UserRecord, interface{}, error) {
+	_, span := otel.StartSpan(
+		ctx,
+		"GetRecord",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemLocal),
+	)
+	defer span.End()
+
+	userRecord := DefaultUserRecord()
+
+	var value []byte
+	err := l.db.View(func(tx *bolt.Tx) error {
+		// the value is only valid for the life of the transaction
+		value = append(value, tx.Bucket(l.bucketName).Get([]byte(recordID))...)
+		return nil
+	})
+	if err != nil {
+		return userRecord, nil, otel.RecordOutcome(err, span)
+	}
+
+	if value == nil {
+		// no stored record yet
+		return userRecord, nil, nil
+	}
+
+	version, serializedUserRecord, err := decodeLocalValue(value)
+	if err != nil {
+		return userRecord, nil, otel.RecordOutcome(err, span)
+	}
+
+	err = cbor.Unmarshal(serializedUserRecord, &userRecord)
+	if err != nil {
+		return userRecord, version, otel.RecordOutcome(err, span)
+	}
+
+	return userRecord, version, nil
+}
+
+func (l LocalRecordStore) WriteRecord(ctx context.Context, recordID UserRecordID, record UserRecord, readRecord interface{}) error {
+	_, span := otel.StartSpan(
+		ctx,
+		"WriteRecord",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemLocal),
+	)
+	defer span.End()
+
+	serializedUserRecord, err := cbor.Marshal(record)
+	if err != nil {
+		return otel.RecordOutcome(err, span)
+	}
+
+	var newVersion uint64
+	var previousVersion *uint64
+
+	if readRecord != nil {
+		v, ok := readRecord.(uint64)
+		if !ok {
+			err := errors.New("unexpected type for read record")
+			return otel.RecordOutcome(err, span)
+		}
+		newVersion = v + 1
+		previousVersion = &v
+	}
+
+	// bbolt fsyncs the file before a read-write transaction is committed.
+	err = l.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(l.bucketName)
+
+		// We'll use the version to ensure no-one has mutated this record since we read it.
+		existing := bucket.Get([]byte(recordID))
+		if existing == nil {
+			if previousVersion != nil {
+				return fmt.Errorf("failed to write to local store, %w", ErrRecordConflict)
+			}
+		} else {
+			existingVersion, _, err := decodeLocalValue(existing)
+			if err != nil {
+				return err
+			}
+			if previousVersion == nil || existingVersion != *previousVersion {
+				return fmt.Errorf("failed to write to local store, %w", ErrRecordConflict)
+			}
+		}
+
+		return bucket.Put([]byte(recordID), encodeLocalValue(newVersion, serializedUserRecord))
+	})
+
+	return otel.RecordOutcome(err, span)
+}
+
+// Stored values are an 8 byte big-endian version followed by the serialized
+// user record.
+func encodeLocalValue(version uint64, serializedUserRecord []byte) []byte {
+	value := make([]byte, 8, 8+len(serializedUserRecord))
+	binary.BigEndian.PutUint64(value, version)
+	return append(value, serializedUserRecord...)
+}
+
+func decodeLocalValue(value []byte) (uint64, []byte, error) {
+	if len(value) < 8 {
+		return 0, nil, errors.New("stored record is unexpectedly short")
+	}
+	return binary.BigEndian.Uint64(value[:8]), value[8:], nil
+}
+
+func (l LocalRecordStore) ScanRecords(ctx context.Context, after UserRecordID, fn func(UserRecordID, []byte) error) error {
+	_, span := otel.StartSpan(
+		ctx,
+		"ScanRecords",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemLocal),
+	)
+	defer span.End()
+
+	for {
+		// fn may write records, which would deadlock if it were called while
+		// the read transaction was open, so read a page at a time.
+		var page []UserRecordID
+		var values [][]byte
+		err := l.db.View(func(tx *bolt.Tx) error {
+			cursor := tx.Bucket(l.bucketName).Cursor()
+			key, value := cursor.Seek([]byte(after))
+			if key != nil && string(key) == string(after) {
+				key, value = cursor.Next()
+			}
+			for ; key != nil && len(page) < scanPageSize; key, value = cursor.Next() {
+				// keys and values are only valid for the life of the transaction
+				page = append(page, UserRecordID(key))
+				values = append(values, append([]byte(nil), value...))
+			}
+			return nil
+		})
+		if err != nil {
+			return otel.RecordOutcome(err, span)
+		}
+
+		for i, recordID := range page {
+			_, serializedUserRecord, err := decodeLocalValue(values[i])
+			if err != nil {
+				return otel.RecordOutcome(err, span)
+			}
+			if err := fn(recordID, serializedUserRecord); err != nil {
+				return otel.RecordOutcome(err, span)
+			}
+		}
+		if len(page) < scanPageSize {
+			return nil
+		}
+		after = page[len(page)-1]
+	}
+}
@@ -0,0 +1,100 @@
+package records
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocalRecordStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "realm.db")
+	realmID := types.RealmID(makeRepeatingByteArray(7, 16))
+	recordID := UserRecordID("artemis")
+
+	store, err := NewLocalRecordStore(ctx, path, realmID)
+	assert.NoError(t, err)
+
+	// no record yet
+	record, readRecord, err := store.GetRecord(ctx, recordID)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultUserRecord(), record)
+	assert.Nil(t, readRecord)
+
+	// first write
+	noGuesses := UserRecord{RegistrationState: NoGuesses{}}
+	assert.NoError(t, store.WriteRecord(ctx, recordID, noGuesses, readRecord))
+
+	// a second write based on the same read should fail
+	err = store.WriteRecord(ctx, recordID, noGuesses, readRecord)
+	assert.EqualError(t, err, "failed to write to local store, record mutated since read")
+	assert.ErrorIs(t, err, ErrRecordConflict)
+
+	record, readRecord, err = store.GetRecord(ctx, recordID)
+	assert.NoError(t, err)
+	assert.Equal(t, noGuesses, record)
+	assert.Equal(t, uint64(0), readRecord)
+
+	// updating from the latest read succeeds, but only once
+	assert.NoError(t, store.WriteRecord(ctx, recordID, DefaultUserRecord(), readRecord))
+	err = store.WriteRecord(ctx, recordID, DefaultUserRecord(), readRecord)
+	assert.EqualError(t, err, "failed to write to local store, record mutated since read")
+	assert.ErrorIs(t, err, ErrRecordConflict)
+
+	// records survive reopening the file
+	store.Close()
+	store, err = NewLocalRecordStore(ctx, path, realmID)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	record, readRecord, err = store.GetRecord(ctx, recordID)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultUserRecord(), record)
+	assert.Equal(t, uint64(1), readRecord)
+}
+
+func TestLocalRecordStoreScan(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "realm.db")
+	store, err := NewLocalRecordStore(ctx, path, types.RealmID(makeRepeatingByteArray(7, 16)))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	// enough records to need more than one page
+	var recordIDs []UserRecordID
+	for i := 0; i < scanPageSize+10; i++ {
+		recordID, err := CreateUserRecordID("acme", fmt.Sprint(i))
+		assert.NoError(t, err)
+		recordIDs = append(recordIDs, recordID)
+		assert.NoError(t, store.WriteRecord(ctx, recordID, DefaultUserRecord(), nil))
+	}
+	slices.Sort(recordIDs)
+
+	// writing from within the scan doesn't deadlock
+	var scanned []UserRecordID
+	err = store.ScanRecords(ctx, "", func(recordID UserRecordID, serializedUserRecord []byte) error {
+		assert.Equal(t, []byte{0xa1, 0x6d, 0x4e, 0x6f, 0x74, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x65, 0x64, 0xa0}, serializedUserRecord)
+		scanned = append(scanned, recordID)
+		_, readRecord, err := store.GetRecord(ctx, recordID)
+		if err != nil {
+			return err
+		}
+		return store.WriteRecord(ctx, recordID, DefaultUserRecord(), readRecord)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, recordIDs, scanned)
+
+	// resuming starts after the given record
+	scanned = nil
+	err = store.ScanRecords(ctx, recordIDs[5], func(recordID UserRecordID, _ []byte) error {
+		scanned = append(scanned, recordID)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, recordIDs[6:], scanned)
+}
@@ -2,9 +2,11 @@ package records
 
 import (
 	"context"
-	"errors"
+	"reflect"
+	"slices"
 	"sync"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/juicebox-systems/juicebox-software-realm/otel"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
@@ -52,12 +54,50 @@ func (m *MemoryRecordStore) WriteRecord(ctx context.Context, recordID UserRecord
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	// Encrypted records hold slices, so they can't be compared with ==
 	existingRecord, exists := m.records[recordID]
-	if !exists && readRecord == nil || existingRecord == readRecord {
+	if !exists && readRecord == nil || exists && reflect.DeepEqual(existingRecord, readRecord) {
 		m.records[recordID] = record
 		return nil
 	}
 
-	err := errors.New("record was unexpectedly mutated before write")
-	return otel.RecordOutcome(err, span)
+	return otel.RecordOutcome(ErrRecordConflict, span)
+}
+
+func (m *MemoryRecordStore) ScanRecords(ctx context.Context, after UserRecordID, fn func(UserRecordID, []byte) error) error {
+	_, span := otel.StartSpan(
+		ctx,
+		"ScanRecords",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemKey.String("memory")),
+	)
+	defer span.End()
+
+	// fn may well write records, so it's called without holding the lock
+	m.lock.Lock()
+	records := make(map[UserRecordID]UserRecord)
+	for recordID, record := range m.records {
+		if recordID > after {
+			records[recordID] = record
+		}
+	}
+	m.lock.Unlock()
+
+	recordIDs := make([]UserRecordID, 0, len(records))
+	for recordID := range records {
+		recordIDs = append(recordIDs, recordID)
+	}
+	slices.Sort(recordIDs)
+
+	for _, recordID := range recordIDs {
+		record := records[recordID]
+		serializedUserRecord, err := cbor.Marshal(&record)
+		if err != nil {
+			return otel.RecordOutcome(err, span)
+		}
+		if err := fn(recordID, serializedUserRecord); err != nil {
+			return otel.RecordOutcome(err, span)
+		}
+	}
+	return nil
 }
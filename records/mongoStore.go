@@ -3,8 +3,8 @@ package records
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/url"
-	"os"
 
 	"github.com/fxamacker/cbor/v2"
 	"github.com/juicebox-systems/juicebox-software-realm/otel"
@@ -13,6 +13,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -26,7 +27,7 @@ const userRecordsCollection string = "userRecords"
 const serializedUserRecordKey string = "serializedUserRecord"
 const versionKey string = "version"
 
-func NewMongoRecordStore(ctx context.Context, realmID types.RealmID) (*MongoRecordStore, error) {
+func NewMongoRecordStore(ctx context.Context, urlString string, realmID types.RealmID) (*MongoRecordStore, error) {
 	ctx, span := otel.StartSpan(
 		ctx,
 		"NewMongoRecordStore",
@@ -35,9 +36,8 @@ func NewMongoRecordStore(ctx context.Context, realmID types.RealmID) (*MongoReco
 	)
 	defer span.End()
 
-	urlString := os.Getenv("MONGO_URL")
 	if urlString == "" {
-		err := errors.New("unexpectedly missing MONGO_URL")
+		err := errors.New("unexpectedly missing mongo URL")
 		return nil, otel.RecordOutcome(err, span)
 	}
 
@@ -74,6 +74,23 @@ func NewMongoRecordStore(ctx context.Context, realmID types.RealmID) (*MongoReco
 	}, nil
 }
 
+func (m MongoRecordStore) Close() {
+	m.client.Disconnect(context.Background())
+}
+
+func (m MongoRecordStore) CheckHealth(ctx context.Context) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"CheckHealth",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemMongoDB),
+	)
+	defer span.End()
+
+	err := m.client.Ping(ctx, readpref.Primary())
+	return otel.RecordOutcome(err, span)
+}
+
 func (m MongoRecordStore) GetRecord(ctx context.Context, recordID UserRecordID) (UserRecord, interface{}, error) {
 	ctx, span := otel.StartSpan(
 		ctx,
@@ -170,7 +187,7 @@ func (m MongoRecordStore) WriteRecord(ctx context.Context, recordID UserRecordID
 		previousVersion = &v
 	}
 
-	_, err = collection.UpdateOne(
+	result, err := collection.UpdateOne(
 		ctx,
 		// lookup a record based on the recordID and previousVersion (or nil version)
 		bson.M{
@@ -189,8 +206,57 @@ func (m MongoRecordStore) WriteRecord(ctx context.Context, recordID UserRecordID
 		options.Update().SetUpsert(previousVersion == nil),
 	)
 
+	// an upsert collides with a record created since it was read
+	if mongo.IsDuplicateKeyError(err) {
+		err := fmt.Errorf("failed to write to mongo, %w", ErrRecordConflict)
+		return otel.RecordOutcome(err, span)
+	}
 	if err != nil {
 		return otel.RecordOutcome(err, span)
 	}
+	// and an update matches nothing if the version has changed
+	if result.MatchedCount == 0 && result.UpsertedCount == 0 {
+		err := fmt.Errorf("failed to write to mongo, %w", ErrRecordConflict)
+		return otel.RecordOutcome(err, span)
+	}
 	return nil
 }
+
+func (m MongoRecordStore) ScanRecords(ctx context.Context, after UserRecordID, fn func(UserRecordID, []byte) error) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"ScanRecords",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemMongoDB),
+	)
+	defer span.End()
+
+	collection := m.client.Database(m.databaseName).Collection(userRecordsCollection)
+
+	cursor, err := collection.Find(
+		ctx,
+		bson.M{"_id": bson.M{"$gt": after}},
+		options.Find().
+			SetSort(bson.M{"_id": 1}).
+			SetProjection(bson.M{"_id": 1, serializedUserRecordKey: 1}).
+			SetBatchSize(scanPageSize),
+	)
+	if err != nil {
+		return otel.RecordOutcome(err, span)
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var result struct {
+			ID                   string `bson:"_id"`
+			SerializedUserRecord []byte `bson:"serializedUserRecord"`
+		}
+		if err := cursor.Decode(&result); err != nil {
+			return otel.RecordOutcome(err, span)
+		}
+		if err := fn(UserRecordID(result.ID), result.SerializedUserRecord); err != nil {
+			return otel.RecordOutcome(err, span)
+		}
+	}
+	return otel.RecordOutcome(cursor.Err(), span)
+}
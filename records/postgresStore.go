@@ -0,0 +1,210 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type PostgresRecordStore struct {
+	pool      *pgxpool.Pool
+	tableName string
+}
+
+const userRecordsTable string = "user_records"
+
+func NewPostgresRecordStore(ctx context.Context, urlString string, realmID types.RealmID) (*PostgresRecordStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"NewPostgresRecordStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	if urlString == "" {
+		err := errors.New("unexpectedly missing postgres URL")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	pool, err := pgxpool.New(ctx, urlString)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	// Each realm gets its own schema, in the same way that each realm gets
+	// its own database in mongo.
+	schemaName := pgx.Identifier{types.JuiceboxRealmDatabasePrefix + realmID.String()}.Sanitize()
+	tableName := schemaName + "." + pgx.Identifier{userRecordsTable}.Sanitize()
+
+	_, err = pool.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+schemaName)
+	if err != nil {
+		pool.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (
+			record_id TEXT PRIMARY KEY,
+			serialized_user_record BYTEA NOT NULL,
+			version BIGINT NOT NULL
+		)`,
+		tableName,
+	))
+	if err != nil {
+		pool.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	return &PostgresRecordStore{
+		pool:      pool,
+		tableName: tableName,
+	}, nil
+}
+
+func (p PostgresRecordStore) Close() {
+	p.pool.Close()
+}
+
+func (p PostgresRecordStore) CheckHealth(ctx context.Context) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"CheckHealth",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	err := p.pool.Ping(ctx)
+	return otel.RecordOutcome(err, span)
+}
+
+func (p PostgresRecordStore) GetRecord(ctx context.Context, recordID UserRecordID) (UserRecord, interface{}, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"GetRecord",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	userRecord := DefaultUserRecord()
+
+	var serializedUserRecord []byte
+	var version int64
+	err := p.pool.QueryRow(
+		ctx,
+		"SELECT serialized_user_record, version FROM "+p.tableName+" WHERE record_id = $1",
+		string(recordID),
+	).Scan(&serializedUserRecord, &version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// no stored record yet
+			return userRecord, nil, nil
+		}
+		return userRecord, nil, otel.RecordOutcome(err, span)
+	}
+
+	err = cbor.Unmarshal(serializedUserRecord, &userRecord)
+	if err != nil {
+		return userRecord, version, otel.RecordOutcome(err, span)
+	}
+
+	return userRecord, version, nil
+}
+
+func (p PostgresRecordStore) WriteRecord(ctx context.Context, recordID UserRecordID, record UserRecord, readRecord interface{}) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"WriteRecord",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	serializedUserRecord, err := cbor.Marshal(record)
+	if err != nil {
+		return otel.RecordOutcome(err, span)
+	}
+
+	var sql string
+	var args []interface{}
+
+	if readRecord == nil {
+		// there was no record when we read, so only insert if that's still the case
+		sql = "INSERT INTO " + p.tableName + " (record_id, serialized_user_record, version) VALUES ($1, $2, 0) ON CONFLICT (record_id) DO NOTHING"
+		args = []interface{}{string(recordID), serializedUserRecord}
+	} else {
+		// postgres doesn't support unsigned integers, but since this
+		// version can safely overflow we don't care.
+		previousVersion, ok := readRecord.(int64)
+		if !ok {
+			err := errors.New("unexpected type for read record")
+			return otel.RecordOutcome(err, span)
+		}
+
+		// We'll use this version to ensure no-one has mutated this row since we read it.
+		sql = "UPDATE " + p.tableName + " SET serialized_user_record = $2, version = $3 WHERE record_id = $1 AND version = $4"
+		args = []interface{}{string(recordID), serializedUserRecord, previousVersion + 1, previousVersion}
+	}
+
+	tag, err := p.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return otel.RecordOutcome(err, span)
+	}
+
+	if tag.RowsAffected() != 1 {
+		err := fmt.Errorf("failed to write to postgres, %w", ErrRecordConflict)
+		return otel.RecordOutcome(err, span)
+	}
+
+	return nil
+}
+
+func (p PostgresRecordStore) ScanRecords(ctx context.Context, after UserRecordID, fn func(UserRecordID, []byte) error) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"ScanRecords",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	for {
+		rows, err := p.pool.Query(
+			ctx,
+			"SELECT record_id, serialized_user_record FROM "+p.tableName+" WHERE record_id > $1 ORDER BY record_id LIMIT $2",
+			string(after),
+			scanPageSize,
+		)
+		if err != nil {
+			return otel.RecordOutcome(err, span)
+		}
+		type scannedRecord struct {
+			RecordID             string
+			SerializedUserRecord []byte
+		}
+		page, err := pgx.CollectRows(rows, pgx.RowToStructByPos[scannedRecord])
+		if err != nil {
+			return otel.RecordOutcome(err, span)
+		}
+
+		for _, record := range page {
+			if err := fn(UserRecordID(record.RecordID), record.SerializedUserRecord); err != nil {
+				return otel.RecordOutcome(err, span)
+			}
+		}
+		if len(page) < scanPageSize {
+			return nil
+		}
+		after = UserRecordID(page[len(page)-1].RecordID)
+	}
+}
@@ -0,0 +1,575 @@
+package records
+
+import (
+	"context"
+	cryptoRand "crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/redis/go-redis/v9"
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Realm IDs are stored somewhere that doesn't depend on the realm ID, so
+// that they can be found again on the next boot. Several realms can share a
+// provider, so each is stored under the name of its realm, and the ID of a
+// realm without a name is stored under a default key.
+const realmMetadataName string = types.JuiceboxRealmDatabasePrefix + "metadata"
+const realmIDKey string = "realmId"
+
+// Returns the key the ID of the named realm is stored under.
+func realmIDKeyFor(name string) string {
+	if name == "" {
+		return realmIDKey
+	}
+	return realmIDKey + ":" + name
+}
+
+// RealmIDOptional reports whether a realm with the given name can start
+// without a configured ID. The local provider's data file belongs to a single
+// realm, but a shared provider can't tell a new realm without a name apart
+// from another one that's already stored its ID, so the new realm would take
+// over the other's records.
+func RealmIDOptional(provider types.ProviderName, name string) bool {
+	return provider == types.Memory || provider == types.Local || name != ""
+}
+
+// ResolveRealmID returns the ID of the named realm stored by the record
+// store provider. On first boot the configured ID, or a random one if none
+// was configured, is stored and returned. It's an error for the configured
+// ID to differ from an ID that was previously stored, as the realm would
+// otherwise start with an empty set of records.
+//
+// Without a name, a shared provider requires a configured ID, which is
+// stored under the default key and checked the same way.
+func ResolveRealmID(ctx context.Context, provider types.ProviderName, opts types.ProviderOptions, name string, configured *types.RealmID) (types.RealmID, error) {
+	ctx, span := otel.StartSpan(ctx, "ResolveRealmID")
+	defer span.End()
+
+	var candidate types.RealmID
+	if configured != nil {
+		candidate = *configured
+	} else if !RealmIDOptional(provider, name) {
+		err := fmt.Errorf("a realm id or realm name is required to store records with the %s provider", provider)
+		return candidate, otel.RecordOutcome(err, span)
+	} else if _, err := cryptoRand.Read(candidate[:]); err != nil {
+		return candidate, otel.RecordOutcome(err, span)
+	}
+
+	if provider == types.Memory {
+		// nothing outlives the process, so there's nothing to conflict with
+		return candidate, nil
+	}
+
+	store, err := openRealmIDStore(ctx, provider, opts, true)
+	if err != nil {
+		return candidate, otel.RecordOutcome(err, span)
+	}
+	defer store.close()
+
+	realmID, err := resolveStoredRealmID(ctx, store, realmIDKeyFor(name), candidate, configured)
+	if err != nil {
+		if name == "" && provider != types.Local {
+			err = fmt.Errorf("%w, give each realm sharing the record store its own name", err)
+		}
+		return realmID, otel.RecordOutcome(err, span)
+	}
+	return realmID, nil
+}
+
+// Stores candidate under key unless an ID is already stored there, and
+// returns the stored ID.
+func resolveStoredRealmID(ctx context.Context, store realmIDStore, key string, candidate types.RealmID, configured *types.RealmID) (types.RealmID, error) {
+	if err := store.putIfAbsent(ctx, key, candidate.String()); err != nil {
+		return candidate, err
+	}
+	value, err := store.get(ctx, key)
+	if err != nil {
+		return candidate, err
+	}
+	if value == "" {
+		return candidate, errors.New("stored realm id unexpectedly missing")
+	}
+	return checkStoredRealmID(value, configured)
+}
+
+// LookupRealmID is ResolveRealmID for tools that operate on an existing
+// realm. It only reads the stored ID, and never stores one.
+func LookupRealmID(ctx context.Context, provider types.ProviderName, opts types.ProviderOptions, name string, configured *types.RealmID) (types.RealmID, error) {
+	ctx, span := otel.StartSpan(ctx, "LookupRealmID")
+	defer span.End()
+
+	if configured == nil && (provider == types.Memory || !RealmIDOptional(provider, name)) {
+		err := fmt.Errorf("a realm id or realm name is required to find the realm's records with the %s provider", provider)
+		return types.RealmID{}, otel.RecordOutcome(err, span)
+	}
+	if provider == types.Memory {
+		return *configured, nil
+	}
+
+	store, err := openRealmIDStore(ctx, provider, opts, false)
+	if err != nil {
+		return types.RealmID{}, otel.RecordOutcome(err, span)
+	}
+	defer store.close()
+
+	value, err := store.get(ctx, realmIDKeyFor(name))
+	if err != nil {
+		return types.RealmID{}, otel.RecordOutcome(err, span)
+	}
+	if value == "" {
+		if configured != nil {
+			// the realm hasn't been started yet
+			return *configured, nil
+		}
+		err := errors.New("no realm id is stored for this realm, has it been started?")
+		return types.RealmID{}, otel.RecordOutcome(err, span)
+	}
+	stored, err := checkStoredRealmID(value, configured)
+	if err != nil {
+		return stored, otel.RecordOutcome(err, span)
+	}
+	return stored, nil
+}
+
+func checkStoredRealmID(value string, configured *types.RealmID) (types.RealmID, error) {
+	stored, err := parseStoredRealmID(value)
+	if err != nil {
+		return stored, err
+	}
+	if configured != nil && stored != *configured {
+		return stored, fmt.Errorf("configured realm id %s conflicts with the realm id %s already stored by the provider", configured, stored)
+	}
+	return stored, nil
+}
+
+func parseStoredRealmID(value string) (types.RealmID, error) {
+	var realmID types.RealmID
+	decoded, err := hex.DecodeString(value)
+	if err != nil || len(decoded) != len(realmID) {
+		return realmID, fmt.Errorf("stored realm id %q is invalid", value)
+	}
+	copy(realmID[:], decoded)
+	return realmID, nil
+}
+
+// realmIDStore is where a provider keeps realm IDs.
+type realmIDStore interface {
+	// get returns the value stored under key, or "" if there isn't one.
+	get(ctx context.Context, key string) (string, error)
+	// putIfAbsent stores value under key, unless key already has a value.
+	putIfAbsent(ctx context.Context, key string, value string) error
+	close()
+}
+
+// Connects to the provider's realm ID store. Unless create is set, nothing
+// is created on the way, and a store that doesn't exist yet is empty.
+func openRealmIDStore(ctx context.Context, provider types.ProviderName, opts types.ProviderOptions, create bool) (realmIDStore, error) {
+	switch provider {
+	case types.GCP:
+		return openBigtableRealmIDStore(ctx, opts.GcpProjectID, opts.BigtableInstanceID, create)
+	case types.AWS:
+		svc := dynamodb.NewFromConfig(opts.Config.(aws.Config))
+		if create && !opts.AwsSkipTableCreation {
+			if err := CreateDynamoDbTable(ctx, svc, realmMetadataName, primaryKeyName, ""); err != nil {
+				return nil, err
+			}
+		}
+		return dynamoDbRealmIDStore{svc: svc}, nil
+	case types.Mongo:
+		return openMongoRealmIDStore(ctx, opts.MongoURL)
+	case types.Postgres:
+		return openPostgresRealmIDStore(ctx, opts.PostgresURL, create)
+	case types.Local:
+		if opts.DataPath == "" {
+			return nil, errors.New("unexpectedly missing data path")
+		}
+		return localRealmIDStore{path: opts.DataPath}, nil
+	case types.Redis:
+		client, err := newRedisClient(opts.RedisURL)
+		if err != nil {
+			return nil, err
+		}
+		return redisRealmIDStore{client: client}, nil
+	case types.S3:
+		svc, err := newS3Client(opts.Config.(aws.Config), opts.S3Bucket, s3OptionsFrom(opts))
+		if err != nil {
+			return nil, err
+		}
+		return s3RealmIDStore{svc: svc, bucket: opts.S3Bucket}, nil
+	default:
+		return nil, fmt.Errorf("unexpected provider %v", provider)
+	}
+}
+
+// localRealmIDStore opens the data file for each operation, as the record
+// store needs it to itself once the realm ID is known.
+type localRealmIDStore struct {
+	path string
+}
+
+func (s localRealmIDStore) get(_ context.Context, key string) (string, error) {
+	// bolt creates the file if it's missing, even when it's read only
+	if _, err := os.Stat(s.path); errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	db, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	var value string
+	err = db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte(realmMetadataName)); bucket != nil {
+			value = string(bucket.Get([]byte(key)))
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (s localRealmIDStore) putIfAbsent(_ context.Context, key string, value string) error {
+	db, err := bolt.Open(s.path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(realmMetadataName))
+		if err != nil {
+			return err
+		}
+		if bucket.Get([]byte(key)) != nil {
+			return nil
+		}
+		return bucket.Put([]byte(key), []byte(value))
+	})
+}
+
+func (s localRealmIDStore) close() {}
+
+type postgresRealmIDStore struct {
+	pool      *pgxpool.Pool
+	tableName string
+}
+
+func openPostgresRealmIDStore(ctx context.Context, urlString string, create bool) (*postgresRealmIDStore, error) {
+	if urlString == "" {
+		return nil, errors.New("unexpectedly missing postgres URL")
+	}
+
+	pool, err := pgxpool.New(ctx, urlString)
+	if err != nil {
+		return nil, err
+	}
+
+	tableName := pgx.Identifier{realmMetadataName}.Sanitize()
+
+	if create {
+		_, err = pool.Exec(ctx, fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s (
+				key TEXT PRIMARY KEY,
+				value TEXT NOT NULL
+			)`,
+			tableName,
+		))
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+	}
+
+	return &postgresRealmIDStore{pool: pool, tableName: tableName}, nil
+}
+
+func (s *postgresRealmIDStore) get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.pool.QueryRow(ctx, "SELECT value FROM "+s.tableName+" WHERE key = $1", key).Scan(&value)
+	var pgErr *pgconn.PgError
+	if errors.Is(err, pgx.ErrNoRows) || (errors.As(err, &pgErr) && pgErr.Code == "42P01") {
+		// no row, or no table (undefined_table)
+		return "", nil
+	}
+	return value, err
+}
+
+func (s *postgresRealmIDStore) putIfAbsent(ctx context.Context, key string, value string) error {
+	_, err := s.pool.Exec(
+		ctx,
+		"INSERT INTO "+s.tableName+" (key, value) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING",
+		key,
+		value,
+	)
+	return err
+}
+
+func (s *postgresRealmIDStore) close() {
+	s.pool.Close()
+}
+
+type mongoRealmIDStore struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func openMongoRealmIDStore(ctx context.Context, urlString string) (*mongoRealmIDStore, error) {
+	if urlString == "" {
+		return nil, errors.New("unexpectedly missing mongo URL")
+	}
+
+	url, err := url.Parse(urlString)
+	if err != nil {
+		return nil, err
+	}
+
+	// If the url names a database the realm keeps everything there,
+	// otherwise use a database shared by all realms.
+	databaseName := realmMetadataName
+	if len(url.Path) > 1 {
+		databaseName = url.Path[1:]
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(urlString))
+	if err != nil {
+		return nil, err
+	}
+
+	return &mongoRealmIDStore{
+		client:     client,
+		collection: client.Database(databaseName).Collection("realmMetadata"),
+	}, nil
+}
+
+func (s *mongoRealmIDStore) get(ctx context.Context, key string) (string, error) {
+	var result struct {
+		Value string `bson:"value"`
+	}
+	err := s.collection.FindOne(ctx, bson.M{"_id": key}).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	return result.Value, err
+}
+
+func (s *mongoRealmIDStore) putIfAbsent(ctx context.Context, key string, value string) error {
+	_, err := s.collection.InsertOne(ctx, bson.M{"_id": key, "value": value})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *mongoRealmIDStore) close() {
+	s.client.Disconnect(context.Background())
+}
+
+type bigtableRealmIDStore struct {
+	client *bigtable.Client
+	table  *bigtable.Table
+}
+
+func openBigtableRealmIDStore(ctx context.Context, projectID string, instanceID string, create bool) (*bigtableRealmIDStore, error) {
+	if projectID == "" {
+		return nil, errors.New("unexpectedly missing GCP project ID")
+	}
+	if instanceID == "" {
+		return nil, errors.New("unexpectedly missing Bigtable instance ID")
+	}
+
+	if create {
+		admin, err := bigtable.NewAdminClient(ctx, projectID, instanceID)
+		if err != nil {
+			return nil, err
+		}
+		defer admin.Close()
+
+		config := bigtable.TableConf{
+			TableID: realmMetadataName,
+			Families: map[string]bigtable.GCPolicy{
+				familyName: bigtable.MaxVersionsPolicy(1),
+			},
+		}
+		if err := admin.CreateTableFromConf(ctx, &config); err != nil {
+			if status.Code(err) != grpccodes.AlreadyExists {
+				return nil, err
+			}
+		}
+	}
+
+	client, err := bigtable.NewClient(ctx, projectID, instanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &bigtableRealmIDStore{
+		client: client,
+		table:  client.Open(realmMetadataName),
+	}, nil
+}
+
+func (s *bigtableRealmIDStore) get(ctx context.Context, key string) (string, error) {
+	row, err := s.table.ReadRow(ctx, key, bigtable.RowFilter(bigtable.ColumnFilter(realmIDKey)))
+	if status.Code(err) == grpccodes.NotFound {
+		// the table doesn't exist
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	family, ok := row[familyName]
+	if !ok || len(family) == 0 {
+		return "", nil
+	}
+	return string(family[0].Value), nil
+}
+
+func (s *bigtableRealmIDStore) putIfAbsent(ctx context.Context, key string, value string) error {
+	// only set the id if the row doesn't already have one
+	set := bigtable.NewMutation()
+	set.Set(familyName, realmIDKey, bigtable.Timestamp(0), []byte(value))
+	mutation := bigtable.NewCondMutation(bigtable.ColumnFilter(realmIDKey), nil, set)
+	return s.table.Apply(ctx, key, mutation)
+}
+
+func (s *bigtableRealmIDStore) close() {
+	s.client.Close()
+}
+
+type dynamoDbRealmIDStore struct {
+	svc *dynamodb.Client
+}
+
+func (s dynamoDbRealmIDStore) get(ctx context.Context, key string) (string, error) {
+	result, err := s.svc.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(realmMetadataName),
+		Key: map[string]ddbTypes.AttributeValue{
+			primaryKeyName: &ddbTypes.AttributeValueMemberS{Value: key},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	var notFound *ddbTypes.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	value, ok := result.Item["value"].(*ddbTypes.AttributeValueMemberS)
+	if !ok {
+		return "", nil
+	}
+	return value.Value, nil
+}
+
+func (s dynamoDbRealmIDStore) putIfAbsent(ctx context.Context, key string, value string) error {
+	_, err := s.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(realmMetadataName),
+		Item: map[string]ddbTypes.AttributeValue{
+			primaryKeyName: &ddbTypes.AttributeValueMemberS{Value: key},
+			"value":        &ddbTypes.AttributeValueMemberS{Value: value},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#primaryKey)"),
+		ExpressionAttributeNames: map[string]string{
+			"#primaryKey": primaryKeyName,
+		},
+	})
+
+	var notFound *ddbTypes.ResourceNotFoundException
+	if errors.As(err, &notFound) {
+		// only when AWS_SKIP_TABLE_CREATION is set
+		return fmt.Errorf("unable to store the realm id: create a DynamoDB table named %s with a partition key named %s", realmMetadataName, primaryKeyName)
+	}
+	var conditionFailed *ddbTypes.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &conditionFailed) {
+		return err
+	}
+	return nil
+}
+
+func (s dynamoDbRealmIDStore) close() {}
+
+type redisRealmIDStore struct {
+	client *redis.Client
+}
+
+func (s redisRealmIDStore) get(ctx context.Context, key string) (string, error) {
+	value, err := s.client.Get(ctx, realmMetadataName+":"+key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return value, err
+}
+
+func (s redisRealmIDStore) putIfAbsent(ctx context.Context, key string, value string) error {
+	return s.client.SetNX(ctx, realmMetadataName+":"+key, value, 0).Err()
+}
+
+func (s redisRealmIDStore) close() {
+	s.client.Close()
+}
+
+type s3RealmIDStore struct {
+	svc    *s3.Client
+	bucket string
+}
+
+func (s s3RealmIDStore) get(ctx context.Context, key string) (string, error) {
+	output, err := s.svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(realmMetadataName + "/" + key),
+	})
+	var noSuchKey *s3Types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer output.Body.Close()
+
+	value, err := io.ReadAll(output.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
+
+func (s s3RealmIDStore) putIfAbsent(ctx context.Context, key string, value string) error {
+	_, err := s.svc.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(realmMetadataName + "/" + key),
+		Body:        strings.NewReader(value),
+		IfNoneMatch: aws.String("*"),
+	})
+	if err != nil && !isS3PreconditionFailed(err) {
+		return err
+	}
+	return nil
+}
+
+func (s s3RealmIDStore) close() {}
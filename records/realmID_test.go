@@ -0,0 +1,193 @@
+package records
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveRealmID(t *testing.T) {
+	ctx := context.Background()
+	opts := types.ProviderOptions{DataPath: filepath.Join(t.TempDir(), "realm.db")}
+
+	// the first boot stores a random id
+	realmID, err := ResolveRealmID(ctx, types.Local, opts, "", nil)
+	assert.NoError(t, err)
+	assert.NotEqual(t, types.RealmID{}, realmID)
+
+	// which is reused on later boots
+	again, err := ResolveRealmID(ctx, types.Local, opts, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, realmID, again)
+
+	// configuring the same id is fine
+	again, err = ResolveRealmID(ctx, types.Local, opts, "", &realmID)
+	assert.NoError(t, err)
+	assert.Equal(t, realmID, again)
+
+	// but a different id is refused
+	other := types.RealmID(makeRepeatingByteArray(9, 16))
+	_, err = ResolveRealmID(ctx, types.Local, opts, "", &other)
+	assert.EqualError(t, err, "configured realm id 09090909090909090909090909090909 conflicts with the realm id "+realmID.String()+" already stored by the provider")
+
+	// the record store can still open the file afterwards
+	store, err := NewLocalRecordStore(ctx, opts.DataPath, realmID)
+	assert.NoError(t, err)
+	store.Close()
+
+	// memory realms don't outlive the process
+	memoryID, err := ResolveRealmID(ctx, types.Memory, types.ProviderOptions{}, "", &other)
+	assert.NoError(t, err)
+	assert.Equal(t, other, memoryID)
+}
+
+func TestResolveRealmIDWithoutName(t *testing.T) {
+	ctx := context.Background()
+	// nothing is connected to, so the url doesn't need to work
+	opts := types.ProviderOptions{PostgresURL: "postgres://localhost:1/postgres"}
+
+	// a shared provider can't tell unnamed realms apart, so it can't choose
+	// an id for one
+	_, err := ResolveRealmID(ctx, types.Postgres, opts, "", nil)
+	assert.EqualError(t, err, "a realm id or realm name is required to store records with the postgres provider")
+	_, err = LookupRealmID(ctx, types.Postgres, opts, "", nil)
+	assert.EqualError(t, err, "a realm id or realm name is required to find the realm's records with the postgres provider")
+
+	// a configured id is stored under the default key on the first boot
+	store := mapRealmIDStore{}
+	configured := types.RealmID(makeRepeatingByteArray(7, 16))
+	realmID, err := resolveStoredRealmID(ctx, store, realmIDKeyFor(""), configured, &configured)
+	assert.NoError(t, err)
+	assert.Equal(t, configured, realmID)
+	assert.Equal(t, configured.String(), store[realmIDKey])
+
+	// so a later boot with a different id is refused
+	other := types.RealmID(makeRepeatingByteArray(9, 16))
+	_, err = resolveStoredRealmID(ctx, store, realmIDKeyFor(""), other, &other)
+	assert.EqualError(t, err, "configured realm id 09090909090909090909090909090909 conflicts with the realm id "+configured.String()+" already stored by the provider")
+}
+
+// A realmIDStore for tests that don't have a provider to hand.
+type mapRealmIDStore map[string]string
+
+func (s mapRealmIDStore) get(_ context.Context, key string) (string, error) {
+	return s[key], nil
+}
+
+func (s mapRealmIDStore) putIfAbsent(_ context.Context, key string, value string) error {
+	if _, ok := s[key]; !ok {
+		s[key] = value
+	}
+	return nil
+}
+
+func (s mapRealmIDStore) close() {}
+
+func TestLookupRealmID(t *testing.T) {
+	ctx := context.Background()
+	opts := types.ProviderOptions{DataPath: filepath.Join(t.TempDir(), "realm.db")}
+	configured := types.RealmID(makeRepeatingByteArray(7, 16))
+
+	// nothing is stored, or created, by a lookup
+	_, err := LookupRealmID(ctx, types.Local, opts, "", nil)
+	assert.EqualError(t, err, "no realm id is stored for this realm, has it been started?")
+	realmID, err := LookupRealmID(ctx, types.Local, opts, "", &configured)
+	assert.NoError(t, err)
+	assert.Equal(t, configured, realmID)
+	_, err = os.Stat(opts.DataPath)
+	assert.True(t, os.IsNotExist(err))
+
+	// but the id stored by the realm is found
+	stored, err := ResolveRealmID(ctx, types.Local, opts, "", nil)
+	assert.NoError(t, err)
+	realmID, err = LookupRealmID(ctx, types.Local, opts, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, stored, realmID)
+	_, err = LookupRealmID(ctx, types.Local, opts, "", &configured)
+	assert.ErrorContains(t, err, "conflicts with the realm id "+stored.String())
+}
+
+func TestBigtableRealmID(t *testing.T) {
+	if os.Getenv("BIGTABLE_EMULATOR_HOST") == "" {
+		t.Skip("BIGTABLE_EMULATOR_HOST isn't set")
+	}
+	testSharedRealmIDs(t, types.GCP, types.ProviderOptions{GcpProjectID: "test-project", BigtableInstanceID: "test-instance"})
+}
+
+func TestDynamoDbRealmID(t *testing.T) {
+	// the metadata table is created on the first boot
+	testSharedRealmIDs(t, types.AWS, types.ProviderOptions{Config: newTestDynamoDbConfig(t)})
+}
+
+func TestMongoRealmID(t *testing.T) {
+	url := os.Getenv("TEST_MONGO_URL")
+	if url == "" {
+		t.Skip("TEST_MONGO_URL isn't set")
+	}
+	testSharedRealmIDs(t, types.Mongo, types.ProviderOptions{MongoURL: url})
+}
+
+func TestPostgresRealmID(t *testing.T) {
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL isn't set")
+	}
+	testSharedRealmIDs(t, types.Postgres, types.ProviderOptions{PostgresURL: url})
+}
+
+func TestRedisRealmID(t *testing.T) {
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL isn't set")
+	}
+	testSharedRealmIDs(t, types.Redis, types.ProviderOptions{RedisURL: url})
+}
+
+func TestS3RealmID(t *testing.T) {
+	cfg, bucket, s3Opts := newTestS3Bucket(t)
+	testSharedRealmIDs(t, types.S3, types.ProviderOptions{
+		Config:           cfg,
+		S3Bucket:         bucket,
+		S3Endpoint:       s3Opts.Endpoint,
+		S3ForcePathStyle: s3Opts.ForcePathStyle,
+	})
+}
+
+// testSharedRealmIDs checks that realms sharing a provider each keep their
+// own ID. The names are random, so that runs against a shared emulator don't
+// see each other's IDs.
+func testSharedRealmIDs(t *testing.T, provider types.ProviderName, opts types.ProviderOptions) {
+	ctx := context.Background()
+	acme := "acme-" + newTestRealmID(t).String()
+	globex := "globex-" + newTestRealmID(t).String()
+
+	// a lookup doesn't store anything
+	_, err := LookupRealmID(ctx, provider, opts, acme, nil)
+	assert.EqualError(t, err, "no realm id is stored for this realm, has it been started?")
+
+	acmeID, err := ResolveRealmID(ctx, provider, opts, acme, nil)
+	assert.NoError(t, err)
+	configured := newTestRealmID(t)
+	globexID, err := ResolveRealmID(ctx, provider, opts, globex, &configured)
+	assert.NoError(t, err)
+	assert.Equal(t, configured, globexID)
+	assert.NotEqual(t, acmeID, globexID)
+
+	// each realm gets its own id back
+	again, err := ResolveRealmID(ctx, provider, opts, acme, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, acmeID, again)
+	again, err = LookupRealmID(ctx, provider, opts, globex, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, globexID, again)
+
+	// and can't take another's
+	_, err = ResolveRealmID(ctx, provider, opts, acme, &globexID)
+	assert.ErrorContains(t, err, "conflicts with the realm id "+acmeID.String())
+	_, err = LookupRealmID(ctx, provider, opts, acme, &globexID)
+	assert.ErrorContains(t, err, "conflicts with the realm id "+acmeID.String())
+}
@@ -2,6 +2,7 @@ package records
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -17,23 +18,53 @@ type RecordStore interface {
 	// from the database – this must be passed to WriteRecord to ensure atomic operation.
 	GetRecord(ctx context.Context, recordID UserRecordID) (UserRecord, interface{}, error)
 	// The write will only be performed if the record in the database still matches
-	// the record that was read.
+	// the record that was read, otherwise ErrRecordConflict is returned.
 	WriteRecord(ctx context.Context, recordID UserRecordID, record UserRecord, readRecord interface{}) error
 }
 
+// ErrRecordConflict is returned by WriteRecord when the record has been
+// changed since it was read.
+var ErrRecordConflict = errors.New("record mutated since read")
+
+// RecordScanner is implemented by record stores that can list the records
+// they hold.
+type RecordScanner interface {
+	// Calls fn with the ID of each stored record and the record serialized as
+	// it's stored, in an order that's stable for the store. If after isn't
+	// empty the scan starts with the record that follows it, so an
+	// interrupted scan can be resumed from the last ID that was scanned.
+	// Returning an error from fn stops the scan.
+	ScanRecords(ctx context.Context, after UserRecordID, fn func(UserRecordID, []byte) error) error
+}
+
+// ErrScanUnsupported is returned when scanning a record store that isn't a
+// RecordScanner.
+var ErrScanUnsupported = errors.New("record store does not support scanning")
+
+// The number of records read from a store at a time while scanning.
+const scanPageSize = 1000
+
 func NewRecordStore(ctx context.Context, provider types.ProviderName, opts types.ProviderOptions, realmID types.RealmID) (RecordStore, error) {
 	ctx, span := otel.StartSpan(ctx, "NewRecordStore")
 	defer span.End()
 
 	switch provider {
 	case types.GCP:
-		return NewBigtableRecordStore(ctx, realmID)
+		return NewBigtableRecordStore(ctx, opts.GcpProjectID, opts.BigtableInstanceID, realmID)
 	case types.Memory:
 		return NewMemoryRecordStore(), nil
 	case types.AWS:
-		return NewDynamoDbRecordStore(ctx, opts.Config.(aws.Config), realmID)
+		return NewDynamoDbRecordStore(ctx, opts.Config.(aws.Config), realmID, opts.AwsSkipTableCreation)
 	case types.Mongo:
-		return NewMongoRecordStore(ctx, realmID)
+		return NewMongoRecordStore(ctx, opts.MongoURL, realmID)
+	case types.Postgres:
+		return NewPostgresRecordStore(ctx, opts.PostgresURL, realmID)
+	case types.Local:
+		return NewLocalRecordStore(ctx, opts.DataPath, realmID)
+	case types.Redis:
+		return NewRedisRecordStore(ctx, opts.RedisURL, realmID)
+	case types.S3:
+		return NewS3RecordStore(ctx, opts.Config.(aws.Config), opts.S3Bucket, s3OptionsFrom(opts), realmID)
 	}
 
 	err := fmt.Errorf("unexpected provider %v", provider)
@@ -0,0 +1,321 @@
+package records
+
+import (
+	"context"
+	cryptoRand "crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// The conformance suite checks the compare-and-swap contract that every
+// RecordStore has to honour. It runs against the memory and local stores,
+// and against the other stores when an emulator for them is configured:
+//
+//	BIGTABLE_EMULATOR_HOST=localhost:8086
+//	TEST_DYNAMODB_ENDPOINT=http://localhost:8000
+//	TEST_MONGO_URL=mongodb://localhost:27017
+//	TEST_POSTGRES_URL=postgres://postgres@localhost:5432/postgres
+//	TEST_REDIS_URL=redis://localhost:6379
+//	TEST_S3_ENDPOINT=http://localhost:9000
+
+func TestMemoryRecordStoreConformance(t *testing.T) {
+	testRecordStore(t, NewMemoryRecordStore())
+}
+
+func TestLocalRecordStoreConformance(t *testing.T) {
+	store, err := NewLocalRecordStore(context.Background(), filepath.Join(t.TempDir(), "realm.db"), newTestRealmID(t))
+	assert.NoError(t, err)
+	defer store.Close()
+	testRecordStore(t, store)
+}
+
+func TestBigtableRecordStoreConformance(t *testing.T) {
+	// the client connects to the emulator whenever this is set
+	if os.Getenv("BIGTABLE_EMULATOR_HOST") == "" {
+		t.Skip("BIGTABLE_EMULATOR_HOST isn't set")
+	}
+	store, err := NewBigtableRecordStore(context.Background(), "test-project", "test-instance", newTestRealmID(t))
+	assert.NoError(t, err)
+	defer store.Close()
+	testRecordStore(t, store)
+}
+
+func TestDynamoDbRecordStoreConformance(t *testing.T) {
+	cfg := newTestDynamoDbConfig(t)
+
+	// the table is created by the store
+	store, err := NewDynamoDbRecordStore(context.Background(), cfg, newTestRealmID(t), false)
+	assert.NoError(t, err)
+	testRecordStore(t, store)
+}
+
+// Returns the config for DynamoDB Local, skipping the test if it isn't
+// configured.
+func newTestDynamoDbConfig(t *testing.T) aws.Config {
+	endpoint := os.Getenv("TEST_DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_DYNAMODB_ENDPOINT isn't set")
+	}
+	return aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(string, string, ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: endpoint}, nil
+		}),
+	}
+}
+
+func TestMongoRecordStoreConformance(t *testing.T) {
+	url := os.Getenv("TEST_MONGO_URL")
+	if url == "" {
+		t.Skip("TEST_MONGO_URL isn't set")
+	}
+	store, err := NewMongoRecordStore(context.Background(), url, newTestRealmID(t))
+	assert.NoError(t, err)
+	defer store.Close()
+	testRecordStore(t, store)
+}
+
+func TestPostgresRecordStoreConformance(t *testing.T) {
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL isn't set")
+	}
+	store, err := NewPostgresRecordStore(context.Background(), url, newTestRealmID(t))
+	assert.NoError(t, err)
+	defer store.Close()
+	testRecordStore(t, store)
+}
+
+func TestRedisRecordStoreConformance(t *testing.T) {
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL isn't set")
+	}
+	store, err := NewRedisRecordStore(context.Background(), url, newTestRealmID(t))
+	assert.NoError(t, err)
+	defer store.Close()
+	testRecordStore(t, store)
+}
+
+func TestS3RecordStoreConformance(t *testing.T) {
+	cfg, bucket, s3Opts := newTestS3Bucket(t)
+	store, err := NewS3RecordStore(context.Background(), cfg, bucket, s3Opts, newTestRealmID(t))
+	assert.NoError(t, err)
+	testRecordStore(t, store)
+}
+
+// Returns the config for the S3-compatible service at TEST_S3_ENDPOINT, and
+// a bucket in it, skipping the test if it isn't set.
+func newTestS3Bucket(t *testing.T) (aws.Config, string, S3Options) {
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT isn't set")
+	}
+	// MinIO's default credentials
+	cfg := aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "minioadmin", SecretAccessKey: "minioadmin"}, nil
+		}),
+	}
+	s3Opts := S3Options{Endpoint: endpoint, ForcePathStyle: true}
+
+	// the bucket is shared by every run, as each uses its own realm
+	const bucket = "jb-sw-realm-test"
+	svc, err := newS3Client(cfg, bucket, s3Opts)
+	assert.NoError(t, err)
+	_, err = svc.CreateBucket(context.Background(), &s3.CreateBucketInput{Bucket: aws.String(bucket)})
+	var owned *s3Types.BucketAlreadyOwnedByYou
+	if err != nil && !errors.As(err, &owned) {
+		t.Fatal(err)
+	}
+	return cfg, bucket, s3Opts
+}
+
+// Each run gets its own realm, so that runs against a shared emulator don't
+// see each other's records.
+func newTestRealmID(t *testing.T) types.RealmID {
+	var realmID types.RealmID
+	_, err := cryptoRand.Read(realmID[:])
+	assert.NoError(t, err)
+	return realmID
+}
+
+// testRecordStore runs the conformance suite against store. Each test uses
+// its own record IDs, so the store doesn't need to start out empty.
+func testRecordStore(t *testing.T, store RecordStore) {
+	ctx := context.Background()
+	registered := func(guessCount uint16) UserRecord {
+		return UserRecord{RegistrationState: Registered{
+			Version:        types.RegistrationVersion(makeRepeatingByteArray(1, 16)),
+			OprfPrivateKey: types.OprfPrivateKey(makeRepeatingByteArray(2, 32)),
+			GuessCount:     guessCount,
+			Policy:         types.Policy{NumGuesses: 10},
+		}}
+	}
+
+	t.Run("MissingRecord", func(t *testing.T) {
+		record, readRecord, err := store.GetRecord(ctx, "missing")
+		assert.NoError(t, err)
+		assert.Equal(t, DefaultUserRecord(), record)
+		assert.Nil(t, readRecord)
+	})
+
+	t.Run("ConflictingWrites", func(t *testing.T) {
+		recordID := UserRecordID("conflicting")
+
+		_, readRecord, err := store.GetRecord(ctx, recordID)
+		assert.NoError(t, err)
+		assert.NoError(t, store.WriteRecord(ctx, recordID, registered(0), readRecord))
+
+		// a second create fails
+		err = store.WriteRecord(ctx, recordID, registered(1), readRecord)
+		assert.ErrorIs(t, err, ErrRecordConflict)
+
+		record, firstRead, err := store.GetRecord(ctx, recordID)
+		assert.NoError(t, err)
+		assert.Equal(t, registered(0), record)
+		assert.NotNil(t, firstRead)
+
+		// updating from the latest read succeeds, but only once
+		assert.NoError(t, store.WriteRecord(ctx, recordID, registered(1), firstRead))
+		err = store.WriteRecord(ctx, recordID, registered(2), firstRead)
+		assert.ErrorIs(t, err, ErrRecordConflict)
+
+		record, _, err = store.GetRecord(ctx, recordID)
+		assert.NoError(t, err)
+		assert.Equal(t, registered(1), record)
+	})
+
+	t.Run("RacingCreates", func(t *testing.T) {
+		const writers = 8
+		recordID := UserRecordID("racing")
+
+		var wg sync.WaitGroup
+		results := make([]error, writers)
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i] = store.WriteRecord(ctx, recordID, registered(uint16(i)), nil)
+			}(i)
+		}
+		wg.Wait()
+
+		// exactly one writer creates the record, and it's the one that's kept
+		winner := -1
+		for i, err := range results {
+			if err == nil {
+				assert.Equal(t, -1, winner, "more than one create succeeded")
+				winner = i
+			} else {
+				assert.ErrorIs(t, err, ErrRecordConflict)
+			}
+		}
+		if assert.NotEqual(t, -1, winner, "no create succeeded") {
+			record, _, err := store.GetRecord(ctx, recordID)
+			assert.NoError(t, err)
+			assert.Equal(t, registered(uint16(winner)), record)
+		}
+	})
+
+	t.Run("ConcurrentUpdates", func(t *testing.T) {
+		const writers = 8
+		recordID := UserRecordID("concurrent")
+		assert.NoError(t, store.WriteRecord(ctx, recordID, registered(0), nil))
+
+		// each writer retries until its increment lands, so none are lost
+		var wg sync.WaitGroup
+		errs := make([]error, writers)
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for {
+					record, readRecord, err := store.GetRecord(ctx, recordID)
+					if err != nil {
+						errs[i] = err
+						return
+					}
+					state := record.RegistrationState.(Registered)
+					err = store.WriteRecord(ctx, recordID, registered(state.GuessCount+1), readRecord)
+					if !errors.Is(err, ErrRecordConflict) {
+						errs[i] = err
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			assert.NoError(t, err)
+		}
+		record, _, err := store.GetRecord(ctx, recordID)
+		assert.NoError(t, err)
+		assert.Equal(t, registered(writers), record)
+	})
+
+	t.Run("LargeRecord", func(t *testing.T) {
+		recordID := UserRecordID("large")
+		ciphertext := make([]byte, 128*1024)
+		_, err := cryptoRand.Read(ciphertext)
+		assert.NoError(t, err)
+		large := UserRecord{RegistrationState: Encrypted{
+			KeyVersion: 1,
+			WrappedKey: makeRepeatingByteArray(3, 72),
+			Nonce:      makeRepeatingByteArray(4, 24),
+			Ciphertext: ciphertext,
+		}}
+
+		assert.NoError(t, store.WriteRecord(ctx, recordID, large, nil))
+		record, _, err := store.GetRecord(ctx, recordID)
+		assert.NoError(t, err)
+		assert.Equal(t, large, record)
+	})
+
+	t.Run("ScanRecords", func(t *testing.T) {
+		scanner, ok := store.(RecordScanner)
+		if !ok {
+			t.Skip("store doesn't support scanning")
+		}
+
+		var recordIDs []UserRecordID
+		for i := 0; i < 5; i++ {
+			recordID := UserRecordID(fmt.Sprintf("scanned-%d", i))
+			recordIDs = append(recordIDs, recordID)
+			assert.NoError(t, store.WriteRecord(ctx, recordID, registered(uint16(i)), nil))
+		}
+
+		var scanned []UserRecordID
+		err := scanner.ScanRecords(ctx, "", func(recordID UserRecordID, _ []byte) error {
+			scanned = append(scanned, recordID)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Subset(t, scanned, recordIDs)
+
+		// the order is up to the store, but resuming carries on from where
+		// the scan left off
+		var resumed []UserRecordID
+		err = scanner.ScanRecords(ctx, scanned[2], func(recordID UserRecordID, _ []byte) error {
+			resumed = append(resumed, recordID)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, scanned[3:], resumed)
+	})
+}
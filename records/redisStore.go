@@ -0,0 +1,247 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/redis/go-redis/v9"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RedisRecordStore keeps each record in a hash holding the serialized record
+// and a version, which a Lua script compares before each write. The IDs of
+// the stored records are also kept in a sorted set, so that they can be
+// scanned in order.
+//
+// Every key is prefixed with the realm ID, so that several realms can share a
+// server. The store connects to a single server, and Redis Cluster isn't
+// supported.
+type RedisRecordStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+const redisRecordField string = "record"
+const redisVersionField string = "version"
+
+var dbSystemRedis = semconv.DBSystemKey.String("redis")
+
+// Writes the record in ARGV[2] with the version in ARGV[3] if the stored
+// version still matches ARGV[1], which is empty if there was no record when
+// it was read. Returns 1 if the record was written.
+var redisWriteScript = redis.NewScript(`
+local version = redis.call('HGET', KEYS[1], 'version')
+if ARGV[1] == '' then
+	if version then
+		return 0
+	end
+elseif version ~= ARGV[1] then
+	return 0
+end
+redis.call('HSET', KEYS[1], 'record', ARGV[2], 'version', ARGV[3])
+redis.call('ZADD', KEYS[2], 0, ARGV[4])
+return 1
+`)
+
+func NewRedisRecordStore(ctx context.Context, urlString string, realmID types.RealmID) (*RedisRecordStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"NewRedisRecordStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemRedis),
+	)
+	defer span.End()
+
+	client, err := newRedisClient(urlString)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	return &RedisRecordStore{
+		client:    client,
+		keyPrefix: "{" + types.JuiceboxRealmDatabasePrefix + realmID.String() + "}:",
+	}, nil
+}
+
+func newRedisClient(urlString string) (*redis.Client, error) {
+	if urlString == "" {
+		return nil, errors.New("unexpectedly missing redis URL")
+	}
+	opts, err := redis.ParseURL(urlString)
+	if err != nil {
+		return nil, err
+	}
+	return redis.NewClient(opts), nil
+}
+
+func (r RedisRecordStore) recordKey(recordID UserRecordID) string {
+	return r.keyPrefix + "record:" + string(recordID)
+}
+
+// The sorted set of stored record IDs. Every member has the same score, so
+// they're ordered by ID.
+func (r RedisRecordStore) indexKey() string {
+	return r.keyPrefix + "records"
+}
+
+func (r RedisRecordStore) Close() {
+	r.client.Close()
+}
+
+func (r RedisRecordStore) CheckHealth(ctx context.Context) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"CheckHealth",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemRedis),
+	)
+	defer span.End()
+
+	err := r.client.Ping(ctx).Err()
+	return otel.RecordOutcome(err, span)
+}
+
+func (r RedisRecordStore) GetRecord(ctx context.Context, recordID UserRecordID) (UserRecord, interface{}, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"GetRecord",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemRedis),
+	)
+	defer span.End()
+
+	userRecord := DefaultUserRecord()
+
+	values, err := r.client.HMGet(ctx, r.recordKey(recordID), redisRecordField, redisVersionField).Result()
+	if err != nil {
+		return userRecord, nil, otel.RecordOutcome(err, span)
+	}
+
+	serializedUserRecord, hasRecord := values[0].(string)
+	version, hasVersion := values[1].(string)
+	if !hasRecord && !hasVersion {
+		// no stored record yet
+		return userRecord, nil, nil
+	}
+	if !hasRecord || !hasVersion {
+		err := errors.New("stored record is unexpectedly missing a field")
+		return userRecord, nil, otel.RecordOutcome(err, span)
+	}
+
+	readRecord, err := strconv.ParseUint(version, 10, 64)
+	if err != nil {
+		return userRecord, nil, otel.RecordOutcome(err, span)
+	}
+
+	err = cbor.Unmarshal([]byte(serializedUserRecord), &userRecord)
+	if err != nil {
+		return userRecord, readRecord, otel.RecordOutcome(err, span)
+	}
+
+	return userRecord, readRecord, nil
+}
+
+func (r RedisRecordStore) WriteRecord(ctx context.Context, recordID UserRecordID, record UserRecord, readRecord interface{}) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"WriteRecord",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemRedis),
+	)
+	defer span.End()
+
+	serializedUserRecord, err := cbor.Marshal(record)
+	if err != nil {
+		return otel.RecordOutcome(err, span)
+	}
+
+	var newVersion uint64
+	previousVersion := ""
+
+	// We'll use this version to ensure no-one has mutated this record since we read it.
+	if readRecord != nil {
+		v, ok := readRecord.(uint64)
+		if !ok {
+			err := errors.New("unexpected type for read record")
+			return otel.RecordOutcome(err, span)
+		}
+		newVersion = v + 1
+		previousVersion = strconv.FormatUint(v, 10)
+	}
+
+	written, err := redisWriteScript.Run(
+		ctx,
+		r.client,
+		[]string{r.recordKey(recordID), r.indexKey()},
+		previousVersion,
+		serializedUserRecord,
+		strconv.FormatUint(newVersion, 10),
+		string(recordID),
+	).Int()
+	if err != nil {
+		return otel.RecordOutcome(err, span)
+	}
+
+	if written != 1 {
+		err := fmt.Errorf("failed to write to redis, %w", ErrRecordConflict)
+		return otel.RecordOutcome(err, span)
+	}
+
+	return nil
+}
+
+func (r RedisRecordStore) ScanRecords(ctx context.Context, after UserRecordID, fn func(UserRecordID, []byte) error) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"ScanRecords",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemRedis),
+	)
+	defer span.End()
+
+	for {
+		min := "-"
+		if after != "" {
+			min = "(" + string(after)
+		}
+		recordIDs, err := r.client.ZRangeByLex(ctx, r.indexKey(), &redis.ZRangeBy{
+			Min:   min,
+			Max:   "+",
+			Count: scanPageSize,
+		}).Result()
+		if err != nil {
+			return otel.RecordOutcome(err, span)
+		}
+
+		for _, recordID := range recordIDs {
+			serializedUserRecord, err := r.client.HGet(ctx, r.recordKey(UserRecordID(recordID)), redisRecordField).Bytes()
+			if errors.Is(err, redis.Nil) {
+				// records are never deleted, but the index could outlive one
+				// that was removed by hand
+				continue
+			}
+			if err != nil {
+				return otel.RecordOutcome(err, span)
+			}
+			if err := fn(UserRecordID(recordID), serializedUserRecord); err != nil {
+				return otel.RecordOutcome(err, span)
+			}
+		}
+
+		if len(recordIDs) < scanPageSize {
+			return nil
+		}
+		after = UserRecordID(recordIDs[len(recordIDs)-1])
+	}
+}
@@ -0,0 +1,106 @@
+package records
+
+import (
+	"context"
+	"errors"
+
+	"github.com/juicebox-systems/juicebox-software-realm/kms"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// How many records are rotated between checkpoints.
+const rotateCheckpointInterval = 100
+
+// How many times a record is read and written again when a concurrent
+// request changes it first.
+const rotateAttempts = 5
+
+// RotationStats counts the records seen by RotateKeys.
+type RotationStats struct {
+	Scanned uint64
+	Rotated uint64
+}
+
+// RotateKeys re-encrypts every record that isn't already encrypted with the
+// KMS's current KEK, including records stored before encryption was enabled.
+// Records are rotated through GetRecord and WriteRecord, so a concurrent
+// request for the same user fails the write rather than being overwritten,
+// and the record is read again.
+//
+// The scan starts after the given record ID. checkpoint is called with the
+// last record that was completed every so often, when the scan finishes, and
+// before an error is returned, so that an interrupted rotation can be resumed.
+func RotateKeys(ctx context.Context, store *EncryptedRecordStore, after UserRecordID, checkpoint func(UserRecordID) error) (RotationStats, error) {
+	ctx, span := otel.StartSpan(ctx, "RotateKeys")
+	defer span.End()
+
+	var stats RotationStats
+
+	current, err := store.kms.CurrentVersion(ctx)
+	if err != nil {
+		return stats, otel.RecordOutcome(err, span)
+	}
+	span.SetAttributes(attribute.Int64("key_version", int64(current)))
+
+	last := after
+	err = store.ScanRecords(ctx, after, func(recordID UserRecordID, _ []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		rotated, err := store.rotateRecord(ctx, recordID, current)
+		if err != nil {
+			return err
+		}
+		stats.Scanned++
+		if rotated {
+			stats.Rotated++
+		}
+
+		last = recordID
+		if stats.Scanned%rotateCheckpointInterval == 0 {
+			return checkpoint(last)
+		}
+		return nil
+	})
+
+	span.SetAttributes(
+		attribute.Int64("scanned", int64(stats.Scanned)),
+		attribute.Int64("rotated", int64(stats.Rotated)),
+	)
+	if checkpointErr := checkpoint(last); err == nil {
+		err = checkpointErr
+	}
+	return stats, otel.RecordOutcome(err, span)
+}
+
+// Rewrites a record with the current KEK, returning false if it was already
+// using it.
+func (e *EncryptedRecordStore) rotateRecord(ctx context.Context, recordID UserRecordID, current kms.KeyVersion) (bool, error) {
+	for attempt := 1; ; attempt++ {
+		record, readRecord, err := e.inner.GetRecord(ctx, recordID)
+		if err != nil {
+			return false, err
+		}
+
+		if encrypted, ok := record.RegistrationState.(Encrypted); ok {
+			if encrypted.KeyVersion == current {
+				return false, nil
+			}
+			record, err = e.decrypt(ctx, recordID, encrypted)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		err = e.WriteRecord(ctx, recordID, record, readRecord)
+		if err == nil {
+			return true, nil
+		}
+		// the record was changed by a request since it was read
+		if !errors.Is(err, ErrRecordConflict) || attempt == rotateAttempts {
+			return false, err
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package records
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/juicebox-systems/juicebox-software-realm/kms"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotateKeys(t *testing.T) {
+	ctx := context.Background()
+	keys := map[kms.KeyVersion][]byte{1: makeRepeatingByteArray(1, 32)}
+	kek, err := kms.NewLocalKMSWithKeys(keys)
+	assert.NoError(t, err)
+
+	inner := NewMemoryRecordStore()
+	store := NewEncryptedRecordStore(inner, kek)
+
+	registered := UserRecord{
+		RegistrationState: Registered{
+			GuessCount: 1,
+			Policy:     types.Policy{NumGuesses: 5},
+		},
+	}
+	recordIDs := []UserRecordID{"a", "b", "c", "d"}
+	for _, recordID := range recordIDs[:3] {
+		assert.NoError(t, store.WriteRecord(ctx, recordID, registered, nil))
+	}
+	// and one from before encryption was enabled
+	assert.NoError(t, inner.WriteRecord(ctx, "d", registered, nil))
+
+	keys[2] = makeRepeatingByteArray(2, 32)
+	kek, err = kms.NewLocalKMSWithKeys(keys)
+	assert.NoError(t, err)
+	store = NewEncryptedRecordStore(inner, kek)
+
+	var checkpoints []UserRecordID
+	checkpoint := func(recordID UserRecordID) error {
+		checkpoints = append(checkpoints, recordID)
+		return nil
+	}
+
+	// an interrupted rotation checkpoints where it was resumed from
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = RotateKeys(cancelled, store, "b", checkpoint)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, []UserRecordID{"b"}, checkpoints)
+
+	checkpoints = nil
+	stats, err := RotateKeys(ctx, store, "", checkpoint)
+	assert.NoError(t, err)
+	assert.Equal(t, RotationStats{Scanned: 4, Rotated: 4}, stats)
+	assert.Equal(t, []UserRecordID{"d"}, checkpoints)
+
+	// everything now uses the new KEK, and reads the same as before
+	for _, recordID := range recordIDs {
+		version, encrypted, err := store.KeyVersion(ctx, recordID)
+		assert.NoError(t, err)
+		assert.True(t, encrypted)
+		assert.Equal(t, kms.KeyVersion(2), version)
+
+		record, _, err := store.GetRecord(ctx, recordID)
+		assert.NoError(t, err)
+		assert.Equal(t, registered, record)
+	}
+
+	// so resuming has nothing left to do
+	checkpoints = nil
+	stats, err = RotateKeys(ctx, store, "b", checkpoint)
+	assert.NoError(t, err)
+	assert.Equal(t, RotationStats{Scanned: 2}, stats)
+	assert.Equal(t, []UserRecordID{"d"}, checkpoints)
+}
@@ -0,0 +1,243 @@
+package records
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// S3RecordStore keeps each record in its own object, under a prefix named
+// after the realm. The object's ETag is used as its version, and writes are
+// made conditional on it with If-Match, or with If-None-Match when creating
+// a record.
+type S3RecordStore struct {
+	svc       *s3.Client
+	bucket    string
+	keyPrefix string
+}
+
+var dbSystemS3 = semconv.DBSystemKey.String("s3")
+
+// S3Options configures the client used to reach an S3-compatible service
+// other than AWS S3, such as MinIO.
+type S3Options struct {
+	// The URL of the service, or empty to use AWS S3.
+	Endpoint string
+	// Address buckets as part of the path rather than the hostname.
+	ForcePathStyle bool
+}
+
+func s3OptionsFrom(opts types.ProviderOptions) S3Options {
+	return S3Options{Endpoint: opts.S3Endpoint, ForcePathStyle: opts.S3ForcePathStyle}
+}
+
+func NewS3RecordStore(ctx context.Context, cfg aws.Config, bucket string, s3Opts S3Options, realmID types.RealmID) (*S3RecordStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"NewS3RecordStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemS3),
+	)
+	defer span.End()
+
+	svc, err := newS3Client(cfg, bucket, s3Opts)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	_, err = svc.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err != nil {
+		err = fmt.Errorf("error accessing bucket %s: %w", bucket, err)
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	return &S3RecordStore{
+		svc:       svc,
+		bucket:    bucket,
+		keyPrefix: types.JuiceboxRealmDatabasePrefix + realmID.String() + "/",
+	}, nil
+}
+
+func newS3Client(cfg aws.Config, bucket string, s3Opts S3Options) (*s3.Client, error) {
+	if bucket == "" {
+		return nil, errors.New("unexpectedly missing S3 bucket")
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if s3Opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(s3Opts.Endpoint)
+		}
+		o.UsePathStyle = s3Opts.ForcePathStyle
+	}), nil
+}
+
+// Reports whether the service rejected a conditional write, either because
+// the condition didn't hold or because it raced with another write.
+func isS3PreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	code := apiErr.ErrorCode()
+	return code == "PreconditionFailed" || code == "ConditionalRequestConflict"
+}
+
+func (s S3RecordStore) objectKey(recordID UserRecordID) string {
+	return s.keyPrefix + string(recordID)
+}
+
+func (s S3RecordStore) CheckHealth(ctx context.Context) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"CheckHealth",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemS3),
+	)
+	defer span.End()
+
+	_, err := s.svc.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)})
+	return otel.RecordOutcome(err, span)
+}
+
+func (s S3RecordStore) GetRecord(ctx context.Context, recordID UserRecordID) (UserRecord, interface{}, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"GetRecord",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemS3),
+	)
+	defer span.End()
+
+	userRecord := DefaultUserRecord()
+
+	serializedUserRecord, etag, err := s.getObject(ctx, s.objectKey(recordID))
+	var noSuchKey *s3Types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		// no stored record yet
+		return userRecord, nil, nil
+	}
+	if err != nil {
+		return userRecord, nil, otel.RecordOutcome(err, span)
+	}
+
+	err = cbor.Unmarshal(serializedUserRecord, &userRecord)
+	if err != nil {
+		return userRecord, etag, otel.RecordOutcome(err, span)
+	}
+
+	return userRecord, etag, nil
+}
+
+func (s S3RecordStore) getObject(ctx context.Context, key string) ([]byte, string, error) {
+	output, err := s.svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer output.Body.Close()
+
+	body, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	etag := aws.ToString(output.ETag)
+	if etag == "" {
+		return nil, "", errors.New("object unexpectedly missing ETag")
+	}
+	return body, etag, nil
+}
+
+func (s S3RecordStore) WriteRecord(ctx context.Context, recordID UserRecordID, record UserRecord, readRecord interface{}) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"WriteRecord",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemS3),
+	)
+	defer span.End()
+
+	serializedUserRecord, err := cbor.Marshal(record)
+	if err != nil {
+		return otel.RecordOutcome(err, span)
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.objectKey(recordID)),
+		Body:        bytes.NewReader(serializedUserRecord),
+		ContentType: aws.String("application/cbor"),
+	}
+
+	// We'll use the ETag to ensure no-one has mutated this object since we read it.
+	if readRecord == nil {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		etag, ok := readRecord.(string)
+		if !ok {
+			err := errors.New("unexpected type for read record")
+			return otel.RecordOutcome(err, span)
+		}
+		input.IfMatch = aws.String(etag)
+	}
+
+	_, err = s.svc.PutObject(ctx, input)
+	if isS3PreconditionFailed(err) {
+		err = fmt.Errorf("failed to write to s3, %w", ErrRecordConflict)
+	}
+	return otel.RecordOutcome(err, span)
+}
+
+func (s S3RecordStore) ScanRecords(ctx context.Context, after UserRecordID, fn func(UserRecordID, []byte) error) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"ScanRecords",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemS3),
+	)
+	defer span.End()
+
+	// objects are listed in order of their keys
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(s.bucket),
+		Prefix:  aws.String(s.keyPrefix),
+		MaxKeys: aws.Int32(scanPageSize),
+	}
+	if after != "" {
+		input.StartAfter = aws.String(s.objectKey(after))
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(s.svc, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return otel.RecordOutcome(err, span)
+		}
+
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			serializedUserRecord, _, err := s.getObject(ctx, key)
+			if err != nil {
+				return otel.RecordOutcome(err, span)
+			}
+			recordID := UserRecordID(strings.TrimPrefix(key, s.keyPrefix))
+			if err := fn(recordID, serializedUserRecord); err != nil {
+				return otel.RecordOutcome(err, span)
+			}
+		}
+	}
+	return nil
+}
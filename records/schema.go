@@ -0,0 +1,112 @@
+package records
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// SchemaVersion is the newest encoding of a UserRecord this realm can read.
+//
+// Version 0 is the original encoding: a map holding a single registration
+// state keyed by its name. Later versions add a "schema_version" entry to
+// the same map. Releases before version 1 reject any key they don't know, so
+// a record can only be written with a newer version once every instance
+// sharing the record store can read it.
+const SchemaVersion uint64 = 1
+
+// LegacySchemaVersion is the encoding understood by every release, and the
+// version records are written with unless configured otherwise.
+const LegacySchemaVersion uint64 = 0
+
+const schemaVersionKey = "schema_version"
+
+// ErrUnsupportedSchemaVersion is returned when reading a record that was
+// written by a newer release.
+var ErrUnsupportedSchemaVersion = errors.New("unsupported record schema version")
+
+// A migration converts an encoded record between one schema version and the
+// next. The map holds the record's entries, without the version marker.
+type migration struct {
+	// Converts a record from the migration's version to the next.
+	up func(m map[string]cbor.RawMessage) (map[string]cbor.RawMessage, error)
+	// Converts a record from the next version back to the migration's
+	// version, so that it can still be read by older releases during a
+	// rolling upgrade.
+	down func(m map[string]cbor.RawMessage) (map[string]cbor.RawMessage, error)
+}
+
+// migrations is keyed by the version each migration starts from. There must
+// be one for every version before SchemaVersion.
+var migrations = map[uint64]migration{
+	// Version 1 only adds the version marker.
+	0: {up: unchanged, down: unchanged},
+}
+
+func unchanged(m map[string]cbor.RawMessage) (map[string]cbor.RawMessage, error) {
+	return m, nil
+}
+
+var writeSchemaVersion atomic.Uint64
+
+// SetWriteSchemaVersion sets the schema version that records are written
+// with. During a rolling upgrade it should be the newest version understood
+// by every instance sharing the record store.
+func SetWriteSchemaVersion(version uint64) error {
+	if version > SchemaVersion {
+		return fmt.Errorf("%w: this realm can write up to version %d, got %d", ErrUnsupportedSchemaVersion, SchemaVersion, version)
+	}
+	writeSchemaVersion.Store(version)
+	return nil
+}
+
+// WriteSchemaVersion returns the schema version that records are written
+// with.
+func WriteSchemaVersion() uint64 {
+	return writeSchemaVersion.Load()
+}
+
+// Upgrades the entries of an encoded record to SchemaVersion.
+func migrateUp(m map[string]cbor.RawMessage) (map[string]cbor.RawMessage, error) {
+	version := LegacySchemaVersion
+	if raw, ok := m[schemaVersionKey]; ok {
+		if err := cbor.Unmarshal(raw, &version); err != nil {
+			return nil, fmt.Errorf("invalid record schema version: %w", err)
+		}
+		delete(m, schemaVersionKey)
+	}
+	if version > SchemaVersion {
+		return nil, fmt.Errorf("%w: record has version %d, this realm can read up to version %d", ErrUnsupportedSchemaVersion, version, SchemaVersion)
+	}
+
+	for ; version < SchemaVersion; version++ {
+		var err error
+		if m, err = migrations[version].up(m); err != nil {
+			return nil, fmt.Errorf("error migrating record from schema version %d: %w", version, err)
+		}
+	}
+	return m, nil
+}
+
+// Downgrades the entries of a record encoded with SchemaVersion to the
+// version it should be written with.
+func migrateDown(m map[string]cbor.RawMessage) (map[string]cbor.RawMessage, error) {
+	target := WriteSchemaVersion()
+	for version := SchemaVersion; version > target; version-- {
+		var err error
+		if m, err = migrations[version-1].down(m); err != nil {
+			return nil, fmt.Errorf("error migrating record to schema version %d: %w", version-1, err)
+		}
+	}
+
+	if target != LegacySchemaVersion {
+		raw, err := cbor.Marshal(target)
+		if err != nil {
+			return nil, err
+		}
+		m[schemaVersionKey] = raw
+	}
+	return m, nil
+}
@@ -6,6 +6,7 @@ import (
 	"reflect"
 
 	"github.com/fxamacker/cbor/v2"
+	"github.com/juicebox-systems/juicebox-software-realm/kms"
 	"github.com/juicebox-systems/juicebox-software-realm/types"
 	"golang.org/x/crypto/blake2s"
 )
@@ -29,7 +30,7 @@ func CreateUserRecordID(tenantName string, userID string) (UserRecordID, error)
 }
 
 type UserRecord struct {
-	// oneof Registered, NotRegistered, NoGuesses
+	// oneof Registered, NotRegistered, NoGuesses, Encrypted
 	RegistrationState interface{} `cbor:"registration_state"`
 }
 
@@ -49,6 +50,24 @@ type Registered struct {
 type NoGuesses struct{}
 type NotRegistered struct{}
 
+// Encrypted is the sealed form of another registration state, as written by
+// an EncryptedRecordStore.
+type Encrypted struct {
+	KeyVersion kms.KeyVersion `cbor:"key_version"`
+	WrappedKey []byte         `cbor:"wrapped_key"`
+	Nonce      []byte         `cbor:"nonce"`
+	Ciphertext []byte         `cbor:"ciphertext"`
+}
+
+// ErrRecordEncrypted is returned for an Encrypted record that reaches code
+// expecting it to be decrypted, as happens when the realm is started without
+// the KMS its records were encrypted with. Such records must be left alone.
+var ErrRecordEncrypted = errors.New("user record is encrypted, but no KMS is configured to decrypt it")
+
+// Sorts the entries of a record, so that its encoding is deterministic once
+// it holds more than the registration state.
+var recordEncMode, _ = cbor.EncOptions{Sort: cbor.SortBytewiseLexical}.EncMode()
+
 func DefaultUserRecord() UserRecord {
 	return UserRecord{
 		RegistrationState: NotRegistered{},
@@ -56,18 +75,19 @@ func DefaultUserRecord() UserRecord {
 }
 
 func (ur *UserRecord) MarshalCBOR() ([]byte, error) {
-	var m interface{}
-
 	name := reflect.TypeOf(ur.RegistrationState).Name()
 
-	m = map[string]interface{}{name: ur.RegistrationState}
+	state, err := cbor.Marshal(ur.RegistrationState)
+	if err != nil {
+		return nil, err
+	}
 
-	data, err := cbor.Marshal(m)
+	m, err := migrateDown(map[string]cbor.RawMessage{name: state})
 	if err != nil {
 		return nil, err
 	}
 
-	return data, nil
+	return recordEncMode.Marshal(m)
 }
 
 func (ur *UserRecord) UnmarshalCBOR(data []byte) error {
@@ -77,6 +97,11 @@ func (ur *UserRecord) UnmarshalCBOR(data []byte) error {
 		return err
 	}
 
+	m, err = migrateUp(m)
+	if err != nil {
+		return err
+	}
+
 	for key, value := range m {
 		switch key {
 		case "Registered":
@@ -90,6 +115,13 @@ func (ur *UserRecord) UnmarshalCBOR(data []byte) error {
 			ur.RegistrationState = NoGuesses{}
 		case "NotRegistered":
 			ur.RegistrationState = NotRegistered{}
+		case "Encrypted":
+			var encrypted Encrypted
+			err = cbor.Unmarshal(value, &encrypted)
+			if err != nil {
+				return err
+			}
+			ur.RegistrationState = encrypted
 		default:
 			return errors.New("unexpected registration state")
 		}
@@ -3,6 +3,7 @@ package records
 import (
 	"testing"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/juicebox-systems/juicebox-software-realm/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -118,6 +119,49 @@ func TestUnmarshalCBOR(t *testing.T) {
 	assert.Nil(t, record.RegistrationState)
 }
 
+func TestSchemaVersions(t *testing.T) {
+	defer SetWriteSchemaVersion(WriteSchemaVersion())
+
+	for version := LegacySchemaVersion; version < SchemaVersion; version++ {
+		_, ok := migrations[version]
+		assert.True(t, ok, "missing migration from schema version %d", version)
+	}
+
+	legacy := []byte{0xa1, 0x69, 0x4e, 0x6f, 0x47, 0x75, 0x65, 0x73, 0x73, 0x65, 0x73, 0xa0}
+	record := UserRecord{RegistrationState: NoGuesses{}}
+
+	// records are written with the legacy encoding until configured otherwise
+	assert.Equal(t, LegacySchemaVersion, WriteSchemaVersion())
+	data, err := cbor.Marshal(&record)
+	assert.NoError(t, err)
+	assert.Equal(t, legacy, data)
+
+	assert.NoError(t, SetWriteSchemaVersion(1))
+	data, err = cbor.Marshal(&record)
+	assert.NoError(t, err)
+	// {"NoGuesses": {}, "schema_version": 1}
+	assert.Equal(t, []byte{
+		0xa2, 0x69, 0x4e, 0x6f, 0x47, 0x75, 0x65, 0x73, 0x73, 0x65, 0x73, 0xa0,
+		0x6e, 0x73, 0x63, 0x68, 0x65, 0x6d, 0x61, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x01,
+	}, data)
+
+	// both encodings can be read
+	for _, encoded := range [][]byte{legacy, data} {
+		var decoded UserRecord
+		assert.NoError(t, cbor.Unmarshal(encoded, &decoded))
+		assert.Equal(t, record, decoded)
+	}
+
+	// records written by a newer release are refused rather than misread
+	newer, err := cbor.Marshal(map[string]interface{}{"NoGuesses": map[string]interface{}{}, "schema_version": SchemaVersion + 1})
+	assert.NoError(t, err)
+	var decoded UserRecord
+	assert.ErrorIs(t, cbor.Unmarshal(newer, &decoded), ErrUnsupportedSchemaVersion)
+
+	assert.ErrorIs(t, SetWriteSchemaVersion(SchemaVersion+1), ErrUnsupportedSchemaVersion)
+	assert.Equal(t, uint64(1), WriteSchemaVersion())
+}
+
 func makeRepeatingByteArray(value byte, length int) []byte {
 	array := make([]byte, length)
 	for i := 0; i < length; i++ {
@@ -0,0 +1,117 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cloud.google.com/go/bigtable"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Bigtable table ids are limited to 50 characters, which rules out adding a
+// suffix to the realm's records table name.
+const bigtablePrefix string = "jb-sw-jti-"
+const bigtableFamily string = "s"
+const bigtableColumn string = "seen"
+
+// Each jti's cell is timestamped with its expiry, and Bigtable garbage
+// collects it this long afterwards.
+const bigtableMaxAge = time.Hour
+
+type bigtableStore struct {
+	client    *bigtable.Client
+	tableName string
+}
+
+func newBigtableStore(ctx context.Context, projectID string, instanceID string, realmID types.RealmID) (*bigtableStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"newBigtableReplayStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemKey.String("bigtable")),
+	)
+	defer span.End()
+
+	if projectID == "" {
+		err := errors.New("unexpectedly missing GCP project ID")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	if instanceID == "" {
+		err := errors.New("unexpectedly missing Bigtable instance ID")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	admin, err := bigtable.NewAdminClient(ctx, projectID, instanceID)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+	defer admin.Close()
+
+	tableName := bigtablePrefix + realmID.String()
+
+	config := bigtable.TableConf{
+		TableID: tableName,
+		Families: map[string]bigtable.GCPolicy{
+			bigtableFamily: bigtable.MaxAgePolicy(bigtableMaxAge),
+		},
+	}
+
+	if err := admin.CreateTableFromConf(ctx, &config); err != nil {
+		if status.Code(err) != grpccodes.AlreadyExists {
+			return nil, otel.RecordOutcome(err, span)
+		}
+	}
+
+	client, err := bigtable.NewClient(ctx, projectID, instanceID)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	return &bigtableStore{
+		client:    client,
+		tableName: tableName,
+	}, nil
+}
+
+func (bt *bigtableStore) Close() {
+	bt.client.Close()
+}
+
+func (bt *bigtableStore) MarkSeen(ctx context.Context, tenant string, jti string, expires time.Time) (bool, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"MarkSeen",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemKey.String("bigtable")),
+	)
+	defer span.End()
+
+	key, err := jtiKey(tenant, jti)
+	if err != nil {
+		return false, otel.RecordOutcome(err, span)
+	}
+
+	// Garbage collection is lazy, so only a cell that hasn't expired yet
+	// means the jti has been seen.
+	unexpired := bigtable.ChainFilters(
+		bigtable.ColumnFilter(bigtableColumn),
+		bigtable.TimestampRangeFilter(time.Now(), time.Time{}),
+	)
+	set := bigtable.NewMutation()
+	set.Set(bigtableFamily, bigtableColumn, bigtable.Time(expires), nil)
+	mutation := bigtable.NewCondMutation(unexpired, nil, set)
+
+	var matched bool
+	err = bt.client.Open(bt.tableName).Apply(ctx, key, mutation, bigtable.GetCondMutationResult(&matched))
+	if err != nil {
+		return false, otel.RecordOutcome(err, span)
+	}
+	return !matched, nil
+}
@@ -0,0 +1,96 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbTypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const dynamoDbTableSuffix string = "-seen-tokens"
+const dynamoDbKeyName string = "key"
+
+// DynamoDB deletes each jti some time after the time in this attribute.
+const dynamoDbExpiresName string = "expires"
+
+type dynamoDbStore struct {
+	svc       *dynamodb.Client
+	tableName string
+}
+
+func newDynamoDbStore(ctx context.Context, cfg aws.Config, realmID types.RealmID, skipTableCreation bool) (*dynamoDbStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"newDynamoDbReplayStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemDynamoDB),
+	)
+	defer span.End()
+
+	svc := dynamodb.NewFromConfig(cfg)
+	tableName := types.JuiceboxRealmDatabasePrefix + realmID.String() + dynamoDbTableSuffix
+
+	if !skipTableCreation {
+		err := records.CreateDynamoDbTable(ctx, svc, tableName, dynamoDbKeyName, dynamoDbExpiresName)
+		if err != nil {
+			return nil, otel.RecordOutcome(err, span)
+		}
+	}
+
+	return &dynamoDbStore{
+		svc:       svc,
+		tableName: tableName,
+	}, nil
+}
+
+func (db *dynamoDbStore) MarkSeen(ctx context.Context, tenant string, jti string, expires time.Time) (bool, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"MarkSeen",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemDynamoDB),
+	)
+	defer span.End()
+
+	key, err := jtiKey(tenant, jti)
+	if err != nil {
+		return false, otel.RecordOutcome(err, span)
+	}
+
+	// DynamoDB TTLs are in whole seconds, so round up. The TTL deletes items
+	// lazily, so an expired jti that's still there is taken over.
+	expiresAt := strconv.FormatInt(expires.Unix()+1, 10)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	_, err = db.svc.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(db.tableName),
+		Item: map[string]ddbTypes.AttributeValue{
+			dynamoDbKeyName:     &ddbTypes.AttributeValueMemberS{Value: key},
+			dynamoDbExpiresName: &ddbTypes.AttributeValueMemberN{Value: expiresAt},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#key) OR #expires < :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#key":     dynamoDbKeyName,
+			"#expires": dynamoDbExpiresName,
+		},
+		ExpressionAttributeValues: map[string]ddbTypes.AttributeValue{
+			":now": &ddbTypes.AttributeValueMemberN{Value: now},
+		},
+	})
+	var conditionFailed *ddbTypes.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, otel.RecordOutcome(err, span)
+	}
+	return true, nil
+}
@@ -0,0 +1,57 @@
+package replay
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// How often the memory store sweeps out expired jti's.
+const memorySweepInterval = time.Minute
+
+type memoryKey struct {
+	tenant string
+	jti    string
+}
+
+// MemoryStore remembers jti's in the realm's memory, so it only protects
+// against replays to the same realm process.
+type MemoryStore struct {
+	lock      sync.Mutex
+	seen      map[memoryKey]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		seen: make(map[memoryKey]time.Time),
+		now:  time.Now,
+	}
+}
+
+func (m *MemoryStore) MarkSeen(_ context.Context, tenant string, jti string, expires time.Time) (bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) >= memorySweepInterval {
+		m.sweep(now)
+	}
+
+	key := memoryKey{tenant: tenant, jti: jti}
+	if seenExpires, ok := m.seen[key]; ok && seenExpires.After(now) {
+		return false, nil
+	}
+	m.seen[key] = expires
+	return true, nil
+}
+
+func (m *MemoryStore) sweep(now time.Time) {
+	m.lastSweep = now
+	for key, expires := range m.seen {
+		if !expires.After(now) {
+			delete(m.seen, key)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const seenTokensCollection string = "seenTokens"
+
+type mongoStore struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func newMongoStore(ctx context.Context, urlString string, realmID types.RealmID) (*mongoStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"newMongoReplayStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemMongoDB),
+	)
+	defer span.End()
+
+	if urlString == "" {
+		err := errors.New("unexpectedly missing mongo URL")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	url, err := url.Parse(urlString)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	databaseName := types.JuiceboxRealmDatabasePrefix + realmID.String()
+	if len(url.Path) > 1 {
+		databaseName = url.Path[1:]
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(urlString))
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	collection := client.Database(databaseName).Collection(seenTokensCollection)
+
+	// mongo deletes each jti some time after it expires
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"expires": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		client.Disconnect(ctx)
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	return &mongoStore{
+		client:     client,
+		collection: collection,
+	}, nil
+}
+
+func (m *mongoStore) Close() {
+	m.client.Disconnect(context.Background())
+}
+
+func (m *mongoStore) MarkSeen(ctx context.Context, tenant string, jti string, expires time.Time) (bool, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"MarkSeen",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemMongoDB),
+	)
+	defer span.End()
+
+	key, err := jtiKey(tenant, jti)
+	if err != nil {
+		return false, otel.RecordOutcome(err, span)
+	}
+
+	// Only an expired jti matches the filter and is taken over. An unexpired
+	// one doesn't, so the upsert tries to insert a second document with the
+	// same id and fails.
+	_, err = m.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": key, "expires": bson.M{"$lte": time.Now()}},
+		bson.M{"$set": bson.M{"expires": expires}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, otel.RecordOutcome(err, span)
+	}
+	return true, nil
+}
@@ -0,0 +1,153 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const seenTokensTable string = "seen_tokens"
+
+// Postgres has no TTLs, so expired jti's are deleted this often, and the
+// deletion is given this long to run.
+const postgresSweepInterval = time.Minute
+const postgresSweepTimeout = 30 * time.Second
+
+type postgresStore struct {
+	pool      *pgxpool.Pool
+	tableName string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newPostgresStore(ctx context.Context, urlString string, realmID types.RealmID) (*postgresStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"newPostgresReplayStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	if urlString == "" {
+		err := errors.New("unexpectedly missing postgres URL")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	pool, err := pgxpool.New(ctx, urlString)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	schemaName := pgx.Identifier{types.JuiceboxRealmDatabasePrefix + realmID.String()}.Sanitize()
+	tableName := schemaName + "." + pgx.Identifier{seenTokensTable}.Sanitize()
+
+	_, err = pool.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+schemaName)
+	if err != nil {
+		pool.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (
+			key TEXT PRIMARY KEY,
+			expires TIMESTAMPTZ NOT NULL
+		)`,
+		tableName,
+	))
+	if err != nil {
+		pool.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		"CREATE INDEX IF NOT EXISTS %s ON %s (expires)",
+		pgx.Identifier{seenTokensTable + "_expires_idx"}.Sanitize(),
+		tableName,
+	))
+	if err != nil {
+		pool.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	p := &postgresStore{
+		pool:      pool,
+		tableName: tableName,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go p.sweep()
+	return p, nil
+}
+
+func (p *postgresStore) Close() {
+	close(p.stop)
+	<-p.done
+	p.pool.Close()
+}
+
+// Deletes expired jti's in the background, rather than slowing down the
+// requests that present tokens.
+func (p *postgresStore) sweep() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(postgresSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), postgresSweepTimeout)
+			_, err := p.pool.Exec(ctx, "DELETE FROM "+p.tableName+" WHERE expires < now()")
+			cancel()
+			if err != nil {
+				fmt.Printf("Failed to delete expired jti's: %v\n", err)
+			}
+		}
+	}
+}
+
+func (p *postgresStore) MarkSeen(ctx context.Context, tenant string, jti string, expires time.Time) (bool, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"MarkSeen",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	key, err := jtiKey(tenant, jti)
+	if err != nil {
+		return false, otel.RecordOutcome(err, span)
+	}
+
+	// An expired jti that hasn't been swept yet is taken over, while an
+	// unexpired one leaves nothing to return.
+	var inserted string
+	err = p.pool.QueryRow(
+		ctx,
+		"INSERT INTO "+p.tableName+" (key, expires) VALUES ($1, $2) "+
+			"ON CONFLICT (key) DO UPDATE SET expires = EXCLUDED.expires "+
+			"WHERE "+p.tableName+".expires <= now() RETURNING key",
+		key,
+		expires,
+	).Scan(&inserted)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, otel.RecordOutcome(err, span)
+	}
+	return true, nil
+}
@@ -0,0 +1,85 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/redis/go-redis/v9"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var dbSystemRedis = semconv.DBSystemKey.String("redis")
+
+type redisStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+func newRedisStore(ctx context.Context, urlString string, realmID types.RealmID) (*redisStore, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"newRedisReplayStore",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemRedis),
+	)
+	defer span.End()
+
+	if urlString == "" {
+		err := errors.New("unexpectedly missing redis URL")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	opts, err := redis.ParseURL(urlString)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+	client := redis.NewClient(opts)
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	return &redisStore{
+		client: client,
+		// the same prefix as the realm's records
+		keyPrefix: "{" + types.JuiceboxRealmDatabasePrefix + realmID.String() + "}:jti:",
+	}, nil
+}
+
+func (r *redisStore) Close() {
+	r.client.Close()
+}
+
+func (r *redisStore) MarkSeen(ctx context.Context, tenant string, jti string, expires time.Time) (bool, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"MarkSeen",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(dbSystemRedis),
+	)
+	defer span.End()
+
+	key, err := jtiKey(tenant, jti)
+	if err != nil {
+		return false, otel.RecordOutcome(err, span)
+	}
+
+	// redis deletes each jti once it expires, so an existing key is always
+	// one that's still in use
+	err = r.client.SetArgs(ctx, r.keyPrefix+key, 1, redis.SetArgs{
+		Mode:     "NX",
+		ExpireAt: expires,
+	}).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, otel.RecordOutcome(err, span)
+	}
+	return true, nil
+}
@@ -0,0 +1,153 @@
+package replay
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+)
+
+// Store remembers the jti's of tokens that have been used.
+type Store interface {
+	// MarkSeen records that the tenant's jti has been used, and needs to be
+	// remembered until expires. It returns false if the jti had already
+	// been seen.
+	MarkSeen(ctx context.Context, tenant string, jti string, expires time.Time) (bool, error)
+}
+
+// SharedSupported reports whether the provider can remember jti's for all of
+// the realm's processes. S3 isn't suited to a write on every request, and has
+// no way to expire the jti's.
+func SharedSupported(provider types.ProviderName) bool {
+	return provider != types.S3
+}
+
+// NewSharedStore creates a store that remembers jti's in the provider that
+// stores the realm's records, so that replays are detected across all of the
+// realm's processes. The jti's are kept apart from the realm's records, and
+// are deleted some time after they expire.
+func NewSharedStore(ctx context.Context, provider types.ProviderName, opts types.ProviderOptions, realmID types.RealmID) (Store, error) {
+	ctx, span := otel.StartSpan(ctx, "NewSharedReplayStore")
+	defer span.End()
+
+	switch provider {
+	case types.GCP:
+		return newBigtableStore(ctx, opts.GcpProjectID, opts.BigtableInstanceID, realmID)
+	case types.AWS:
+		return newDynamoDbStore(ctx, opts.Config.(aws.Config), realmID, opts.AwsSkipTableCreation)
+	case types.Mongo:
+		return newMongoStore(ctx, opts.MongoURL, realmID)
+	case types.Postgres:
+		return newPostgresStore(ctx, opts.PostgresURL, realmID)
+	case types.Redis:
+		return newRedisStore(ctx, opts.RedisURL, realmID)
+	case types.Memory, types.Local:
+		// these only ever run as a single process
+		return NewMemoryStore(), nil
+	}
+
+	err := fmt.Errorf("the records replay store isn't supported by the %s provider", provider)
+	return nil, otel.RecordOutcome(err, span)
+}
+
+// The shared stores key each jti by a hash of it and its tenant, which keeps
+// the keys short no matter how long the jti is.
+func jtiKey(tenant string, jti string) (string, error) {
+	type jtiSerializer struct {
+		TenantName string `cbor:"tenant_name"`
+		JTI        string `cbor:"jti"`
+	}
+
+	data, err := cbor.Marshal(jtiSerializer{tenant, jti})
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// Policy describes the extra requirements a tenant places on its tokens.
+type Policy struct {
+	// Require a jti claim, and reject tokens whose jti has already been used.
+	RequireJTI bool
+	// Reject tokens whose exp - iat is longer than this. Zero allows any
+	// lifetime.
+	MaxLifetime time.Duration
+}
+
+var ErrReplayed = errors.New("jwt 'jti' has already been used")
+
+// Guard applies each tenant's token policy.
+type Guard struct {
+	store    Store
+	fallback Policy
+	tenants  map[string]Policy
+	leeway   time.Duration
+}
+
+// NewGuard returns a guard that applies the tenant's policy, or the fallback
+// policy for tenants without one of their own. Tokens are accepted for
+// leeway after they expire, so their jti's are remembered for that much
+// longer too.
+func NewGuard(store Store, fallback Policy, tenants map[string]Policy, leeway time.Duration) *Guard {
+	return &Guard{
+		store:    store,
+		fallback: fallback,
+		tenants:  tenants,
+		leeway:   leeway,
+	}
+}
+
+// Close releases any clients held by the guard's store.
+func (g *Guard) Close() {
+	if closer, ok := g.store.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+// Check returns an error if a token from the tenant with these claims
+// should be rejected. Returns ErrReplayed if the token has been used before.
+func (g *Guard) Check(ctx context.Context, tenant string, claims jwt.RegisteredClaims) error {
+	policy, ok := g.tenants[tenant]
+	if !ok {
+		policy = g.fallback
+	}
+
+	if policy.MaxLifetime > 0 {
+		if claims.IssuedAt == nil || claims.ExpiresAt == nil {
+			return errors.New("jwt claims must include 'iat' and 'exp' fields")
+		}
+		lifetime := claims.ExpiresAt.Sub(claims.IssuedAt.Time)
+		if lifetime > policy.MaxLifetime {
+			return fmt.Errorf("jwt lifetime of %s exceeds the maximum of %s", lifetime, policy.MaxLifetime)
+		}
+	}
+
+	if policy.RequireJTI {
+		if claims.ID == "" {
+			return errors.New("jwt claims missing 'jti' field")
+		}
+		// without an expiry the jti would need to be remembered forever
+		if claims.ExpiresAt == nil {
+			return errors.New("jwt claims missing 'exp' field")
+		}
+		fresh, err := g.store.MarkSeen(ctx, tenant, claims.ID, claims.ExpiresAt.Add(g.leeway))
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrReplayed
+		}
+	}
+
+	return nil
+}
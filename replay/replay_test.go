@@ -0,0 +1,186 @@
+package replay
+
+import (
+	"context"
+	cryptoRand "crypto/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func tokenClaims(jti string, issuedAt time.Time, lifetime time.Duration) jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		ID:        jti,
+		IssuedAt:  jwt.NewNumericDate(issuedAt),
+		ExpiresAt: jwt.NewNumericDate(issuedAt.Add(lifetime)),
+	}
+}
+
+func TestGuard(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	guard := NewGuard(
+		NewMemoryStore(),
+		Policy{},
+		map[string]Policy{
+			"acme":   {RequireJTI: true},
+			"globex": {MaxLifetime: 10 * time.Minute},
+		},
+		5*time.Second,
+	)
+
+	// tenants without a policy can replay tokens
+	claims := tokenClaims("", now, time.Hour)
+	assert.NoError(t, guard.Check(ctx, "test", claims))
+	assert.NoError(t, guard.Check(ctx, "test", claims))
+
+	// tenants requiring a jti can't
+	assert.EqualError(t, guard.Check(ctx, "acme", claims), "jwt claims missing 'jti' field")
+	claims = tokenClaims("1", now, time.Hour)
+	assert.NoError(t, guard.Check(ctx, "acme", claims))
+	assert.ErrorIs(t, guard.Check(ctx, "acme", claims), ErrReplayed)
+	assert.NoError(t, guard.Check(ctx, "acme", tokenClaims("2", now, time.Hour)))
+
+	// jti's are per tenant
+	assert.NoError(t, guard.Check(ctx, "test", claims))
+
+	claims.ExpiresAt = nil
+	claims.ID = "3"
+	assert.EqualError(t, guard.Check(ctx, "acme", claims), "jwt claims missing 'exp' field")
+
+	// tenants can limit the token lifetime
+	assert.NoError(t, guard.Check(ctx, "globex", tokenClaims("", now, 10*time.Minute)))
+	assert.EqualError(t, guard.Check(ctx, "globex", tokenClaims("", now, time.Hour)), "jwt lifetime of 1h0m0s exceeds the maximum of 10m0s")
+	assert.EqualError(t, guard.Check(ctx, "globex", jwt.RegisteredClaims{}), "jwt claims must include 'iat' and 'exp' fields")
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	fresh, err := store.MarkSeen(ctx, "acme", "1", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, fresh)
+
+	fresh, err = store.MarkSeen(ctx, "acme", "1", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, fresh)
+
+	// once the token has expired it can't be replayed anyway, so it's forgotten
+	now = now.Add(2 * time.Minute)
+	fresh, err = store.MarkSeen(ctx, "acme", "2", now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.True(t, fresh)
+	assert.Len(t, store.seen, 1)
+}
+
+func TestNewSharedStore(t *testing.T) {
+	ctx := context.Background()
+
+	// single process providers remember jti's in memory
+	store, err := NewSharedStore(ctx, types.Local, types.ProviderOptions{}, types.RealmID{})
+	assert.NoError(t, err)
+	assert.IsType(t, &MemoryStore{}, store)
+
+	_, err = NewSharedStore(ctx, types.S3, types.ProviderOptions{}, types.RealmID{})
+	assert.EqualError(t, err, "the records replay store isn't supported by the s3 provider")
+}
+
+// The shared stores are tested when an emulator for them is configured, in
+// the same way as the record stores.
+
+func TestBigtableStore(t *testing.T) {
+	if os.Getenv("BIGTABLE_EMULATOR_HOST") == "" {
+		t.Skip("BIGTABLE_EMULATOR_HOST isn't set")
+	}
+	testSharedStore(t, types.GCP, types.ProviderOptions{GcpProjectID: "test-project", BigtableInstanceID: "test-instance"})
+}
+
+func TestDynamoDbStore(t *testing.T) {
+	endpoint := os.Getenv("TEST_DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_DYNAMODB_ENDPOINT isn't set")
+	}
+	cfg := aws.Config{
+		Region: "us-east-1",
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "test", SecretAccessKey: "test"}, nil
+		}),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(string, string, ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: endpoint}, nil
+		}),
+	}
+	testSharedStore(t, types.AWS, types.ProviderOptions{Config: cfg})
+}
+
+func TestMongoStore(t *testing.T) {
+	url := os.Getenv("TEST_MONGO_URL")
+	if url == "" {
+		t.Skip("TEST_MONGO_URL isn't set")
+	}
+	testSharedStore(t, types.Mongo, types.ProviderOptions{MongoURL: url})
+}
+
+func TestPostgresStore(t *testing.T) {
+	url := os.Getenv("TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL isn't set")
+	}
+	testSharedStore(t, types.Postgres, types.ProviderOptions{PostgresURL: url})
+}
+
+func TestRedisStore(t *testing.T) {
+	url := os.Getenv("TEST_REDIS_URL")
+	if url == "" {
+		t.Skip("TEST_REDIS_URL isn't set")
+	}
+	testSharedStore(t, types.Redis, types.ProviderOptions{RedisURL: url})
+}
+
+// testSharedStore checks the jti's remembered by the provider's shared store.
+// Each run uses its own realm, so that runs against a shared emulator don't
+// see each other's jti's.
+func testSharedStore(t *testing.T, provider types.ProviderName, opts types.ProviderOptions) {
+	ctx := context.Background()
+	var realmID types.RealmID
+	_, err := cryptoRand.Read(realmID[:])
+	assert.NoError(t, err)
+
+	store, err := NewSharedStore(ctx, provider, opts, realmID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	guard := NewGuard(store, Policy{}, nil, 0)
+	defer guard.Close()
+
+	expires := time.Now().Add(time.Minute)
+	fresh, err := store.MarkSeen(ctx, "acme", "1", expires)
+	assert.NoError(t, err)
+	assert.True(t, fresh)
+
+	fresh, err = store.MarkSeen(ctx, "acme", "1", expires)
+	assert.NoError(t, err)
+	assert.False(t, fresh)
+
+	// jti's are per tenant
+	fresh, err = store.MarkSeen(ctx, "globex", "1", expires)
+	assert.NoError(t, err)
+	assert.True(t, fresh)
+
+	// an expired jti is forgotten, even before the provider deletes it
+	expired := time.Now().Add(-time.Minute)
+	fresh, err = store.MarkSeen(ctx, "acme", "2", expired)
+	assert.NoError(t, err)
+	assert.True(t, fresh)
+	fresh, err = store.MarkSeen(ctx, "acme", "2", expires)
+	assert.NoError(t, err)
+	assert.True(t, fresh)
+}
@@ -40,3 +40,7 @@ type TenantLog struct {
 type TenantLogAck struct {
 	Acks []string `json:"acks"`
 }
+
+type AdminUser struct {
+	UserID string `json:"user_id"`
+}
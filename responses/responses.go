@@ -47,3 +47,22 @@ type TenantLogEntry struct {
 }
 
 type TenantLogAck struct{}
+
+type Health struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components,omitempty"`
+}
+
+type ComponentHealth struct {
+	Provider string `json:"provider,omitempty"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// AdminUser is a user record with its key material left out.
+type AdminUser struct {
+	State      string  `json:"state"`
+	Version    string  `json:"version,omitempty"`
+	NumGuesses *uint16 `json:"num_guesses,omitempty"`
+	GuessCount *uint16 `json:"guess_count,omitempty"`
+}
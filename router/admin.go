@@ -0,0 +1,253 @@
+package router
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/providers"
+	"github.com/juicebox-systems/juicebox-software-realm/pubsub"
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+	"github.com/juicebox-systems/juicebox-software-realm/requests"
+	"github.com/juicebox-systems/juicebox-software-realm/responses"
+	"github.com/juicebox-systems/juicebox-software-realm/secrets"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	echojwt "github.com/labstack/echo-jwt/v4"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// AddAdminHandlers adds the endpoints operators use to inspect and recover
+// user records. They require a token with the admin scope, signed by the
+// tenant whose users are being managed.
+func AddAdminHandlers(e *echo.Echo, realmID types.RealmID, provider *providers.Provider) {
+	jwtConfig := echojwt.Config{
+		ParseTokenFunc: func(c echo.Context, auth string) (interface{}, error) {
+			token, err := jwt.ParseWithClaims(auth, &claims{}, func(t *jwt.Token) (interface{}, error) {
+				return secrets.GetJWTSigningKey(c.Request().Context(), provider.SecretsManager, t)
+			}, jwt.WithLeeway(JWTLeeway))
+			if err != nil {
+				return nil, &echojwt.TokenError{Token: token, Err: err}
+			}
+			if !token.Valid {
+				return nil, &echojwt.TokenError{Token: token, Err: errors.New("invalid token")}
+			}
+			return token, nil
+		},
+	}
+
+	e.POST("/admin/user", func(c echo.Context) error {
+		ctx, span := otel.StartSpan(c.Request().Context(), "admin_user")
+		defer span.End()
+
+		result, err := handleAdminRequest(ctx, c, realmID, provider, nil)
+		if err != nil {
+			return types.NewHTTPError(http.StatusInternalServerError, err).ToEcho()
+		}
+		return c.JSON(http.StatusOK, result)
+
+	}, middleware.BodyLimit("32K"), echojwt.WithConfig(jwtConfig))
+
+	e.POST("/admin/user/delete", func(c echo.Context) error {
+		ctx, span := otel.StartSpan(c.Request().Context(), "admin_delete")
+		defer span.End()
+
+		result, err := handleAdminRequest(ctx, c, realmID, provider, deleteRegistration)
+		if err != nil {
+			return types.NewHTTPError(http.StatusInternalServerError, err).ToEcho()
+		}
+		return c.JSON(http.StatusOK, result)
+
+	}, middleware.BodyLimit("32K"), echojwt.WithConfig(jwtConfig))
+
+	e.POST("/admin/user/reset_guesses", func(c echo.Context) error {
+		ctx, span := otel.StartSpan(c.Request().Context(), "admin_reset_guesses")
+		defer span.End()
+
+		result, err := handleAdminRequest(ctx, c, realmID, provider, resetGuesses)
+		if err != nil {
+			return types.NewHTTPError(http.StatusInternalServerError, err).ToEcho()
+		}
+		return c.JSON(http.StatusOK, result)
+
+	}, middleware.BodyLimit("32K"), echojwt.WithConfig(jwtConfig))
+
+	e.POST("/admin/tenant/invalidate_secrets", func(c echo.Context) error {
+		ctx, span := otel.StartSpan(c.Request().Context(), "admin_invalidate_secrets")
+		defer span.End()
+
+		err := handleInvalidateSecrets(ctx, c, realmID, provider)
+		if err != nil {
+			return types.NewHTTPError(http.StatusInternalServerError, err).ToEcho()
+		}
+		return c.NoContent(http.StatusNoContent)
+
+	}, middleware.BodyLimit("32K"), echojwt.WithConfig(jwtConfig))
+}
+
+// Drops this realm's copy of the signing keys of the tenant that signed the
+// token, so that a key the tenant has revoked stops being accepted without
+// waiting for it to expire from the cache.
+func handleInvalidateSecrets(ctx context.Context, c echo.Context, realmID types.RealmID, provider *providers.Provider) error {
+	claims, err := verifyToken(c, realmID, requireScope, scopeAdmin)
+	if err != nil {
+		return types.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	tenant := claims.Issuer
+	if invalidator, ok := provider.SecretsManager.(secrets.Invalidator); ok {
+		err := invalidator.Invalidate(ctx, types.JuiceboxTenantSecretPrefix+tenant)
+		if err != nil {
+			return fmt.Errorf("error invalidating tenant secrets: %w", err)
+		}
+	}
+
+	otel.IncrementInt64Counter(
+		ctx,
+		"realm.admin.count",
+		attribute.String("tenant", tenant),
+		attribute.String("type", c.Request().URL.Path),
+	)
+	return nil
+}
+
+// An adminUpdate changes a user's record, returning the event to publish to
+// the tenant log, or nil if the record is left as it was.
+type adminUpdate func(record *records.UserRecord) *string
+
+// Removes the user's registration, which is also the only way to clear a
+// registration that has run out of guesses.
+func deleteRegistration(record *records.UserRecord) *string {
+	if _, ok := record.RegistrationState.(records.NotRegistered); ok {
+		return nil
+	}
+	record.RegistrationState = records.NotRegistered{}
+	event := "deleted"
+	return &event
+}
+
+// Clears a registration that has run out of guesses. Its secret has already
+// been discarded, so NoGuesses is reset to NotRegistered, allowing the user to
+// register again. Any other record is left as it was: resetting a registered
+// user's guess count would let whoever holds the tenant's signing key make
+// unlimited guesses.
+func resetGuesses(record *records.UserRecord) *string {
+	if _, ok := record.RegistrationState.(records.NoGuesses); !ok {
+		return nil
+	}
+	record.RegistrationState = records.NotRegistered{}
+	event := "deleted"
+	return &event
+}
+
+func handleAdminRequest(ctx context.Context, c echo.Context, realmID types.RealmID, provider *providers.Provider, update adminUpdate) (*responses.AdminUser, error) {
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return nil, types.NewHTTPError(http.StatusInternalServerError, fmt.Errorf("error reading request body: %w", err))
+	}
+
+	claims, err := verifyToken(c, realmID, requireScope, scopeAdmin)
+	if err != nil {
+		return nil, types.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	var request requests.AdminUser
+	err = json.Unmarshal(body, &request)
+	if err != nil {
+		return nil, types.NewHTTPError(http.StatusBadRequest, fmt.Errorf("error unmarshalling request body: %w", err))
+	}
+	if request.UserID == "" {
+		return nil, types.NewHTTPError(http.StatusBadRequest, errors.New("request missing 'user_id' field"))
+	}
+
+	// the tenant is always the one that signed the token, so that tenants
+	// can only manage their own users
+	tenant := claims.Issuer
+	recordID, err := records.CreateUserRecordID(tenant, request.UserID)
+	if err != nil {
+		return nil, types.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	var record records.UserRecord
+	var event *string
+	for attempt := 1; ; attempt++ {
+		var readRecord interface{}
+		record, readRecord, err = provider.RecordStore.GetRecord(ctx, recordID)
+		if err != nil {
+			return nil, types.NewHTTPError(http.StatusInternalServerError, fmt.Errorf("error reading from record store: %w", err))
+		}
+		if _, ok := record.RegistrationState.(records.Encrypted); ok {
+			return nil, types.NewHTTPError(http.StatusInternalServerError, records.ErrRecordEncrypted)
+		}
+
+		if update == nil {
+			break
+		}
+		event = update(&record)
+		if event == nil {
+			break
+		}
+		err = provider.RecordStore.WriteRecord(ctx, recordID, record, readRecord)
+		if err == nil {
+			break
+		}
+		if errors.Is(err, records.ErrRecordConflict) {
+			// a request for this user changed the record first, so apply
+			// the update to the record it wrote
+			otel.IncrementInt64Counter(
+				ctx,
+				"realm.record.conflict.count",
+				attribute.String("tenant", tenant),
+			)
+			if attempt < recordConflictAttempts {
+				continue
+			}
+			return nil, types.NewHTTPError(http.StatusConflict, fmt.Errorf("error writing to record store: %w", err))
+		}
+		return nil, types.NewHTTPError(http.StatusInternalServerError, fmt.Errorf("error writing to record store: %w", err))
+	}
+
+	if event != nil {
+		err = provider.PubSub.Publish(ctx, realmID, tenant, pubsub.EventMessage{
+			User:  tenantUserID(tenant, request.UserID),
+			Event: *event,
+		})
+		if err != nil {
+			return nil, types.NewHTTPError(http.StatusInternalServerError, fmt.Errorf("error writing to pub/sub queue: %w", err))
+		}
+	}
+
+	otel.IncrementInt64Counter(
+		ctx,
+		"realm.admin.count",
+		attribute.String("tenant", tenant),
+		attribute.String("type", c.Request().URL.Path),
+	)
+	return redactUserRecord(record), nil
+}
+
+// Describes a user record without any of the key material it holds.
+func redactUserRecord(record records.UserRecord) *responses.AdminUser {
+	switch state := record.RegistrationState.(type) {
+	case records.Registered:
+		numGuesses := state.Policy.NumGuesses
+		guessCount := state.GuessCount
+		return &responses.AdminUser{
+			State:      "Registered",
+			Version:    hex.EncodeToString(state.Version[:]),
+			NumGuesses: &numGuesses,
+			GuessCount: &guessCount,
+		}
+	case records.NoGuesses:
+		return &responses.AdminUser{State: "NoGuesses"}
+	default:
+		return &responses.AdminUser{State: "NotRegistered"}
+	}
+}
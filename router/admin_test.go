@@ -0,0 +1,273 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/juicebox-systems/juicebox-software-realm/config"
+	"github.com/juicebox-systems/juicebox-software-realm/providers"
+	"github.com/juicebox-systems/juicebox-software-realm/pubsub"
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+	"github.com/juicebox-systems/juicebox-software-realm/requests"
+	"github.com/juicebox-systems/juicebox-software-realm/responses"
+	"github.com/juicebox-systems/juicebox-software-realm/secrets"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminApi(t *testing.T) {
+	realmID := types.RealmID(makeRepeatingByteArray(4, 16))
+	sm, err := secrets.NewMemorySecretsManager(context.Background(), map[string]map[uint64]string{"acme": {1: "acme-tenant-key"}})
+	assert.NoError(t, err)
+	provider := &providers.Provider{
+		RecordStore:    records.NewMemoryRecordStore(),
+		SecretsManager: sm,
+		PubSub:         pubsub.NewMemPubSub(),
+	}
+	e := NewRouter(realmID, provider, nil, nil, config.Default())
+
+	// artemis has used two of their five guesses
+	recordID, err := records.CreateUserRecordID("acme", "artemis")
+	assert.NoError(t, err)
+	err = provider.RecordStore.WriteRecord(context.Background(), recordID, records.UserRecord{
+		RegistrationState: records.Registered{
+			Version:    types.RegistrationVersion(makeRepeatingByteArray(1, 16)),
+			GuessCount: 2,
+			Policy:     types.Policy{NumGuesses: 5},
+		},
+	}, nil)
+	assert.NoError(t, err)
+
+	request := func(path string, scope string, userID string) (int, *responses.AdminUser) {
+		n := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "acme",
+				Subject:   "operator",
+				Audience:  []string{realmID.String()},
+				ExpiresAt: jwt.NewNumericDate(n.Add(time.Minute)),
+				IssuedAt:  jwt.NewNumericDate(n),
+			},
+			Scope: scope,
+		})
+		token.Header["kid"] = "acme:1"
+		bearer, err := token.SignedString([]byte("acme-tenant-key"))
+		assert.NoError(t, err)
+
+		body, err := json.Marshal(requests.AdminUser{UserID: userID})
+		assert.NoError(t, err)
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			return rec.Code, nil
+		}
+		var user responses.AdminUser
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &user))
+		return rec.Code, &user
+	}
+	events := func() []string {
+		entries, err := provider.PubSub.Pull(context.Background(), realmID, "acme", 10)
+		assert.NoError(t, err)
+		var names []string
+		var acks []string
+		for _, entry := range entries {
+			assert.Equal(t, tenantUserID("acme", "artemis"), entry.UserID)
+			names = append(names, entry.Event)
+			acks = append(acks, entry.Ack)
+		}
+		assert.NoError(t, provider.PubSub.Ack(context.Background(), realmID, "acme", acks))
+		return names
+	}
+	guesses := func(n uint16) *uint16 { return &n }
+
+	// user tokens can't be used
+	code, _ := request("/admin/user", "user", "artemis")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = request("/admin/user", "", "artemis")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = request("/admin/user", "admin", "")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, user := request("/admin/user", "admin", "artemis")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, &responses.AdminUser{
+		State:      "Registered",
+		Version:    "01010101010101010101010101010101",
+		NumGuesses: guesses(5),
+		GuessCount: guesses(2),
+	}, user)
+	assert.Empty(t, events())
+
+	// a registered user's guess count can't be reset
+	code, user = request("/admin/user/reset_guesses", "admin", "artemis")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, guesses(2), user.GuessCount)
+	assert.Empty(t, events())
+
+	code, user = request("/admin/user/delete", "admin", "artemis")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, &responses.AdminUser{State: "NotRegistered"}, user)
+	assert.Equal(t, []string{"deleted"}, events())
+
+	stored, _, err := provider.RecordStore.GetRecord(context.Background(), recordID)
+	assert.NoError(t, err)
+	assert.Equal(t, records.NotRegistered{}, stored.RegistrationState)
+
+	// without the KMS apollo's record was encrypted with, it's left alone
+	encryptedID, err := records.CreateUserRecordID("acme", "apollo")
+	assert.NoError(t, err)
+	encrypted := records.UserRecord{RegistrationState: records.Encrypted{
+		WrappedKey: makeRepeatingByteArray(1, 32),
+		Nonce:      makeRepeatingByteArray(2, 24),
+		Ciphertext: makeRepeatingByteArray(3, 64),
+	}}
+	assert.NoError(t, provider.RecordStore.WriteRecord(context.Background(), encryptedID, encrypted, nil))
+	for _, path := range []string{"/admin/user", "/admin/user/reset_guesses", "/admin/user/delete"} {
+		code, _ = request(path, "admin", "apollo")
+		assert.Equal(t, http.StatusInternalServerError, code)
+	}
+	assert.Empty(t, events())
+	stored, _, err = provider.RecordStore.GetRecord(context.Background(), encryptedID)
+	assert.NoError(t, err)
+	assert.Equal(t, encrypted, stored)
+}
+
+func TestAdminResetNoGuesses(t *testing.T) {
+	record := records.UserRecord{RegistrationState: records.NoGuesses{}}
+	event := resetGuesses(&record)
+	assert.Equal(t, "deleted", *event)
+	assert.Equal(t, records.NotRegistered{}, record.RegistrationState)
+
+	record = records.DefaultUserRecord()
+	assert.Nil(t, resetGuesses(&record))
+	assert.Nil(t, deleteRegistration(&record))
+}
+
+// Records the secrets it's asked to invalidate.
+type invalidatingSecretsManager struct {
+	*secrets.MemorySecretsManager
+	invalidated []string
+}
+
+func (sm *invalidatingSecretsManager) Invalidate(_ context.Context, name string) error {
+	sm.invalidated = append(sm.invalidated, name)
+	return nil
+}
+
+func TestAdminInvalidateSecrets(t *testing.T) {
+	realmID := types.RealmID(makeRepeatingByteArray(4, 16))
+	memory, err := secrets.NewMemorySecretsManager(context.Background(), map[string]map[uint64]string{"acme": {1: "acme-tenant-key"}})
+	assert.NoError(t, err)
+	sm := &invalidatingSecretsManager{MemorySecretsManager: memory}
+	provider := &providers.Provider{
+		RecordStore:    records.NewMemoryRecordStore(),
+		SecretsManager: sm,
+		PubSub:         pubsub.NewMemPubSub(),
+	}
+	e := NewRouter(realmID, provider, nil, nil, config.Default())
+
+	request := func(scope string) int {
+		n := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "acme",
+				Subject:   "operator",
+				Audience:  []string{realmID.String()},
+				ExpiresAt: jwt.NewNumericDate(n.Add(time.Minute)),
+				IssuedAt:  jwt.NewNumericDate(n),
+			},
+			Scope: scope,
+		})
+		token.Header["kid"] = "acme:1"
+		bearer, err := token.SignedString([]byte("acme-tenant-key"))
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/admin/tenant/invalidate_secrets", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// user tokens can't be used
+	assert.Equal(t, http.StatusUnauthorized, request("user"))
+	assert.Empty(t, sm.invalidated)
+
+	// only the signing tenant's secrets are invalidated
+	assert.Equal(t, http.StatusNoContent, request("admin"))
+	assert.Equal(t, []string{types.JuiceboxTenantSecretPrefix + "acme"}, sm.invalidated)
+}
+
+func TestAdminRecordConflicts(t *testing.T) {
+	realmID := types.RealmID(makeRepeatingByteArray(6, 16))
+	sm, err := secrets.NewMemorySecretsManager(context.Background(), map[string]map[uint64]string{"acme": {1: "acme-tenant-key"}})
+	assert.NoError(t, err)
+	store := &conflictingRecordStore{RecordStore: records.NewMemoryRecordStore()}
+	provider := &providers.Provider{
+		RecordStore:    store,
+		SecretsManager: sm,
+		PubSub:         pubsub.NewMemPubSub(),
+	}
+	e := NewRouter(realmID, provider, nil, nil, config.Default())
+
+	recordID, err := records.CreateUserRecordID("acme", "artemis")
+	assert.NoError(t, err)
+	register := func() {
+		_, readRecord, err := store.GetRecord(context.Background(), recordID)
+		assert.NoError(t, err)
+		err = store.RecordStore.WriteRecord(context.Background(), recordID, records.UserRecord{
+			RegistrationState: records.Registered{Policy: types.Policy{NumGuesses: 5}},
+		}, readRecord)
+		assert.NoError(t, err)
+	}
+	deleteRequest := func() *httptest.ResponseRecorder {
+		n := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    "acme",
+				Subject:   "operator",
+				Audience:  []string{realmID.String()},
+				ExpiresAt: jwt.NewNumericDate(n.Add(time.Minute)),
+				IssuedAt:  jwt.NewNumericDate(n),
+			},
+			Scope: "admin",
+		})
+		token.Header["kid"] = "acme:1"
+		bearer, err := token.SignedString([]byte("acme-tenant-key"))
+		assert.NoError(t, err)
+		body, err := json.Marshal(requests.AdminUser{UserID: "artemis"})
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/admin/user/delete", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// an update that loses a race is applied to the record that won it
+	register()
+	store.conflicts = recordConflictAttempts - 1
+	rec := deleteRequest()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, recordConflictAttempts, store.writes)
+	stored, _, err := store.GetRecord(context.Background(), recordID)
+	assert.NoError(t, err)
+	assert.Equal(t, records.NotRegistered{}, stored.RegistrationState)
+
+	// but not forever
+	register()
+	store.conflicts = recordConflictAttempts
+	store.writes = 0
+	rec = deleteRequest()
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Equal(t, recordConflictAttempts, store.writes)
+}
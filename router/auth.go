@@ -3,6 +3,7 @@ package router
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/juicebox-systems/juicebox-software-realm/records"
@@ -11,10 +12,14 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+// How far a token's exp and nbf claims may be off by.
+const JWTLeeway = 5 * time.Second
+
 const requireScope = true
 const allowMissingScope = false
 const scopeUser = "user"
 const scopeAudit = "audit"
+const scopeAdmin = "admin"
 
 func userRecordID(c echo.Context, realmID types.RealmID) (*records.UserRecordID, *claims, error) {
 	claims, err := verifyToken(c, realmID, allowMissingScope, scopeUser)
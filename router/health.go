@@ -0,0 +1,110 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/juicebox-systems/juicebox-software-realm/providers"
+	"github.com/juicebox-systems/juicebox-software-realm/responses"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/labstack/echo/v4"
+)
+
+// How long each component gets to respond to a readiness check.
+const readinessTimeout = 2 * time.Second
+
+const (
+	healthOK          = "ok"
+	healthUnchecked   = "unchecked"
+	healthError       = "error"
+	healthUnavailable = "unavailable"
+)
+
+// HealthComponent is a part of the realm that /readyz reports on. Components
+// that don't implement types.HealthChecker are reported as unchecked.
+type HealthComponent struct {
+	Name string
+	// The name of the provider backing this component, if known.
+	Provider  string
+	Component interface{}
+}
+
+// ProviderHealthComponents returns the record store, secrets manager and
+// pub/sub components of a provider.
+func ProviderHealthComponents(provider *providers.Provider) []HealthComponent {
+	return []HealthComponent{
+		{Name: "records", Provider: provider.Names.RecordStore.String(), Component: provider.RecordStore},
+		{Name: "secrets", Provider: provider.Names.SecretsManager.String(), Component: provider.SecretsManager},
+		{Name: "pubsub", Provider: provider.Names.PubSub.String(), Component: provider.PubSub},
+	}
+}
+
+// AddHealthHandlers adds /healthz, which succeeds as long as the server is
+// running, and /readyz, which checks each component can serve requests.
+func AddHealthHandlers(e *echo.Echo, components []HealthComponent) {
+	e.GET("/healthz", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, responses.Health{Status: healthOK})
+	})
+
+	e.GET("/readyz", func(c echo.Context) error {
+		health := checkHealth(c.Request().Context(), components)
+		if health.Status != healthOK {
+			return c.JSON(http.StatusServiceUnavailable, health)
+		}
+		return c.JSON(http.StatusOK, health)
+	})
+}
+
+func checkHealth(ctx context.Context, components []HealthComponent) responses.Health {
+	results := make([]responses.ComponentHealth, len(components))
+
+	var wg sync.WaitGroup
+	for i, component := range components {
+		wg.Add(1)
+		go func(i int, component HealthComponent) {
+			defer wg.Done()
+			results[i] = checkComponent(ctx, component)
+		}(i, component)
+	}
+	wg.Wait()
+
+	health := responses.Health{
+		Status:     healthOK,
+		Components: make(map[string]responses.ComponentHealth, len(components)),
+	}
+	for i, component := range components {
+		if results[i].Status == healthError {
+			health.Status = healthUnavailable
+		}
+		health.Components[component.Name] = results[i]
+	}
+	return health
+}
+
+func checkComponent(ctx context.Context, component HealthComponent) responses.ComponentHealth {
+	result := responses.ComponentHealth{
+		Provider: component.Provider,
+		Status:   healthOK,
+	}
+
+	checker, ok := component.Component.(types.HealthChecker)
+	if !ok {
+		result.Status = healthUnchecked
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	err := checker.CheckHealth(ctx)
+	if errors.Is(err, types.ErrHealthCheckUnsupported) {
+		result.Status = healthUnchecked
+	} else if err != nil {
+		result.Status = healthError
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/juicebox-systems/juicebox-software-realm/pubsub"
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeHealthChecker struct {
+	err error
+}
+
+func (f fakeHealthChecker) CheckHealth(_ context.Context) error {
+	return f.err
+}
+
+func healthRequest(e *echo.Echo, path string) (int, string) {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestHealthHandlers(t *testing.T) {
+	store := &fakeHealthChecker{}
+	e := echo.New()
+	AddHealthHandlers(e, []HealthComponent{
+		{Name: "records", Provider: "postgres", Component: store},
+		{Name: "secrets", Provider: "memory", Component: records.NewMemoryRecordStore()},
+		{Name: "pubsub", Component: pubsub.NewMemPubSub()},
+	})
+
+	code, body := healthRequest(e, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"status":"ok"}`, body)
+
+	code, body = healthRequest(e, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{
+		"status": "ok",
+		"components": {
+			"records": {"provider": "postgres", "status": "ok"},
+			"secrets": {"provider": "memory", "status": "unchecked"},
+			"pubsub": {"status": "unchecked"}
+		}
+	}`, body)
+
+	// a failing component makes the realm unready, but it's still alive
+	store.err = errors.New("connection refused")
+
+	code, body = healthRequest(e, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.JSONEq(t, `{
+		"status": "unavailable",
+		"components": {
+			"records": {"provider": "postgres", "status": "error", "error": "connection refused"},
+			"secrets": {"provider": "memory", "status": "unchecked"},
+			"pubsub": {"status": "unchecked"}
+		}
+	}`, body)
+
+	code, _ = healthRequest(e, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+}
@@ -0,0 +1,118 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/juicebox-systems/juicebox-software-realm/config"
+	"github.com/juicebox-systems/juicebox-software-realm/providers"
+	"github.com/juicebox-systems/juicebox-software-realm/pubsub"
+	"github.com/juicebox-systems/juicebox-software-realm/ratelimit"
+	"github.com/juicebox-systems/juicebox-software-realm/records"
+	"github.com/juicebox-systems/juicebox-software-realm/replay"
+	"github.com/juicebox-systems/juicebox-software-realm/secrets"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns a function that sends a /req request with a token carrying
+// registeredClaims to a memory realm.
+func newProtectedRouter(t *testing.T, limiter *ratelimit.Limiter, guard *replay.Guard) func(jwt.RegisteredClaims) *httptest.ResponseRecorder {
+	realmID := types.RealmID(makeRepeatingByteArray(3, 16))
+	sm, err := secrets.NewMemorySecretsManager(context.Background(), map[string]map[uint64]string{"acme": {1: "acme-tenant-key"}})
+	assert.NoError(t, err)
+	provider := &providers.Provider{
+		RecordStore:    records.NewMemoryRecordStore(),
+		SecretsManager: sm,
+		PubSub:         pubsub.NewMemPubSub(),
+	}
+	e := NewRouter(realmID, provider, limiter, guard, config.Default())
+
+	return func(registeredClaims jwt.RegisteredClaims) *httptest.ResponseRecorder {
+		registeredClaims.Issuer = "acme"
+		registeredClaims.Subject = "artemis"
+		registeredClaims.Audience = []string{realmID.String()}
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims{RegisteredClaims: registeredClaims})
+		token.Header["kid"] = "acme:1"
+		bearer, err := token.SignedString([]byte("acme-tenant-key"))
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/req", bytes.NewReader([]byte("not cbor")))
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		req.Header.Set("X-Juicebox-Version", Version.String())
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+}
+
+func TestRateLimitedRequests(t *testing.T) {
+	limiter := ratelimit.NewLimiter(
+		ratelimit.NewMemoryStore(),
+		ratelimit.Policy{User: ratelimit.Limit{Requests: 1, Window: time.Hour}},
+		nil,
+	)
+	request := newProtectedRouter(t, limiter, nil)
+
+	// the first request gets past the limiter
+	rec := request(jwt.RegisteredClaims{})
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Empty(t, rec.Header().Get(echo.HeaderRetryAfter))
+
+	// but the second doesn't
+	rec = request(jwt.RegisteredClaims{})
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get(echo.HeaderRetryAfter))
+}
+
+func TestReplayedRequests(t *testing.T) {
+	guard := replay.NewGuard(
+		replay.NewMemoryStore(),
+		replay.Policy{RequireJTI: true, MaxLifetime: time.Minute},
+		nil,
+		JWTLeeway,
+	)
+	request := newProtectedRouter(t, nil, guard)
+
+	n := time.Now()
+	registeredClaims := jwt.RegisteredClaims{
+		ID:        "1",
+		IssuedAt:  jwt.NewNumericDate(n),
+		ExpiresAt: jwt.NewNumericDate(n.Add(time.Minute)),
+	}
+
+	// the first use of a token gets past the guard
+	rec := request(registeredClaims)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// but it can't be used again
+	rec = request(registeredClaims)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Token has already been used", rec.Body.String())
+
+	// tokens need a jti
+	registeredClaims.ID = ""
+	rec = request(registeredClaims)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "Error reading user from jwt", rec.Body.String())
+
+	// and can't live too long
+	registeredClaims.ID = "2"
+	registeredClaims.ExpiresAt = jwt.NewNumericDate(n.Add(time.Hour))
+	rec = request(registeredClaims)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	assert.Equal(t, "1", retryAfterSeconds(0))
+	assert.Equal(t, "1", retryAfterSeconds(time.Millisecond))
+	assert.Equal(t, "1", retryAfterSeconds(time.Second))
+	assert.Equal(t, "2", retryAfterSeconds(1001*time.Millisecond))
+	assert.Equal(t, "3600", retryAfterSeconds(time.Hour))
+}
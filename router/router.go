@@ -1,11 +1,11 @@
 package router
 
 import (
+	"context"
 	cryptoRand "crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	"fmt"
 	"io"
 	"net/http"
 	"reflect"
@@ -15,11 +15,14 @@ import (
 	semver "github.com/Masterminds/semver/v3"
 	"github.com/fxamacker/cbor/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/juicebox-systems/juicebox-software-realm/config"
 	"github.com/juicebox-systems/juicebox-software-realm/oprf"
 	"github.com/juicebox-systems/juicebox-software-realm/otel"
 	"github.com/juicebox-systems/juicebox-software-realm/providers"
 	"github.com/juicebox-systems/juicebox-software-realm/pubsub"
+	"github.com/juicebox-systems/juicebox-software-realm/ratelimit"
 	"github.com/juicebox-systems/juicebox-software-realm/records"
+	"github.com/juicebox-systems/juicebox-software-realm/replay"
 	"github.com/juicebox-systems/juicebox-software-realm/requests"
 	"github.com/juicebox-systems/juicebox-software-realm/responses"
 	"github.com/juicebox-systems/juicebox-software-realm/secrets"
@@ -34,15 +37,37 @@ import (
 
 var Version = semver.MustParse("0.2.0")
 
+// The number of times a request is run before giving up on writing the
+// user's record, when other requests for the same user keep changing it.
+const recordConflictAttempts = 3
+
+// RunRouter serves the realm until ctx is cancelled, then waits for
+// in-flight requests to finish before returning.
 func RunRouter(
+	ctx context.Context,
+	realmID types.RealmID,
+	provider *providers.Provider,
+	limiter *ratelimit.Limiter,
+	guard *replay.Guard,
+	cfg *config.Config,
+) error {
+	e := NewRouter(realmID, provider, limiter, guard, cfg)
+	return Serve(ctx, e, cfg.Listener.Port, cfg.Listener.DrainTimeout)
+}
+
+// NewRouter returns the realm's HTTP server. Requests to /req are rate limited
+// by limiter, and their tokens checked against each tenant's policy by guard,
+// unless they're nil.
+func NewRouter(
 	realmID types.RealmID,
 	provider *providers.Provider,
-	port uint64,
-) {
+	limiter *ratelimit.Limiter,
+	guard *replay.Guard,
+	cfg *config.Config,
+) *echo.Echo {
 	e := echo.New()
 	e.HideBanner = true
-	// This needs to be larger than the 650 second timeout set for NGINX in GCP.
-	e.Server.IdleTimeout = 11 * time.Minute
+	e.Server.IdleTimeout = cfg.Limits.IdleTimeout
 
 	e.Use(timingHeader)
 	e.Use(middleware.Logger())
@@ -54,6 +79,8 @@ func RunRouter(
 		return c.JSON(http.StatusOK, map[string]interface{}{"realmID": realmID.String()})
 	})
 
+	AddHealthHandlers(e, ProviderHealthComponents(provider))
+
 	e.POST("/req", func(c echo.Context) error {
 		sdkVersion, err := semver.NewVersion(c.Request().Header.Get("X-Juicebox-Version"))
 		hasValidVersion := err == nil && (sdkVersion.Major() > Version.Major() || sdkVersion.Major() == Version.Major() && sdkVersion.Minor() >= Version.Minor())
@@ -66,6 +93,37 @@ func RunRouter(
 			return contextAwareError(c, http.StatusUnauthorized, "Error reading user from jwt")
 		}
 
+		if limiter != nil {
+			rejection, err := limiter.Allow(c.Request().Context(), claims.Issuer, string(*userRecordID))
+			if err != nil {
+				return contextAwareError(c, http.StatusInternalServerError, "Error checking rate limit")
+			}
+			if rejection != nil {
+				otel.IncrementInt64Counter(
+					c.Request().Context(),
+					"realm.request.rate_limited.count",
+					attribute.String("tenant", claims.Issuer),
+					attribute.String("scope", rejection.Scope),
+				)
+				c.Response().Header().Set(echo.HeaderRetryAfter, retryAfterSeconds(rejection.RetryAfter))
+				return contextAwareError(c, http.StatusTooManyRequests, "Too many requests")
+			}
+		}
+
+		if guard != nil {
+			err := guard.Check(c.Request().Context(), claims.Issuer, claims.RegisteredClaims)
+			if errors.Is(err, replay.ErrReplayed) {
+				otel.IncrementInt64Counter(
+					c.Request().Context(),
+					"realm.request.replayed.count",
+					attribute.String("tenant", claims.Issuer),
+				)
+				return contextAwareError(c, http.StatusUnauthorized, "Token has already been used")
+			} else if err != nil {
+				return contextAwareError(c, http.StatusUnauthorized, "Error reading user from jwt")
+			}
+		}
+
 		body, err := io.ReadAll(c.Request().Body)
 		if err != nil {
 			return contextAwareError(c, http.StatusInternalServerError, "Error reading request body")
@@ -77,21 +135,40 @@ func RunRouter(
 			return contextAwareError(c, http.StatusBadRequest, "Error unmarshalling request body")
 		}
 
-		userRecord, readRecord, err := provider.RecordStore.GetRecord(c.Request().Context(), *userRecordID)
-		if err != nil {
-			return contextAwareError(c, http.StatusInternalServerError, "Error reading from record store")
-		}
+		var result *appResult
+		for attempt := 1; ; attempt++ {
+			userRecord, readRecord, err := provider.RecordStore.GetRecord(c.Request().Context(), *userRecordID)
+			if err != nil {
+				return contextAwareError(c, http.StatusInternalServerError, "Error reading from record store")
+			}
 
-		result, err := handleRequest(c, claims, userRecord, request, cryptoRand.Reader)
-		if err != nil {
-			return contextAwareError(c, http.StatusBadRequest, "Error processing request")
-		}
+			result, err = handleRequest(c, claims, userRecord, request, cryptoRand.Reader)
+			if errors.Is(err, records.ErrRecordEncrypted) {
+				return contextAwareError(c, http.StatusInternalServerError, "Error reading from record store")
+			} else if err != nil {
+				return contextAwareError(c, http.StatusBadRequest, "Error processing request")
+			}
 
-		if result.updatedRecord != nil {
-			err := provider.RecordStore.WriteRecord(c.Request().Context(), *userRecordID, *result.updatedRecord, readRecord)
-			if err != nil {
-				return contextAwareError(c, http.StatusInternalServerError, "Error writing to record store")
+			if result.updatedRecord == nil {
+				break
+			}
+			err = provider.RecordStore.WriteRecord(c.Request().Context(), *userRecordID, *result.updatedRecord, readRecord)
+			if err == nil {
+				break
+			}
+			if errors.Is(err, records.ErrRecordConflict) {
+				// another request for this user changed the record first, so
+				// start over from the record it wrote
+				otel.IncrementInt64Counter(
+					c.Request().Context(),
+					"realm.record.conflict.count",
+					attribute.String("tenant", claims.Issuer),
+				)
+				if attempt < recordConflictAttempts {
+					continue
+				}
 			}
+			return contextAwareError(c, http.StatusInternalServerError, "Error writing to record store")
 		}
 		if result.event != nil {
 			err := provider.PubSub.Publish(c.Request().Context(), realmID, claims.Issuer, *result.event)
@@ -114,11 +191,11 @@ func RunRouter(
 		c.Response().Header().Set(echo.HeaderContentType, echo.MIMEOctetStream)
 		return c.Blob(http.StatusOK, echo.MIMEOctetStream, serializedResponse)
 
-	}, middleware.BodyLimit("2K"), echojwt.WithConfig(echojwt.Config{
+	}, middleware.BodyLimit(cfg.Limits.RequestBody), echojwt.WithConfig(echojwt.Config{
 		ParseTokenFunc: func(c echo.Context, auth string) (interface{}, error) {
 			token, err := jwt.ParseWithClaims(auth, &claims{}, func(t *jwt.Token) (interface{}, error) {
 				return secrets.GetJWTSigningKey(c.Request().Context(), provider.SecretsManager, t)
-			}, jwt.WithLeeway(5*time.Second))
+			}, jwt.WithLeeway(JWTLeeway))
 			if err != nil {
 				return nil, &echojwt.TokenError{Token: token, Err: err}
 			}
@@ -129,9 +206,10 @@ func RunRouter(
 		},
 	}))
 
+	AddAdminHandlers(e, realmID, provider)
 	AddTenantLogHandlers(e, realmID, provider.PubSub, provider.SecretsManager, types.JuiceboxTenantSecretPrefix)
 
-	e.Logger.Fatal(e.Start(fmt.Sprintf(":%d", port)))
+	return e
 }
 
 type appResult struct {
@@ -145,6 +223,13 @@ func handleRequest(c echo.Context, claims *claims, record records.UserRecord, re
 	defer span.End()
 	span.SetAttributes(attribute.String("tenant", claims.Issuer))
 
+	// an encrypted record can't be read, and mustn't be overwritten
+	if _, ok := record.RegistrationState.(records.Encrypted); ok {
+		span.RecordError(records.ErrRecordEncrypted)
+		span.SetStatus(codes.Error, records.ErrRecordEncrypted.Error())
+		return nil, records.ErrRecordEncrypted
+	}
+
 	switch payload := request.Payload.(type) {
 	case requests.Register1:
 		return &appResult{
@@ -357,10 +442,14 @@ func handleRequest(c echo.Context, claims *claims, record records.UserRecord, re
 
 // Builds the hashed tenant & userID string that is included in the tenant event log entries.
 func eventUserID(c *claims) string {
+	return tenantUserID(c.Issuer, c.Subject)
+}
+
+func tenantUserID(tenant string, userID string) string {
 	h := sha256.New()
-	h.Write([]byte(c.Issuer))
+	h.Write([]byte(tenant))
 	h.Write([]byte{':'})
-	h.Write([]byte(c.Subject))
+	h.Write([]byte(userID))
 	return hex.EncodeToString(h.Sum(nil))
 }
 
@@ -375,6 +464,16 @@ func contextAwareError(c echo.Context, code int, str string) error {
 	}
 }
 
+// Retry-After is given in whole seconds, rounded up so that clients don't
+// retry before the window has ended.
+func retryAfterSeconds(d time.Duration) string {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
 func timingHeader(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		start := time.Now()
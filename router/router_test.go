@@ -2,15 +2,21 @@ package router
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/fxamacker/cbor/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/juicebox-systems/juicebox-software-realm/config"
+	"github.com/juicebox-systems/juicebox-software-realm/providers"
 	"github.com/juicebox-systems/juicebox-software-realm/pubsub"
 	"github.com/juicebox-systems/juicebox-software-realm/records"
 	"github.com/juicebox-systems/juicebox-software-realm/requests"
 	"github.com/juicebox-systems/juicebox-software-realm/responses"
+	"github.com/juicebox-systems/juicebox-software-realm/secrets"
 	"github.com/juicebox-systems/juicebox-software-realm/types"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
@@ -381,6 +387,134 @@ func TestHandleRequest(t *testing.T) {
 	assert.Nil(t, result)
 }
 
+// Fails writes with a conflict until conflicts runs out, as if another
+// request had just changed the record.
+type conflictingRecordStore struct {
+	records.RecordStore
+	conflicts int
+	writes    int
+}
+
+func (s *conflictingRecordStore) WriteRecord(ctx context.Context, recordID records.UserRecordID, record records.UserRecord, readRecord interface{}) error {
+	s.writes++
+	if s.conflicts > 0 {
+		s.conflicts--
+		return records.ErrRecordConflict
+	}
+	return s.RecordStore.WriteRecord(ctx, recordID, record, readRecord)
+}
+
+func TestRecordConflicts(t *testing.T) {
+	realmID := types.RealmID(makeRepeatingByteArray(5, 16))
+	sm, err := secrets.NewMemorySecretsManager(context.Background(), map[string]map[uint64]string{"acme": {1: "acme-tenant-key"}})
+	assert.NoError(t, err)
+	store := &conflictingRecordStore{RecordStore: records.NewMemoryRecordStore()}
+	provider := &providers.Provider{
+		RecordStore:    store,
+		SecretsManager: sm,
+		PubSub:         pubsub.NewMemPubSub(),
+	}
+	e := NewRouter(realmID, provider, nil, nil, config.Default())
+
+	deleteRequest := func() *httptest.ResponseRecorder {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims{RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   "acme",
+			Subject:  "artemis",
+			Audience: []string{realmID.String()},
+		}})
+		token.Header["kid"] = "acme:1"
+		bearer, err := token.SignedString([]byte("acme-tenant-key"))
+		assert.NoError(t, err)
+		body, err := cbor.Marshal("Delete")
+		assert.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodPost, "/req", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		req.Header.Set("X-Juicebox-Version", Version.String())
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// a request that loses a race is run again
+	store.conflicts = recordConflictAttempts - 1
+	rec := deleteRequest()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, recordConflictAttempts, store.writes)
+
+	// but not forever
+	store.conflicts = recordConflictAttempts
+	store.writes = 0
+	rec = deleteRequest()
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "Error writing to record store", rec.Body.String())
+	assert.Equal(t, recordConflictAttempts, store.writes)
+}
+
+func TestEncryptedRecordWithoutKMS(t *testing.T) {
+	realmID := types.RealmID(makeRepeatingByteArray(7, 16))
+	sm, err := secrets.NewMemorySecretsManager(context.Background(), map[string]map[uint64]string{"acme": {1: "acme-tenant-key"}})
+	assert.NoError(t, err)
+	store := records.NewMemoryRecordStore()
+	provider := &providers.Provider{
+		RecordStore:    store,
+		SecretsManager: sm,
+		PubSub:         pubsub.NewMemPubSub(),
+	}
+	e := NewRouter(realmID, provider, nil, nil, config.Default())
+
+	// as written by an encrypted record store, which this realm doesn't have
+	recordID, err := records.CreateUserRecordID("acme", "artemis")
+	assert.NoError(t, err)
+	encrypted := records.UserRecord{RegistrationState: records.Encrypted{
+		WrappedKey: makeRepeatingByteArray(1, 32),
+		Nonce:      makeRepeatingByteArray(2, 24),
+		Ciphertext: makeRepeatingByteArray(3, 64),
+	}}
+	_, readRecord, err := store.GetRecord(context.Background(), recordID)
+	assert.NoError(t, err)
+	assert.NoError(t, store.WriteRecord(context.Background(), recordID, encrypted, readRecord))
+
+	c := e.NewContext(&http.Request{}, nil)
+	userClaims := &claims{RegisteredClaims: jwt.RegisteredClaims{Issuer: "acme", Subject: "artemis"}}
+	payloads := []interface{}{
+		requests.Register1{},
+		requests.Register2{},
+		requests.Recover1{},
+		requests.Recover2{},
+		requests.Recover3{},
+		requests.Delete{},
+	}
+	for _, payload := range payloads {
+		result, err := HandleRequest(c, userClaims, encrypted, requests.SecretsRequest{Payload: payload}, nil)
+		assert.ErrorIs(t, err, records.ErrRecordEncrypted)
+		assert.Nil(t, result)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims{RegisteredClaims: jwt.RegisteredClaims{
+		Issuer:   "acme",
+		Subject:  "artemis",
+		Audience: []string{realmID.String()},
+	}})
+	token.Header["kid"] = "acme:1"
+	bearer, err := token.SignedString([]byte("acme-tenant-key"))
+	assert.NoError(t, err)
+	body, err := cbor.Marshal("Delete")
+	assert.NoError(t, err)
+	req := httptest.NewRequest(http.MethodPost, "/req", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+bearer)
+	req.Header.Set("X-Juicebox-Version", Version.String())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, "Error reading from record store", rec.Body.String())
+
+	// the record is left as it was
+	stored, _, err := store.GetRecord(context.Background(), recordID)
+	assert.NoError(t, err)
+	assert.Equal(t, encrypted, stored)
+}
+
 func makeRepeatingByteArray(value byte, length int) []byte {
 	array := make([]byte, length)
 	for i := 0; i < length; i++ {
@@ -0,0 +1,52 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Serve runs e on the given port until ctx is cancelled. It then stops
+// accepting new connections and waits up to drainTimeout for in-flight
+// requests to finish, so that a request isn't cut off between updating a
+// user's record and publishing the matching tenant log event. A zero
+// drainTimeout closes every connection straight away instead.
+func Serve(ctx context.Context, e *echo.Echo, port uint64, drainTimeout time.Duration) error {
+	started := make(chan error, 1)
+	go func() {
+		started <- e.Start(fmt.Sprintf(":%d", port))
+	}()
+
+	select {
+	case err := <-started:
+		// the server failed before we were asked to stop
+		return err
+	case <-ctx.Done():
+	}
+
+	if drainTimeout == 0 {
+		// asked not to wait, so in-flight requests are cut off
+		e.Logger.Infof("shutting down without waiting for requests to finish")
+		if err := e.Close(); err != nil {
+			return err
+		}
+	} else {
+		e.Logger.Infof("shutting down, waiting up to %s for requests to finish", drainTimeout)
+
+		// ctx is already done, so the drain needs a context of its own
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		if err := e.Shutdown(shutdownCtx); err != nil {
+			return err
+		}
+	}
+
+	if err := <-started; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
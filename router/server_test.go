@@ -0,0 +1,106 @@
+package router
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeDrainsRequests(t *testing.T) {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+
+	handling := make(chan struct{})
+	e.GET("/slow", func(c echo.Context) error {
+		close(handling)
+		time.Sleep(200 * time.Millisecond)
+		return c.String(http.StatusOK, "done")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, e, 7898, 5*time.Second)
+	}()
+	waitForListener(t, e)
+
+	type result struct {
+		body string
+		err  error
+	}
+	responded := make(chan result, 1)
+	go func() {
+		res, err := http.Get("http://localhost:7898/slow")
+		if err != nil {
+			responded <- result{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		responded <- result{body: string(body), err: err}
+	}()
+
+	// shutting down mid-request lets the request finish
+	<-handling
+	cancel()
+
+	r := <-responded
+	assert.NoError(t, r.err)
+	assert.Equal(t, "done", r.body)
+	assert.NoError(t, <-served)
+
+	// and no new connections are accepted
+	_, err := http.Get("http://localhost:7898/slow")
+	assert.Error(t, err)
+}
+
+func TestServeWithoutDrain(t *testing.T) {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+
+	handling := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	e.GET("/slow", func(c echo.Context) error {
+		close(handling)
+		<-release
+		return c.String(http.StatusOK, "done")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(ctx, e, 7899, 0)
+	}()
+	waitForListener(t, e)
+
+	responded := make(chan error, 1)
+	go func() {
+		res, err := http.Get("http://localhost:7899/slow")
+		if err == nil {
+			_, err = io.ReadAll(res.Body)
+			res.Body.Close()
+		}
+		responded <- err
+	}()
+
+	// without a drain timeout, an in-flight request doesn't hold up a clean
+	// stop, and is cut off
+	<-handling
+	cancel()
+
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve didn't return")
+	}
+	assert.Error(t, <-responded)
+}
@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/juicebox-systems/juicebox-software-realm/otel"
@@ -41,6 +40,10 @@ func NewTenantAPIServer(
 	e.GET("/", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, map[string]interface{}{"realmID": realmID.String()})
 	})
+	AddHealthHandlers(e, []HealthComponent{
+		{Name: "secrets", Component: secretsManager},
+		{Name: "pubsub", Component: pubSub},
+	})
 	return e
 }
 
@@ -49,7 +52,7 @@ func AddTenantLogHandlers(e *echo.Echo, realmID types.RealmID, pubsub pubsub.Pub
 		ParseTokenFunc: func(c echo.Context, auth string) (interface{}, error) {
 			token, err := jwt.ParseWithClaims(auth, &claims{}, func(t *jwt.Token) (interface{}, error) {
 				return secrets.GetJWTSigningKeyWithPrefix(c.Request().Context(), secretsManager, secretsPrefix, t)
-			}, jwt.WithLeeway(5*time.Second))
+			}, jwt.WithLeeway(JWTLeeway))
 			if err != nil {
 				return nil, &echojwt.TokenError{Token: token, Err: err}
 			}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
-	"os"
 	"testing"
 	"time"
 
@@ -16,13 +15,14 @@ import (
 	"github.com/juicebox-systems/juicebox-software-realm/responses"
 	"github.com/juicebox-systems/juicebox-software-realm/secrets"
 	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestTenantLogApi(t *testing.T) {
 	realmID := types.RealmID(makeRepeatingByteArray(250, 16))
-	os.Setenv("TENANT_SECRETS", `{"acme":{"1":"acme-tenant-key"}}`)
-	sm, err := secrets.NewMemorySecretsManagerWithPrefix(context.Background(), "tenant-")
+	tenantSecrets := map[string]map[uint64]string{"acme": {1: "acme-tenant-key"}}
+	sm, err := secrets.NewMemorySecretsManagerWithPrefix(context.Background(), tenantSecrets, "tenant-")
 	assert.NoError(t, err)
 	ps := pubsub.NewMemPubSub()
 	e := NewTenantAPIServer(realmID, sm, ps)
@@ -30,6 +30,7 @@ func TestTenantLogApi(t *testing.T) {
 		e.Start(":7899")
 	}()
 	defer e.Close()
+	waitForListener(t, e)
 	n := time.Now()
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims{
 		RegisteredClaims: jwt.RegisteredClaims{
@@ -155,3 +156,13 @@ func TestEventUserId(t *testing.T) {
 	})
 	assert.Equal(t, id, "447ddec5f08c757d40e7acb9f1bc10ed44a960683bb991f5e4ed17498f786ff8")
 }
+
+func waitForListener(t *testing.T, e *echo.Echo) {
+	for i := 0; i < 100; i++ {
+		if e.ListenerAddr() != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("server failed to start listening")
+}
@@ -2,10 +2,12 @@ package secrets
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/smithy-go"
 	"github.com/juicebox-systems/juicebox-software-realm/otel"
 )
 
@@ -22,6 +24,27 @@ func NewAwsSecretsManager(ctx context.Context, cfg aws.Config) (*AwsSecretsManag
 	}, nil
 }
 
+func (sm AwsSecretsManager) CheckHealth(ctx context.Context) error {
+	ctx, span := otel.StartSpan(ctx, "CheckHealth")
+	defer span.End()
+
+	// Describing a secret doesn't read its value. The realm is usually only
+	// allowed to read its tenants' secrets, so being told the secret is
+	// missing or off limits still shows the service is reachable and
+	// accepts the realm's credentials.
+	_, err := sm.svc.DescribeSecret(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: aws.String(healthCheckSecretName),
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ResourceNotFoundException", "AccessDeniedException":
+			err = nil
+		}
+	}
+	return otel.RecordOutcome(err, span)
+}
+
 func (sm AwsSecretsManager) GetSecret(ctx context.Context, name string, version uint64) ([]byte, error) {
 	ctx, span := otel.StartSpan(ctx, "GetSecret")
 	defer span.End()
@@ -0,0 +1,190 @@
+package secrets
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sync/singleflight"
+)
+
+// Invalidator is implemented by secrets managers that keep the secrets
+// they've read, so that a revoked key can stop being accepted before they'd
+// otherwise read it again.
+type Invalidator interface {
+	// Invalidate drops whatever is kept of the named secret, so that its
+	// versions are read again the next time they're used.
+	Invalidate(ctx context.Context, name string) error
+}
+
+// How long a secret is cached for, and how long a failure to read one is
+// cached for, so that tokens with unknown kids don't each reach the secrets
+// provider.
+const secretsCacheTTL = time.Hour
+const secretsCacheFailureTTL = 10 * time.Second
+
+// The most secrets and failures cached at once. The least recently used are
+// evicted to make room for new ones.
+const secretsCacheSize = 10000
+
+// How long to wait for the secrets provider when reading a secret. The read
+// isn't cancelled with the request that caused it, as other requests may be
+// waiting on it too.
+const secretsCacheReadTimeout = 30 * time.Second
+
+// cachingSecretsManager caches the secrets read from another secrets
+// manager, and the failures to read them, in a bounded LRU cache. Concurrent
+// reads of a secret that isn't cached share a single read.
+type cachingSecretsManager struct {
+	inner      SecretsManager
+	size       int
+	ttl        time.Duration
+	failureTTL time.Duration
+	now        func() time.Time
+	reads      singleflight.Group
+
+	lock    sync.Mutex
+	entries map[cacheKey]*list.Element
+	// The cache entries, most recently used first.
+	lru *list.List
+	// Incremented by each invalidation, so that a read that started before
+	// it isn't cached.
+	generation uint64
+}
+
+type cacheKey struct {
+	name    string
+	version uint64
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	secret  []byte
+	err     error
+	expires time.Time
+}
+
+func newCachingSecretsManager(inner SecretsManager, size int, ttl time.Duration, failureTTL time.Duration) *cachingSecretsManager {
+	return &cachingSecretsManager{
+		inner:      inner,
+		size:       size,
+		ttl:        ttl,
+		failureTTL: failureTTL,
+		now:        time.Now,
+		entries:    make(map[cacheKey]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+func (c *cachingSecretsManager) GetSecret(ctx context.Context, name string, version uint64) ([]byte, error) {
+	key := cacheKey{
+		name:    name,
+		version: version,
+	}
+	entry, generation, ok := c.getCached(key)
+	if ok {
+		otel.IncrementInt64Counter(ctx, "realm.secrets_cache.hit.count", attribute.Bool("failure", entry.err != nil))
+		return entry.secret, entry.err
+	}
+	otel.IncrementInt64Counter(ctx, "realm.secrets_cache.miss.count")
+
+	readKey := fmt.Sprintf("%d:%d:%s", generation, version, name)
+	secret, err, _ := c.reads.Do(readKey, func() (interface{}, error) {
+		readCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), secretsCacheReadTimeout)
+		defer cancel()
+		secret, err := c.inner.GetSecret(readCtx, name, version)
+		c.addToCache(key, secret, err, generation)
+		return secret, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return secret.([]byte), nil
+}
+
+// Invalidate drops the cached versions of the named secret, and any cached
+// failures to read it.
+func (c *cachingSecretsManager) Invalidate(_ context.Context, name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.generation++
+	for key, elem := range c.entries {
+		if key.name == name {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+	return nil
+}
+
+func (c *cachingSecretsManager) Close() {
+	if closer, ok := c.inner.(interface{ Close() }); ok {
+		closer.Close()
+	}
+}
+
+func (c *cachingSecretsManager) CheckHealth(ctx context.Context) error {
+	if checker, ok := c.inner.(types.HealthChecker); ok {
+		return checker.CheckHealth(ctx)
+	}
+	return types.ErrHealthCheckUnsupported
+}
+
+// Returns the unexpired cache entry for the key if there is one, and the
+// current generation of the cache.
+func (c *cachingSecretsManager) getCached(key cacheKey) (cacheEntry, uint64, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return cacheEntry{}, c.generation, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !c.now().Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return cacheEntry{}, c.generation, false
+	}
+	c.lru.MoveToFront(elem)
+	return *entry, c.generation, true
+}
+
+func (c *cachingSecretsManager) addToCache(key cacheKey, secret []byte, err error, generation uint64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if generation != c.generation {
+		// the secret was invalidated while it was being read
+		return
+	}
+
+	ttl := c.ttl
+	if err != nil {
+		ttl = c.failureTTL
+	}
+	entry := &cacheEntry{
+		key:     key,
+		secret:  secret,
+		err:     err,
+		expires: c.now().Add(ttl),
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,192 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// Returns "<name>-<version>" for versions 1 and 2 of any secret, counting
+// how often each is read. Reads wait for release, if it's set.
+type countingSecretsManager struct {
+	lock    sync.Mutex
+	reads   map[cacheKey]int
+	started chan struct{}
+	release chan struct{}
+}
+
+func newCountingSecretsManager() *countingSecretsManager {
+	return &countingSecretsManager{reads: make(map[cacheKey]int)}
+}
+
+func (sm *countingSecretsManager) GetSecret(ctx context.Context, name string, version uint64) ([]byte, error) {
+	sm.lock.Lock()
+	sm.reads[cacheKey{name: name, version: version}]++
+	started, release := sm.started, sm.release
+	sm.lock.Unlock()
+
+	if release != nil {
+		started <- struct{}{}
+		<-release
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if version != 1 && version != 2 {
+		return nil, errors.New("secret not found")
+	}
+	return []byte(fmt.Sprintf("%s-%d", name, version)), nil
+}
+
+func (sm *countingSecretsManager) readCount(name string, version uint64) int {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	return sm.reads[cacheKey{name: name, version: version}]
+}
+
+func TestCachingSecretsManager(t *testing.T) {
+	ctx := context.Background()
+	inner := newCountingSecretsManager()
+	c := newCachingSecretsManager(inner, 3, time.Hour, 10*time.Second)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	// secrets are read once
+	for i := 0; i < 3; i++ {
+		assertSecret(t, c, "acme", 1, "jb-sw-tenant-acme-1")
+	}
+	assert.Equal(t, 1, inner.readCount("jb-sw-tenant-acme", 1))
+
+	// and so are failures, until they expire
+	for i := 0; i < 3; i++ {
+		assertNoSecret(t, c, "acme", 3)
+	}
+	assert.Equal(t, 1, inner.readCount("jb-sw-tenant-acme", 3))
+	now = now.Add(10 * time.Second)
+	assertNoSecret(t, c, "acme", 3)
+	assert.Equal(t, 2, inner.readCount("jb-sw-tenant-acme", 3))
+
+	// the least recently used entries are evicted
+	assertSecret(t, c, "acme", 1, "jb-sw-tenant-acme-1")
+	assertSecret(t, c, "globex", 1, "jb-sw-tenant-globex-1")
+	assertSecret(t, c, "globex", 2, "jb-sw-tenant-globex-2")
+	assertSecret(t, c, "acme", 1, "jb-sw-tenant-acme-1")
+	assert.Equal(t, 1, inner.readCount("jb-sw-tenant-acme", 1))
+	assertNoSecret(t, c, "acme", 3)
+	assert.Equal(t, 3, inner.readCount("jb-sw-tenant-acme", 3))
+	assertSecret(t, c, "globex", 1, "jb-sw-tenant-globex-1")
+	assert.Equal(t, 2, inner.readCount("jb-sw-tenant-globex", 1))
+
+	// and secrets expire
+	now = now.Add(time.Hour)
+	assertSecret(t, c, "acme", 1, "jb-sw-tenant-acme-1")
+	assert.Equal(t, 2, inner.readCount("jb-sw-tenant-acme", 1))
+
+	// invalidating a secret drops only its versions
+	assertSecret(t, c, "acme", 2, "jb-sw-tenant-acme-2")
+	assertSecret(t, c, "globex", 1, "jb-sw-tenant-globex-1")
+	assert.NoError(t, c.Invalidate(ctx, "jb-sw-tenant-acme"))
+	assertSecret(t, c, "acme", 1, "jb-sw-tenant-acme-1")
+	assertSecret(t, c, "acme", 2, "jb-sw-tenant-acme-2")
+	assertSecret(t, c, "globex", 1, "jb-sw-tenant-globex-1")
+	assert.Equal(t, 3, inner.readCount("jb-sw-tenant-acme", 1))
+	assert.Equal(t, 2, inner.readCount("jb-sw-tenant-acme", 2))
+	assert.Equal(t, 3, inner.readCount("jb-sw-tenant-globex", 1))
+	assert.LessOrEqual(t, c.lru.Len(), 3)
+	assert.Equal(t, c.lru.Len(), len(c.entries))
+}
+
+func TestCachingSecretsManagerConcurrentReads(t *testing.T) {
+	inner := newCountingSecretsManager()
+	inner.started = make(chan struct{}, 10)
+	inner.release = make(chan struct{})
+	c := newCachingSecretsManager(inner, 10, time.Hour, 10*time.Second)
+
+	// the first read's request is cancelled, which doesn't stop the others
+	// from getting the secret it read
+	cancelled, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		ctx := context.Background()
+		if i == 0 {
+			ctx = cancelled
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			secret, err := c.GetSecret(ctx, "jb-sw-tenant-acme", 1)
+			if assert.NoError(t, err) {
+				assert.Equal(t, "jb-sw-tenant-acme-1", string(secret))
+			}
+		}()
+	}
+	<-inner.started
+	cancel()
+	// give the other reads time to wait on the first
+	time.Sleep(50 * time.Millisecond)
+	close(inner.release)
+	wg.Wait()
+	assert.Equal(t, 1, inner.readCount("jb-sw-tenant-acme", 1))
+
+	// a secret that's invalidated while it's being read isn't cached
+	inner.lock.Lock()
+	inner.release = make(chan struct{})
+	inner.lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		secret, err := c.GetSecret(context.Background(), "jb-sw-tenant-acme", 2)
+		assert.NoError(t, err)
+		assert.Equal(t, "jb-sw-tenant-acme-2", string(secret))
+	}()
+	<-inner.started
+	assert.NoError(t, c.Invalidate(context.Background(), "jb-sw-tenant-acme"))
+	close(inner.release)
+	<-done
+
+	inner.lock.Lock()
+	inner.release = nil
+	inner.lock.Unlock()
+	assertSecret(t, c, "acme", 2, "jb-sw-tenant-acme-2")
+	assert.Equal(t, 2, inner.readCount("jb-sw-tenant-acme", 2))
+}
+
+func TestCachingSecretsManagerMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	defer otel.SetMeterProvider(otel.GetMeterProvider())
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+
+	c := newCachingSecretsManager(newCountingSecretsManager(), 10, time.Hour, 10*time.Second)
+	for i := 0; i < 3; i++ {
+		assertSecret(t, c, "acme", 1, "jb-sw-tenant-acme-1")
+		assertNoSecret(t, c, "acme", 3)
+	}
+
+	var rm metricdata.ResourceMetrics
+	assert.NoError(t, reader.Collect(context.Background(), &rm))
+	counts := make(map[string]int64)
+	for _, scope := range rm.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			for _, point := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				name := m.Name
+				if failure, ok := point.Attributes.Value("failure"); ok && failure.AsBool() {
+					name += ".failure"
+				}
+				counts[name] += point.Value
+			}
+		}
+	}
+	assert.Equal(t, map[string]int64{
+		"realm.secrets_cache.miss.count":        2,
+		"realm.secrets_cache.hit.count":         2,
+		"realm.secrets_cache.hit.count.failure": 2,
+	}, counts)
+}
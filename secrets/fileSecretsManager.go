@@ -0,0 +1,291 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+)
+
+// FileSecretsManager reads tenant secrets from a file, or from every file in
+// a directory such as a Kubernetes secret mount. Each file holds the same
+// layout as TENANT_SECRETS, except that a secret can also be given as an
+// auth key object rather than a string:
+//
+//	{"acme": {"1": "acme-tenant-key", "2": {"data": "...", "encoding": "Hex", "algorithm": "Edwards25519"}}}
+//
+// The files are checked for changes in the background, and the new set of
+// secrets is swapped in once it has been read and validated. If it can't be,
+// the error is logged and the secrets already loaded are kept.
+type FileSecretsManager struct {
+	path    string
+	secrets atomic.Pointer[MemorySecretsManager]
+	// Held while reloading, and guards the digest of the files last read,
+	// which is used to skip reloading them when they're unchanged.
+	reloadLock sync.Mutex
+	digest     [sha256.Size]byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// DefaultFileSecretsReloadInterval is how often the files are checked for
+// changes, unless configured otherwise.
+const DefaultFileSecretsReloadInterval = 10 * time.Second
+
+// The auth key algorithms, and the jwt algorithm each is used with.
+var authKeyJWTAlgorithms = map[types.AuthKeyAlgorithm]string{
+	types.HS256: "HS256",
+	types.RS256: "RS256",
+	types.EdDSA: "EdDSA",
+	types.ES256: "ES256",
+	types.ES384: "ES384",
+	types.PS256: "PS256",
+}
+
+func NewFileSecretsManager(ctx context.Context, path string, reloadInterval time.Duration) (*FileSecretsManager, error) {
+	ctx, span := otel.StartSpan(ctx, "NewFileSecretsManager")
+	defer span.End()
+
+	if path == "" {
+		err := errors.New("unexpectedly missing tenant secrets path")
+		return nil, otel.RecordOutcome(err, span)
+	}
+	if reloadInterval <= 0 {
+		reloadInterval = DefaultFileSecretsReloadInterval
+	}
+
+	sm := &FileSecretsManager{
+		path: path,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	if _, err := sm.reload(ctx, true); err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	go sm.watch(reloadInterval)
+	return sm, nil
+}
+
+// Reload reads the files again, swapping in their secrets if they're
+// valid. If they aren't, the secrets already loaded are kept and the error
+// is returned.
+func (sm *FileSecretsManager) Reload(ctx context.Context) error {
+	_, err := sm.reload(ctx, true)
+	return err
+}
+
+// Returns whether the files had changed since they were last read. Unless
+// forced, unchanged files aren't parsed again.
+func (sm *FileSecretsManager) reload(ctx context.Context, force bool) (bool, error) {
+	ctx, span := otel.StartSpan(ctx, "ReloadSecrets")
+	defer span.End()
+
+	sm.reloadLock.Lock()
+	defer sm.reloadLock.Unlock()
+
+	files, err := readTenantSecretsFiles(sm.path)
+	if err != nil {
+		return false, otel.RecordOutcome(err, span)
+	}
+
+	digest := digestTenantSecretsFiles(files)
+	changed := digest != sm.digest
+	if !changed && !force {
+		return false, nil
+	}
+	// a bad change is only reported once
+	sm.digest = digest
+
+	tenantSecrets, err := parseTenantSecretsFiles(files)
+	if err != nil {
+		return changed, otel.RecordOutcome(err, span)
+	}
+	secrets, err := NewMemorySecretsManager(ctx, tenantSecrets)
+	if err != nil {
+		return changed, otel.RecordOutcome(err, span)
+	}
+
+	sm.secrets.Store(secrets)
+	return changed, nil
+}
+
+func (sm *FileSecretsManager) watch(interval time.Duration) {
+	defer close(sm.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sm.stop:
+			return
+		case <-ticker.C:
+			changed, err := sm.reload(context.Background(), false)
+			if err != nil {
+				fmt.Printf("Keeping the current tenant secrets, failed to reload them from %s: %v\n", sm.path, err)
+			} else if changed {
+				fmt.Printf("Reloaded the tenant secrets from %s.\n", sm.path)
+			}
+		}
+	}
+}
+
+func (sm *FileSecretsManager) Close() {
+	close(sm.stop)
+	<-sm.done
+}
+
+// Invalidate reads the files again, so that a key removed from them stops
+// being used without waiting for the next check for changes.
+func (sm *FileSecretsManager) Invalidate(ctx context.Context, _ string) error {
+	return sm.Reload(ctx)
+}
+
+func (sm *FileSecretsManager) GetSecret(ctx context.Context, name string, version uint64) ([]byte, error) {
+	return sm.secrets.Load().GetSecret(ctx, name, version)
+}
+
+// Returns the contents of the file at path, or of each file in the
+// directory at path, keyed by file name. Hidden files and directories are
+// skipped, such as the ones Kubernetes uses to update a mount atomically.
+func readTenantSecretsFiles(path string) (map[string][]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return map[string][]byte{filepath.Base(path): contents}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		filePath := filepath.Join(path, entry.Name())
+		// follows symlinks, which a Kubernetes mount is made of
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+		contents, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+		files[entry.Name()] = contents
+	}
+	return files, nil
+}
+
+func digestTenantSecretsFiles(files map[string][]byte) [sha256.Size]byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hash, "%d:%s%d:", len(name), name, len(files[name]))
+		hash.Write(files[name])
+	}
+	var digest [sha256.Size]byte
+	copy(digest[:], hash.Sum(nil))
+	return digest
+}
+
+// Merges the tenant secrets in each file, which can't define the same
+// version of a tenant's secret more than once.
+func parseTenantSecretsFiles(files map[string][]byte) (map[string]map[uint64]string, error) {
+	tenantSecrets := make(map[string]map[uint64]string)
+	definedIn := make(map[string]map[uint64]string)
+
+	for name, contents := range files {
+		var parsed map[string]map[uint64]json.RawMessage
+		if err := json.Unmarshal(contents, &parsed); err != nil {
+			return nil, fmt.Errorf("invalid tenant secrets in %s: %w", name, err)
+		}
+
+		for tenant, versions := range parsed {
+			if tenantSecrets[tenant] == nil {
+				tenantSecrets[tenant] = make(map[uint64]string)
+				definedIn[tenant] = make(map[uint64]string)
+			}
+			for version, raw := range versions {
+				if other, ok := definedIn[tenant][version]; ok {
+					return nil, fmt.Errorf("version %d of tenant %s is in both %s and %s", version, tenant, other, name)
+				}
+				secret, err := parseTenantSecret(raw)
+				if err != nil {
+					return nil, fmt.Errorf("invalid version %d of tenant %s in %s: %w", version, tenant, name, err)
+				}
+				tenantSecrets[tenant][version] = secret
+				definedIn[tenant][version] = name
+			}
+		}
+	}
+	return tenantSecrets, nil
+}
+
+// Returns a secret given as a string, or as an auth key object, which is
+// returned in its JSON form like the other providers store it.
+func parseTenantSecret(raw json.RawMessage) (string, error) {
+	var secret string
+	switch {
+	case bytes.HasPrefix(raw, []byte(`"`)):
+		if err := json.Unmarshal(raw, &secret); err != nil {
+			return "", err
+		}
+	case bytes.HasPrefix(raw, []byte(`{`)):
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, raw); err != nil {
+			return "", err
+		}
+		secret = compacted.String()
+	default:
+		return "", errors.New("secret must be a string or an auth key object")
+	}
+
+	if secret == "" {
+		return "", errors.New("secret is empty")
+	}
+
+	// a secret that isn't an auth key is used as an HS256 key as is
+	var authKey types.AuthKeyJSON
+	if err := json.Unmarshal([]byte(secret), &authKey); err != nil {
+		return secret, nil
+	}
+	alg, ok := authKeyJWTAlgorithms[authKey.Algorithm]
+	if !ok {
+		return "", fmt.Errorf("unexpected auth key algorithm=%s", authKey.Algorithm)
+	}
+	if _, err := ParseAuthKey([]byte(secret), alg); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
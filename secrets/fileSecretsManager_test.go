@@ -0,0 +1,117 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const testEdDSAKeyHex = "302a300506032b65700321009fc1ac7fad6f56d29ddde3c30c96e1a9bbce6c92286fb7ee72d6995bb2e1e443"
+
+// Replaces the file at path in one step, as a reader could otherwise see it
+// half written. The temporary file is hidden, so it isn't read either.
+func writeFileAtomically(t *testing.T, path string, contents string) {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	assert.NoError(t, os.WriteFile(tmp, []byte(contents), 0600))
+	assert.NoError(t, os.Rename(tmp, path))
+}
+
+func assertSecret(t *testing.T, sm SecretsManager, tenant string, version uint64, expected string) {
+	secret, err := sm.GetSecret(context.Background(), types.JuiceboxTenantSecretPrefix+tenant, version)
+	if assert.NoError(t, err) {
+		assert.Equal(t, expected, string(secret))
+	}
+}
+
+func assertNoSecret(t *testing.T, sm SecretsManager, tenant string, version uint64) {
+	_, err := sm.GetSecret(context.Background(), types.JuiceboxTenantSecretPrefix+tenant, version)
+	assert.Error(t, err)
+}
+
+func TestFileSecretsManager(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	writeFileAtomically(t, path, `{
+		"acme": {
+			"1": "acme-key-1",
+			"2": {"data": "`+testEdDSAKeyHex+`", "encoding": "Hex", "algorithm": "Edwards25519"}
+		}
+	}`)
+
+	// the files are only reloaded when asked to
+	sm, err := NewFileSecretsManager(ctx, path, time.Hour)
+	assert.NoError(t, err)
+	defer sm.Close()
+
+	assertSecret(t, sm, "acme", 1, "acme-key-1")
+	authKey, err := sm.GetSecret(ctx, types.JuiceboxTenantSecretPrefix+"acme", 2)
+	assert.NoError(t, err)
+	_, err = ParseAuthKey(authKey, "EdDSA")
+	assert.NoError(t, err)
+
+	// a new tenant and key are swapped in, and the removed key is gone
+	writeFileAtomically(t, path, `{"acme": {"2": "acme-key-2"}, "globex": {"1": "globex-key-1"}}`)
+	assert.NoError(t, sm.Reload(ctx))
+	assertNoSecret(t, sm, "acme", 1)
+	assertSecret(t, sm, "acme", 2, "acme-key-2")
+	assertSecret(t, sm, "globex", 1, "globex-key-1")
+
+	// bad reloads keep the secrets already loaded
+	for contents, expected := range map[string]string{
+		`{"acme": {"3": "acme-key-3"`:        "invalid tenant secrets in secrets.json",
+		`{}`:                                 "unexpectedly missing tenant secrets",
+		`{"ac/me": {"1": "acme-key-1"}}`:     "tenant names must be alphanumeric",
+		`{"acme": {"latest": "acme-key-1"}}`: "invalid tenant secrets in secrets.json",
+		`{"acme": {"1": ""}}`:                "invalid version 1 of tenant acme in secrets.json: secret is empty",
+		`{"acme": {"1": 42}}`:                "secret must be a string or an auth key object",
+		`{"acme": {"1": {"data": "zz", "encoding": "Hex", "algorithm": "Edwards25519"}}}`: "invalid signing key hex",
+		`{"acme": {"1": {"data": "k", "encoding": "UTF8", "algorithm": "Rot13"}}}`:        "unexpected auth key algorithm=Rot13",
+	} {
+		writeFileAtomically(t, path, contents)
+		assert.ErrorContains(t, sm.Reload(ctx), expected)
+		assertSecret(t, sm, "acme", 2, "acme-key-2")
+		assertSecret(t, sm, "globex", 1, "globex-key-1")
+	}
+
+	assert.NoError(t, os.Remove(path))
+	assert.Error(t, sm.Reload(ctx))
+	assertSecret(t, sm, "acme", 2, "acme-key-2")
+
+	_, err = NewFileSecretsManager(ctx, path, time.Hour)
+	assert.Error(t, err)
+}
+
+func TestFileSecretsManagerDirectory(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeFileAtomically(t, filepath.Join(dir, "acme.json"), `{"acme": {"1": "acme-key-1"}}`)
+	writeFileAtomically(t, filepath.Join(dir, "globex.json"), `{"globex": {"1": "globex-key-1"}}`)
+	// like the directories Kubernetes uses to swap in a new version of a
+	// mounted secret
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "..data"), 0700))
+	writeFileAtomically(t, filepath.Join(dir, ".hidden"), `not json`)
+
+	sm, err := NewFileSecretsManager(ctx, dir, 10*time.Millisecond)
+	assert.NoError(t, err)
+	defer sm.Close()
+	assertSecret(t, sm, "acme", 1, "acme-key-1")
+	assertSecret(t, sm, "globex", 1, "globex-key-1")
+
+	// changes are picked up in the background
+	writeFileAtomically(t, filepath.Join(dir, "acme.json"), `{"acme": {"1": "acme-key-1", "2": "acme-key-2"}}`)
+	assert.Eventually(t, func() bool {
+		secret, err := sm.GetSecret(ctx, types.JuiceboxTenantSecretPrefix+"acme", 2)
+		return err == nil && string(secret) == "acme-key-2"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the same key can't be defined twice
+	writeFileAtomically(t, filepath.Join(dir, "more.json"), `{"acme": {"2": "another-acme-key-2"}}`)
+	err = sm.Reload(ctx)
+	assert.ErrorContains(t, err, "version 2 of tenant acme is in both")
+	assertSecret(t, sm, "acme", 2, "acme-key-2")
+}
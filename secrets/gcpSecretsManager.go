@@ -3,25 +3,29 @@ package secrets
 import (
 	"context"
 	"fmt"
-	"os"
 
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// The name of a secret that's looked up to check Secret Manager can be
+// reached. It doesn't need to exist.
+const healthCheckSecretName = "jb-sw-realm-healthcheck"
+
 type GcpSecretsManager struct {
 	client    *secretmanager.Client
 	projectID string
 }
 
-func NewGcpSecretsManager(ctx context.Context) (*GcpSecretsManager, error) {
+func NewGcpSecretsManager(ctx context.Context, projectID string) (*GcpSecretsManager, error) {
 	ctx, span := otel.StartSpan(ctx, "NewGcpSecretsManager")
 	defer span.End()
 
-	projectID := os.Getenv("GCP_PROJECT_ID")
 	if projectID == "" {
-		err := fmt.Errorf("unexpectedly missing GCP_PROJECT_ID")
+		err := fmt.Errorf("unexpectedly missing GCP project ID")
 		return nil, otel.RecordOutcome(err, span)
 	}
 
@@ -40,6 +44,23 @@ func (sm GcpSecretsManager) Close() {
 	sm.client.Close()
 }
 
+func (sm GcpSecretsManager) CheckHealth(ctx context.Context) error {
+	ctx, span := otel.StartSpan(ctx, "CheckHealth")
+	defer span.End()
+
+	// Reading a secret's metadata doesn't touch its versions. The realm is
+	// usually only allowed to access its tenants' secrets, so being told the
+	// secret is missing or off limits still shows the service is reachable
+	// and accepts the realm's credentials.
+	_, err := sm.client.GetSecret(ctx, &secretmanagerpb.GetSecretRequest{
+		Name: fmt.Sprintf("projects/%s/secrets/%s", sm.projectID, healthCheckSecretName),
+	})
+	if code := status.Code(err); code == codes.NotFound || code == codes.PermissionDenied {
+		err = nil
+	}
+	return otel.RecordOutcome(err, span)
+}
+
 func (sm GcpSecretsManager) GetSecret(ctx context.Context, name string, version uint64) ([]byte, error) {
 	ctx, span := otel.StartSpan(ctx, "GetSecret")
 	defer span.End()
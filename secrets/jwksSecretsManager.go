@@ -0,0 +1,519 @@
+package secrets
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// JWKSSecretsManager reads tenant signing keys from the JSON Web Key Set each
+// tenant publishes at a URL. Version 2 of acme's key is the JWK in acme's set
+// whose kid is "acme:2", the same kid the tenant's tokens are signed with.
+// RS256, PS256, EdDSA (Ed25519), ES256 (P-256) and ES384 (P-384) keys are
+// supported, and other keys in the set are ignored.
+//
+// The sets must be fetched over https, unless allowInsecureHTTP is set for
+// testing.
+//
+// Each set is refetched in the background once its Cache-Control or Expires
+// headers say it's stale, but no later than the refresh interval. A lookup
+// of a key that isn't in the set also refetches it, so that a new key can be
+// used as soon as it's published. If a set can't be fetched, the error is
+// logged and the keys already fetched are kept.
+type JWKSSecretsManager struct {
+	client  *http.Client
+	tenants map[string]*jwksTenant
+	// The longest a set is kept before it's refetched, and the shortest,
+	// however short its headers say it can be cached for.
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type jwksTenant struct {
+	name string
+	url  string
+	// The tenant's keys, in the form ParseAuthKey takes, by version.
+	keys atomic.Pointer[map[uint64][]byte]
+
+	// Held while fetching, and guards the fields below. Whether a fetch is
+	// still needed is checked once it's held, so that lookups of a missing
+	// key that were waiting on another fetch don't repeat it.
+	fetchLock    sync.Mutex
+	etag         string
+	lastModified string
+	lastFetch    time.Time
+	nextFetch    time.Time
+}
+
+// DefaultJWKSRefreshInterval is the longest tenant key sets are kept before
+// they're fetched again, unless configured otherwise.
+const DefaultJWKSRefreshInterval = 15 * time.Minute
+
+// The shortest time between fetches of a tenant's key set.
+const jwksMinRefreshInterval = 30 * time.Second
+
+// How long to wait for a tenant's key set to be fetched.
+const jwksFetchTimeout = 10 * time.Second
+
+// The largest key set that will be read.
+const jwksMaxSize = 1 << 20
+
+func NewJWKSSecretsManager(ctx context.Context, urls map[string]string, refreshInterval time.Duration, allowInsecureHTTP bool) (*JWKSSecretsManager, error) {
+	client := &http.Client{Timeout: jwksFetchTimeout}
+	return newJWKSSecretsManager(ctx, client, urls, refreshInterval, jwksMinRefreshInterval, allowInsecureHTTP)
+}
+
+func newJWKSSecretsManager(ctx context.Context, client *http.Client, urls map[string]string, refreshInterval time.Duration, minRefreshInterval time.Duration, allowInsecureHTTP bool) (*JWKSSecretsManager, error) {
+	ctx, span := otel.StartSpan(ctx, "NewJWKSSecretsManager")
+	defer span.End()
+
+	if len(urls) == 0 {
+		err := errors.New("unexpectedly missing tenant JWKS urls")
+		return nil, otel.RecordOutcome(err, span)
+	}
+	if refreshInterval <= 0 {
+		refreshInterval = DefaultJWKSRefreshInterval
+	}
+	if minRefreshInterval > refreshInterval {
+		minRefreshInterval = refreshInterval
+	}
+
+	regex := regexp.MustCompile("^(test-)?[a-zA-Z0-9]+$")
+	tenants := make(map[string]*jwksTenant)
+	for tenantName, rawURL := range urls {
+		if !regex.MatchString(tenantName) {
+			err := errors.New("tenant names must be alphanumeric")
+			return nil, otel.RecordOutcome(err, span)
+		}
+		parsed, err := url.Parse(rawURL)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			err := fmt.Errorf("invalid JWKS url for tenant %s: %q", tenantName, rawURL)
+			return nil, otel.RecordOutcome(err, span)
+		}
+		// anyone on the path could substitute their own keys
+		if parsed.Scheme == "http" && !allowInsecureHTTP {
+			err := fmt.Errorf("the JWKS url for tenant %s must use https: %q", tenantName, rawURL)
+			return nil, otel.RecordOutcome(err, span)
+		}
+		tenants[tenantName] = &jwksTenant{name: tenantName, url: rawURL}
+	}
+
+	sm := &JWKSSecretsManager{
+		client:             client,
+		tenants:            tenants,
+		refreshInterval:    refreshInterval,
+		minRefreshInterval: minRefreshInterval,
+		stop:               make(chan struct{}),
+		done:               make(chan struct{}),
+	}
+
+	// One tenant's keys being unavailable shouldn't stop the others from
+	// being served, so these are retried rather than failing startup.
+	var wg sync.WaitGroup
+	for _, tenant := range tenants {
+		wg.Add(1)
+		go func(tenant *jwksTenant) {
+			defer wg.Done()
+			if err := sm.fetch(ctx, tenant, always); err != nil {
+				fmt.Printf("Failed to fetch the signing keys of tenant %s, will retry: %v\n", tenant.name, err)
+			}
+		}(tenant)
+	}
+	wg.Wait()
+
+	go sm.refresh()
+	return sm, nil
+}
+
+// Decides whether a fetch of the tenant's key set is still needed, once its
+// fetch lock is held.
+type fetchCondition func(tenant *jwksTenant, now time.Time) bool
+
+func always(*jwksTenant, time.Time) bool {
+	return true
+}
+
+// The set is stale according to its cache headers.
+func stale(tenant *jwksTenant, now time.Time) bool {
+	return !now.Before(tenant.nextFetch)
+}
+
+// The set wasn't fetched recently, which limits how often unknown kids can
+// cause a fetch.
+func (sm *JWKSSecretsManager) notRecentlyFetched(tenant *jwksTenant, now time.Time) bool {
+	return now.Sub(tenant.lastFetch) >= sm.minRefreshInterval
+}
+
+// Fetches the tenant's key set if the condition holds, swapping in its keys
+// if it's valid, and schedules the next fetch.
+func (sm *JWKSSecretsManager) fetch(ctx context.Context, tenant *jwksTenant, condition fetchCondition) error {
+	tenant.fetchLock.Lock()
+	defer tenant.fetchLock.Unlock()
+
+	now := time.Now()
+	if !condition(tenant, now) {
+		return nil
+	}
+
+	ctx, span := otel.StartSpan(ctx, "FetchJWKS")
+	defer span.End()
+	span.SetAttributes(attribute.String("tenant", tenant.name))
+
+	tenant.lastFetch = now
+	// until the set is fetched successfully
+	tenant.nextFetch = now.Add(sm.minRefreshInterval)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tenant.url, nil)
+	if err != nil {
+		return otel.RecordOutcome(err, span)
+	}
+	req.Header.Set("Accept", "application/jwk-set+json, application/json")
+	if tenant.keys.Load() != nil {
+		if tenant.etag != "" {
+			req.Header.Set("If-None-Match", tenant.etag)
+		}
+		if tenant.lastModified != "" {
+			req.Header.Set("If-Modified-Since", tenant.lastModified)
+		}
+	}
+
+	resp, err := sm.client.Do(req)
+	if err != nil {
+		return otel.RecordOutcome(err, span)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		tenant.nextFetch = now.Add(sm.cacheLifetime(resp.Header, now))
+		return nil
+	default:
+		err := fmt.Errorf("unexpected status fetching %s: %s", tenant.url, resp.Status)
+		return otel.RecordOutcome(err, span)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, jwksMaxSize+1))
+	if err != nil {
+		return otel.RecordOutcome(err, span)
+	}
+	if len(body) > jwksMaxSize {
+		err := fmt.Errorf("JWKS at %s is larger than %d bytes", tenant.url, jwksMaxSize)
+		return otel.RecordOutcome(err, span)
+	}
+	keys, err := parseJWKS(tenant.name, body)
+	if err != nil {
+		err = fmt.Errorf("invalid JWKS at %s: %w", tenant.url, err)
+		return otel.RecordOutcome(err, span)
+	}
+
+	tenant.keys.Store(&keys)
+	tenant.etag = resp.Header.Get("ETag")
+	tenant.lastModified = resp.Header.Get("Last-Modified")
+	tenant.nextFetch = now.Add(sm.cacheLifetime(resp.Header, now))
+	return nil
+}
+
+// Returns how long a response can be used for according to its
+// Cache-Control or Expires headers, kept between the minimum and maximum
+// refresh intervals.
+func (sm *JWKSSecretsManager) cacheLifetime(header http.Header, now time.Time) time.Duration {
+	lifetime := sm.refreshInterval
+	if maxAge, ok := parseCacheControlMaxAge(header.Get("Cache-Control")); ok {
+		lifetime = maxAge
+		if age, err := strconv.ParseUint(header.Get("Age"), 10, 32); err == nil {
+			lifetime -= time.Duration(age) * time.Second
+		}
+	} else if expiresHeader := header.Get("Expires"); expiresHeader != "" {
+		// an invalid date means the response has already expired
+		lifetime = 0
+		if expires, err := http.ParseTime(expiresHeader); err == nil {
+			date, err := http.ParseTime(header.Get("Date"))
+			if err != nil {
+				date = now
+			}
+			lifetime = expires.Sub(date)
+		}
+	}
+
+	if lifetime < sm.minRefreshInterval {
+		return sm.minRefreshInterval
+	}
+	if lifetime > sm.refreshInterval {
+		return sm.refreshInterval
+	}
+	return lifetime
+}
+
+// Returns the max-age of a Cache-Control header, which is 0 when the
+// response mustn't be reused, and whether it had one.
+func parseCacheControlMaxAge(cacheControl string) (time.Duration, bool) {
+	var maxAge time.Duration
+	found := false
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache":
+			return 0, true
+		case "max-age":
+			seconds, err := strconv.ParseUint(strings.Trim(value, `"`), 10, 32)
+			if err != nil {
+				return 0, true
+			}
+			maxAge = time.Duration(seconds) * time.Second
+			found = true
+		}
+	}
+	return maxAge, found
+}
+
+// Fetches each tenant's key set again once it's stale, checking as often as
+// a set can be fetched. It returns once the secrets manager is closed.
+func (sm *JWKSSecretsManager) refresh() {
+	defer close(sm.done)
+
+	ticker := time.NewTicker(sm.minRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sm.stop:
+			return
+		case <-ticker.C:
+		}
+
+		for _, tenant := range sm.tenants {
+			if err := sm.fetch(context.Background(), tenant, stale); err != nil {
+				fmt.Printf("Keeping the current signing keys of tenant %s, failed to refresh them: %v\n", tenant.name, err)
+			}
+		}
+	}
+}
+
+func (sm *JWKSSecretsManager) Close() {
+	close(sm.stop)
+	<-sm.done
+}
+
+func (sm *JWKSSecretsManager) GetSecret(ctx context.Context, name string, version uint64) ([]byte, error) {
+	ctx, span := otel.StartSpan(ctx, "GetSecret")
+	defer span.End()
+
+	tenantName, ok := strings.CutPrefix(name, types.JuiceboxTenantSecretPrefix)
+	if !ok {
+		err := fmt.Errorf("no JWKS for secret %s", name)
+		return nil, otel.RecordOutcome(err, span)
+	}
+	tenant, ok := sm.tenants[tenantName]
+	if !ok {
+		err := fmt.Errorf("no JWKS for tenant %s", tenantName)
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	if key, ok := tenant.key(version); ok {
+		return key, nil
+	}
+
+	// The key may have been published since the set was last fetched.
+	if err := sm.fetch(ctx, tenant, sm.notRecentlyFetched); err != nil {
+		fmt.Printf("Failed to refresh the signing keys of tenant %s: %v\n", tenantName, err)
+	}
+	if key, ok := tenant.key(version); ok {
+		return key, nil
+	}
+
+	err := fmt.Errorf("no key for version %d of tenant %s in its JWKS", version, tenantName)
+	return nil, otel.RecordOutcome(err, span)
+}
+
+// Invalidate fetches the named tenant's key set again, so that a key removed
+// from it stops being used without waiting for the set to become stale.
+func (sm *JWKSSecretsManager) Invalidate(ctx context.Context, name string) error {
+	tenantName, ok := strings.CutPrefix(name, types.JuiceboxTenantSecretPrefix)
+	tenant, found := sm.tenants[tenantName]
+	if !ok || !found {
+		return fmt.Errorf("no JWKS for secret %s", name)
+	}
+	return sm.fetch(ctx, tenant, always)
+}
+
+func (tenant *jwksTenant) key(version uint64) ([]byte, bool) {
+	keys := tenant.keys.Load()
+	if keys == nil {
+		return nil, false
+	}
+	key, ok := (*keys)[version]
+	return key, ok
+}
+
+// A JSON Web Key, as described by RFC 7517, with the parameters of the key
+// types that are supported.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// Returns the tenant's signing keys in a JWKS, as auth key objects keyed by
+// version. Keys for other tenants and keys of unsupported types are skipped.
+func parseJWKS(tenantName string, body []byte) (map[uint64][]byte, error) {
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(body, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[uint64][]byte)
+	for _, jwk := range jwks.Keys {
+		kidTenant, kidVersion, ok := strings.Cut(jwk.Kid, ":")
+		if !ok || kidTenant != tenantName || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+		version, err := strconv.ParseUint(kidVersion, 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, ok := keys[version]; ok {
+			return nil, fmt.Errorf("more than one key has kid %s", jwk.Kid)
+		}
+
+		authKey, err := jwk.authKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", jwk.Kid, err)
+		}
+		if authKey == nil {
+			continue
+		}
+		key, err := json.Marshal(authKey)
+		if err != nil {
+			return nil, err
+		}
+		keys[version] = key
+	}
+	return keys, nil
+}
+
+// The supported curves of EC keys, and the algorithm each is used with.
+var jwkCurves = map[string]struct {
+	alg       string
+	algorithm types.AuthKeyAlgorithm
+	curve     elliptic.Curve
+	ecdh      ecdh.Curve
+}{
+	"P-256": {"ES256", types.ES256, elliptic.P256(), ecdh.P256()},
+	"P-384": {"ES384", types.ES384, elliptic.P384(), ecdh.P384()},
+}
+
+// Returns the key as an auth key object, or nil if its type isn't
+// supported.
+func (jwk *jsonWebKey) authKey() (*types.AuthKeyJSON, error) {
+	var algorithm types.AuthKeyAlgorithm
+	var pubKey interface{}
+
+	switch {
+	case jwk.Kty == "RSA" && (jwk.Alg == "" || jwk.Alg == "RS256" || jwk.Alg == "PS256"):
+		n, err := decodeJWKParam("n", jwk.N, 0)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKParam("e", jwk.E, 0)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		// without an alg, RSA keys are used with RS256
+		algorithm = types.RS256
+		if jwk.Alg == "PS256" {
+			algorithm = types.PS256
+		}
+		pubKey = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519" && (jwk.Alg == "" || jwk.Alg == "EdDSA"):
+		x, err := decodeJWKParam("x", jwk.X, ed25519.PublicKeySize)
+		if err != nil {
+			return nil, err
+		}
+		algorithm = types.EdDSA
+		pubKey = ed25519.PublicKey(x)
+
+	case jwk.Kty == "EC" && jwkCurves[jwk.Crv].alg != "" && (jwk.Alg == "" || jwk.Alg == jwkCurves[jwk.Crv].alg):
+		curve := jwkCurves[jwk.Crv]
+		size := (curve.curve.Params().BitSize + 7) / 8
+		x, err := decodeJWKParam("x", jwk.X, size)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKParam("y", jwk.Y, size)
+		if err != nil {
+			return nil, err
+		}
+		// checks the point is on the curve
+		if _, err := curve.ecdh.NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, err
+		}
+		algorithm = curve.algorithm
+		pubKey = &ecdsa.PublicKey{Curve: curve.curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+
+	default:
+		return nil, nil
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		return nil, err
+	}
+	return &types.AuthKeyJSON{
+		Data:      hex.EncodeToString(der),
+		Encoding:  types.Hex,
+		Algorithm: algorithm,
+	}, nil
+}
+
+// Decodes a base64url parameter of a JWK, which must be size bytes long
+// unless size is 0.
+func decodeJWKParam(name string, value string, size int) ([]byte, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(decoded) == 0 {
+		return nil, fmt.Errorf("invalid %s parameter", name)
+	}
+	if size != 0 && len(decoded) != size {
+		return nil, fmt.Errorf("%s parameter must be %d bytes, got %d", name, size, len(decoded))
+	}
+	return decoded, nil
+}
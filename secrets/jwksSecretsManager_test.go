@@ -0,0 +1,298 @@
+package secrets
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptoRand "crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves a key set that the test can change, counting the requests for it.
+type testJWKSServer struct {
+	*httptest.Server
+	lock         sync.Mutex
+	keys         []map[string]string
+	status       int
+	cacheControl string
+	etag         string
+	requests     int
+}
+
+func newTestJWKSServer(t *testing.T) *testJWKSServer {
+	s := &testJWKSServer{status: http.StatusOK}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.requests++
+
+		if s.cacheControl != "" {
+			w.Header().Set("Cache-Control", s.cacheControl)
+		}
+		if s.etag != "" {
+			w.Header().Set("ETag", s.etag)
+			if r.Header.Get("If-None-Match") == s.etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/jwk-set+json")
+		w.WriteHeader(s.status)
+		assert.NoError(t, json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys}))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *testJWKSServer) update(f func(s *testJWKSServer)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f(s)
+}
+
+func (s *testJWKSServer) requestCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func rsaJWK(kid string, alg string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{"kty": "RSA", "kid": kid, "alg": alg, "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	size := (key.Curve.Params().BitSize + 7) / 8
+	return map[string]string{"kty": "EC", "kid": kid, "crv": key.Curve.Params().Name, "x": b64(key.X.FillBytes(make([]byte, size))), "y": b64(key.Y.FillBytes(make([]byte, size)))}
+}
+
+func ed25519JWK(kid string, key ed25519.PublicKey) map[string]string {
+	return map[string]string{"kty": "OKP", "kid": kid, "crv": "Ed25519", "use": "sig", "x": b64(key)}
+}
+
+func TestJWKSSecretsManager(t *testing.T) {
+	ctx := context.Background()
+	rsaKey, err := rsa.GenerateKey(cryptoRand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), cryptoRand.Reader)
+	assert.NoError(t, err)
+	ec384Key, err := ecdsa.GenerateKey(elliptic.P384(), cryptoRand.Reader)
+	assert.NoError(t, err)
+	edPublic, edPrivate, err := ed25519.GenerateKey(cryptoRand.Reader)
+	assert.NoError(t, err)
+
+	server := newTestJWKSServer(t)
+	server.keys = []map[string]string{
+		rsaJWK("acme:1", "RS256", &rsaKey.PublicKey),
+		ecJWK("acme:2", &ecKey.PublicKey),
+		ed25519JWK("acme:3", edPublic),
+		ecJWK("acme:8", &ec384Key.PublicKey),
+		rsaJWK("acme:9", "PS256", &rsaKey.PublicKey),
+		// these are skipped
+		ed25519JWK("globex:4", edPublic),
+		ed25519JWK("acme:latest", edPublic),
+		{"kty": "oct", "kid": "acme:5", "k": b64([]byte("acme-key-5"))},
+		{"kty": "RSA", "kid": "acme:6", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		{"kty": "EC", "kid": "acme:7", "crv": "P-521", "x": "AQAB", "y": "AQAB"},
+	}
+
+	sm, err := newJWKSSecretsManager(ctx, server.Client(), map[string]string{"acme": server.URL}, time.Hour, jwksMinRefreshInterval, false)
+	assert.NoError(t, err)
+	defer sm.Close()
+
+	for version, expected := range map[uint64]struct {
+		alg    string
+		key    crypto.PublicKey
+		signer crypto.Signer
+	}{
+		1: {"RS256", &rsaKey.PublicKey, rsaKey},
+		2: {"ES256", &ecKey.PublicKey, ecKey},
+		3: {"EdDSA", edPublic, edPrivate},
+		8: {"ES384", &ec384Key.PublicKey, ec384Key},
+		9: {"PS256", &rsaKey.PublicKey, rsaKey},
+	} {
+		secret, err := sm.GetSecret(ctx, types.JuiceboxTenantSecretPrefix+"acme", version)
+		assert.NoError(t, err)
+		parsedKey, err := ParseAuthKey(secret, expected.alg)
+		assert.NoError(t, err)
+		assert.Equal(t, expected.key, parsedKey)
+
+		// a token signed with the key verifies
+		token := jwt.NewWithClaims(jwt.GetSigningMethod(expected.alg), jwt.MapClaims{"sub": "artemis"})
+		token.Header["kid"] = fmt.Sprintf("acme:%d", version)
+		signed, err := token.SignedString(expected.signer)
+		assert.NoError(t, err)
+		_, err = jwt.Parse(signed, func(token *jwt.Token) (interface{}, error) {
+			return GetJWTSigningKey(ctx, sm, token)
+		})
+		assert.NoError(t, err)
+	}
+
+	// the set was fetched once, and a missing key is only fetched again
+	// once the set is stale
+	assert.Equal(t, 1, server.requestCount())
+	for _, version := range []uint64{4, 5, 6, 7} {
+		assertNoSecret(t, sm, "acme", version)
+	}
+	assertNoSecret(t, sm, "globex", 4)
+	assert.Equal(t, 1, server.requestCount())
+
+	// invalidating the tenant's keys fetches them again straight away, so a
+	// revoked key stops being used
+	server.update(func(s *testJWKSServer) { s.keys = s.keys[1:] })
+	assert.NoError(t, sm.Invalidate(ctx, types.JuiceboxTenantSecretPrefix+"acme"))
+	assertNoSecret(t, sm, "acme", 1)
+	assert.Equal(t, 2, server.requestCount())
+	assert.Error(t, sm.Invalidate(ctx, types.JuiceboxTenantSecretPrefix+"globex"))
+
+	_, err = NewJWKSSecretsManager(ctx, map[string]string{"acme": "ftp://example.com/jwks.json"}, time.Hour, true)
+	assert.ErrorContains(t, err, "invalid JWKS url for tenant acme")
+	_, err = NewJWKSSecretsManager(ctx, map[string]string{"ac/me": server.URL}, time.Hour, false)
+	assert.ErrorContains(t, err, "tenant names must be alphanumeric")
+}
+
+func TestJWKSSecretsManagerInsecureHTTP(t *testing.T) {
+	ctx := context.Background()
+	edPublic, _, err := ed25519.GenerateKey(cryptoRand.Reader)
+	assert.NoError(t, err)
+	keys := map[string]interface{}{"keys": []map[string]string{ed25519JWK("acme:1", edPublic)}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewEncoder(w).Encode(keys))
+	}))
+	defer server.Close()
+
+	// plain http is refused unless it's explicitly allowed
+	_, err = NewJWKSSecretsManager(ctx, map[string]string{"acme": server.URL}, time.Hour, false)
+	assert.EqualError(t, err, fmt.Sprintf("the JWKS url for tenant acme must use https: %q", server.URL))
+
+	sm, err := NewJWKSSecretsManager(ctx, map[string]string{"acme": server.URL}, time.Hour, true)
+	assert.NoError(t, err)
+	defer sm.Close()
+	_, err = sm.GetSecret(ctx, types.JuiceboxTenantSecretPrefix+"acme", 1)
+	assert.NoError(t, err)
+}
+
+func TestJWKSSecretsManagerConcurrentMisses(t *testing.T) {
+	ctx := context.Background()
+	edPublic, _, err := ed25519.GenerateKey(cryptoRand.Reader)
+	assert.NoError(t, err)
+
+	server := newTestJWKSServer(t)
+	server.keys = []map[string]string{ed25519JWK("acme:1", edPublic)}
+
+	sm, err := newJWKSSecretsManager(ctx, server.Client(), map[string]string{"acme": server.URL}, time.Hour, 50*time.Millisecond, false)
+	assert.NoError(t, err)
+	defer sm.Close()
+	assert.Equal(t, 1, server.requestCount())
+
+	// lookups of a missing key that wait on the same fetch don't repeat it
+	time.Sleep(60 * time.Millisecond)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assertNoSecret(t, sm, "acme", 2)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, server.requestCount())
+}
+
+func TestJWKSSecretsManagerRefresh(t *testing.T) {
+	ctx := context.Background()
+	edPublic1, _, err := ed25519.GenerateKey(cryptoRand.Reader)
+	assert.NoError(t, err)
+	edPublic2, _, err := ed25519.GenerateKey(cryptoRand.Reader)
+	assert.NoError(t, err)
+
+	server := newTestJWKSServer(t)
+	server.keys = []map[string]string{ed25519JWK("acme:1", edPublic1)}
+	server.etag = `"v1"`
+
+	sm, err := newJWKSSecretsManager(ctx, server.Client(), map[string]string{"acme": server.URL}, time.Hour, 10*time.Millisecond, false)
+	assert.NoError(t, err)
+	defer sm.Close()
+	secret, err := sm.GetSecret(ctx, types.JuiceboxTenantSecretPrefix+"acme", 1)
+	assert.NoError(t, err)
+
+	// a key published since the set was fetched is fetched when it's used
+	server.update(func(s *testJWKSServer) {
+		s.keys = append(s.keys, ed25519JWK("acme:2", edPublic2))
+		s.etag = `"v2"`
+	})
+	time.Sleep(20 * time.Millisecond)
+	_, err = sm.GetSecret(ctx, types.JuiceboxTenantSecretPrefix+"acme", 2)
+	assert.NoError(t, err)
+
+	// failed fetches keep the keys already fetched
+	server.update(func(s *testJWKSServer) {
+		s.status = http.StatusInternalServerError
+		s.etag = ""
+	})
+	time.Sleep(20 * time.Millisecond)
+	assertNoSecret(t, sm, "acme", 3)
+	assertSecret(t, sm, "acme", 1, string(secret))
+
+	// the set is fetched again in the background once its cache headers
+	// say it's stale, and an unchanged set isn't sent again
+	server.update(func(s *testJWKSServer) {
+		s.status = http.StatusOK
+		s.keys = s.keys[1:]
+		s.cacheControl = "public, max-age=0"
+		s.etag = `"v3"`
+	})
+	assert.Eventually(t, func() bool {
+		_, err := sm.GetSecret(ctx, types.JuiceboxTenantSecretPrefix+"acme", 1)
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	requests := server.requestCount()
+	assert.Eventually(t, func() bool {
+		return server.requestCount() > requests+2
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = sm.GetSecret(ctx, types.JuiceboxTenantSecretPrefix+"acme", 2)
+	assert.NoError(t, err)
+}
+
+func TestJWKSCacheLifetime(t *testing.T) {
+	sm := &JWKSSecretsManager{refreshInterval: time.Hour, minRefreshInterval: time.Minute}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for headers, expected := range map[[3]string]time.Duration{
+		{"", "", ""}:                                         time.Hour,
+		{"max-age=600", "", ""}:                              10 * time.Minute,
+		{"public, max-age=600", "", ""}:                      10 * time.Minute,
+		{"max-age=86400", "", ""}:                            time.Hour,
+		{"max-age=10", "", ""}:                               time.Minute,
+		{"no-cache", "", ""}:                                 time.Minute,
+		{"max-age=600, no-store", "", ""}:                    time.Minute,
+		{"max-age=600", "300", ""}:                           5 * time.Minute,
+		{"", "", "Mon, 01 Jan 2024 00:20:00 GMT"}:            20 * time.Minute,
+		{"max-age=600", "", "Mon, 01 Jan 2024 00:20:00 GMT"}: 10 * time.Minute,
+		{"", "", "0"}:                                        time.Minute,
+	} {
+		header := http.Header{}
+		header.Set("Cache-Control", headers[0])
+		header.Set("Age", headers[1])
+		header.Set("Expires", headers[2])
+		assert.Equal(t, expected, sm.cacheLifetime(header, now), headers)
+	}
+}
@@ -2,10 +2,8 @@ package secrets
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"regexp"
 
 	"github.com/juicebox-systems/juicebox-software-realm/otel"
@@ -16,23 +14,16 @@ type MemorySecretsManager struct {
 	secrets map[string]map[uint64][]byte
 }
 
-func NewMemorySecretsManager(ctx context.Context) (*MemorySecretsManager, error) {
-	return NewMemorySecretsManagerWithPrefix(ctx, types.JuiceboxTenantSecretPrefix)
+func NewMemorySecretsManager(ctx context.Context, tenantSecrets map[string]map[uint64]string) (*MemorySecretsManager, error) {
+	return NewMemorySecretsManagerWithPrefix(ctx, tenantSecrets, types.JuiceboxTenantSecretPrefix)
 }
 
-func NewMemorySecretsManagerWithPrefix(ctx context.Context, secretPrefix string) (*MemorySecretsManager, error) {
+func NewMemorySecretsManagerWithPrefix(ctx context.Context, tenantSecrets map[string]map[uint64]string, secretPrefix string) (*MemorySecretsManager, error) {
 	_, span := otel.StartSpan(ctx, "NewMemorySecretsManager")
 	defer span.End()
 
-	secretsJSON := os.Getenv("TENANT_SECRETS")
-	if secretsJSON == "" {
-		err := errors.New("unexpectedly missing TENANT_SECRETS")
-		return nil, otel.RecordOutcome(err, span)
-	}
-
-	var unmarshaledSecrets map[string]map[uint64]string
-	err := json.Unmarshal([]byte(secretsJSON), &unmarshaledSecrets)
-	if err != nil {
+	if len(tenantSecrets) == 0 {
+		err := errors.New("unexpectedly missing tenant secrets")
 		return nil, otel.RecordOutcome(err, span)
 	}
 
@@ -40,7 +31,7 @@ func NewMemorySecretsManagerWithPrefix(ctx context.Context, secretPrefix string)
 
 	regex := regexp.MustCompile("^(test-)?[a-zA-Z0-9]+$")
 
-	for tenantName, versionAndSecrets := range unmarshaledSecrets {
+	for tenantName, versionAndSecrets := range tenantSecrets {
 		if match := regex.MatchString(tenantName); !match {
 			err := errors.New("tenant names must be alphanumeric")
 			return nil, otel.RecordOutcome(err, span)
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"net/url"
-	"os"
 
 	"github.com/juicebox-systems/juicebox-software-realm/otel"
 	"github.com/juicebox-systems/juicebox-software-realm/types"
@@ -12,6 +11,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 	"go.opentelemetry.io/otel/trace"
 )
@@ -25,7 +25,7 @@ const secretsCollection string = "tenantSecrets"
 const secretsVersionKey string = "version"
 const secretsSecretKey string = "secret"
 
-func NewMongoSecretsManager(ctx context.Context, realmID types.RealmID) (*MongoSecretsManager, error) {
+func NewMongoSecretsManager(ctx context.Context, urlString string, realmID types.RealmID) (*MongoSecretsManager, error) {
 	ctx, span := otel.StartSpan(
 		ctx,
 		"NewMongoSecretsManager",
@@ -34,9 +34,8 @@ func NewMongoSecretsManager(ctx context.Context, realmID types.RealmID) (*MongoS
 	)
 	defer span.End()
 
-	urlString := os.Getenv("MONGO_URL")
 	if urlString == "" {
-		err := errors.New("unexpectedly missing MONGO_URL")
+		err := errors.New("unexpectedly missing mongo URL")
 		return nil, otel.RecordOutcome(err, span)
 	}
 
@@ -62,6 +61,23 @@ func NewMongoSecretsManager(ctx context.Context, realmID types.RealmID) (*MongoS
 	}, nil
 }
 
+func (sm MongoSecretsManager) Close() {
+	sm.client.Disconnect(context.Background())
+}
+
+func (sm MongoSecretsManager) CheckHealth(ctx context.Context) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"CheckHealth",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemMongoDB),
+	)
+	defer span.End()
+
+	err := sm.client.Ping(ctx, readpref.Primary())
+	return otel.RecordOutcome(err, span)
+}
+
 func (sm MongoSecretsManager) GetSecret(ctx context.Context, name string, version uint64) ([]byte, error) {
 	ctx, span := otel.StartSpan(
 		ctx,
@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type PostgresSecretsManager struct {
+	pool      *pgxpool.Pool
+	tableName string
+}
+
+const secretsTable string = "tenant_secrets"
+
+func NewPostgresSecretsManager(ctx context.Context, urlString string, realmID types.RealmID) (*PostgresSecretsManager, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"NewPostgresSecretsManager",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	if urlString == "" {
+		err := errors.New("unexpectedly missing postgres URL")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	pool, err := pgxpool.New(ctx, urlString)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	schemaName := pgx.Identifier{types.JuiceboxRealmDatabasePrefix + realmID.String()}.Sanitize()
+	tableName := schemaName + "." + pgx.Identifier{secretsTable}.Sanitize()
+
+	_, err = pool.Exec(ctx, "CREATE SCHEMA IF NOT EXISTS "+schemaName)
+	if err != nil {
+		pool.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	_, err = pool.Exec(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (
+			name TEXT NOT NULL,
+			version BIGINT NOT NULL,
+			secret BYTEA NOT NULL,
+			PRIMARY KEY (name, version)
+		)`,
+		tableName,
+	))
+	if err != nil {
+		pool.Close()
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	return &PostgresSecretsManager{
+		pool:      pool,
+		tableName: tableName,
+	}, nil
+}
+
+func (sm PostgresSecretsManager) Close() {
+	sm.pool.Close()
+}
+
+func (sm PostgresSecretsManager) CheckHealth(ctx context.Context) error {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"CheckHealth",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	err := sm.pool.Ping(ctx)
+	return otel.RecordOutcome(err, span)
+}
+
+func (sm PostgresSecretsManager) GetSecret(ctx context.Context, name string, version uint64) ([]byte, error) {
+	ctx, span := otel.StartSpan(
+		ctx,
+		"GetSecret",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+	)
+	defer span.End()
+
+	var secret []byte
+	err := sm.pool.QueryRow(
+		ctx,
+		"SELECT secret FROM "+sm.tableName+" WHERE name = $1 AND version = $2",
+		name,
+		int64(version),
+	).Scan(&secret)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	return secret, nil
+}
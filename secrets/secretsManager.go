@@ -1,17 +1,21 @@
 package secrets
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
 	"crypto/x509"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
-	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/golang-jwt/jwt/v5"
@@ -71,16 +75,35 @@ func ParseAuthKey(key []byte, alg string) (interface{}, error) {
 		if err != nil {
 			return nil, errors.New("invalid signing key hex")
 		}
-		switch authKeyJSON.Algorithm {
-		case types.HS256:
+		if authKeyJSON.Algorithm == types.HS256 {
 			return data, nil
-		case types.EdDSA, types.RS256:
-			pubKey, err := x509.ParsePKIXPublicKey(data)
-			if err != nil {
-				return nil, errors.New("invalid public key")
-			}
-			return pubKey, nil
 		}
+		pubKey, err := x509.ParsePKIXPublicKey(data)
+		if err != nil {
+			return nil, errors.New("invalid public key")
+		}
+		return checkPublicKey(pubKey, authKeyJSON.Algorithm)
+	case types.PEM:
+		if authKeyJSON.Algorithm == types.HS256 {
+			return nil, fmt.Errorf("pem encoding is not valid for this algorithm=%s", authKeyJSON.Algorithm)
+		}
+		block, rest := pem.Decode([]byte(authKeyJSON.Data))
+		if block == nil || len(bytes.TrimSpace(rest)) != 0 {
+			return nil, errors.New("invalid signing key pem")
+		}
+		var pubKey interface{}
+		switch block.Type {
+		case "PUBLIC KEY":
+			pubKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "RSA PUBLIC KEY":
+			pubKey, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		default:
+			return nil, fmt.Errorf("unexpected pem block type=%s", block.Type)
+		}
+		if err != nil {
+			return nil, errors.New("invalid public key")
+		}
+		return checkPublicKey(pubKey, authKeyJSON.Algorithm)
 	case types.UTF8:
 		switch authKeyJSON.Algorithm {
 		case types.HS256:
@@ -93,6 +116,25 @@ func ParseAuthKey(key []byte, alg string) (interface{}, error) {
 	return nil, fmt.Errorf("invalid signing key encoding=%s", authKeyJSON.Encoding)
 }
 
+// Returns the public key if it's the type of key the algorithm uses, so that
+// a token can't be checked with the wrong algorithm for its key.
+func checkPublicKey(pubKey interface{}, algorithm types.AuthKeyAlgorithm) (interface{}, error) {
+	valid := false
+	switch key := pubKey.(type) {
+	case ed25519.PublicKey:
+		valid = algorithm == types.EdDSA
+	case *rsa.PublicKey:
+		valid = algorithm == types.RS256 || algorithm == types.PS256
+	case *ecdsa.PublicKey:
+		valid = (algorithm == types.ES256 && key.Curve == elliptic.P256()) ||
+			(algorithm == types.ES384 && key.Curve == elliptic.P384())
+	}
+	if !valid {
+		return nil, fmt.Errorf("public key is not valid for this algorithm=%s", algorithm)
+	}
+	return pubKey, nil
+}
+
 func ParseKid(token *jwt.Token) (*string, *uint64, error) {
 	kid, ok := token.Header["kid"]
 	if !ok {
@@ -132,79 +174,37 @@ func NewSecretsManager(ctx context.Context, provider types.ProviderName, opts ty
 	var sm SecretsManager
 	switch provider {
 	case types.GCP:
-		sm, err = NewGcpSecretsManager(ctx)
-	case types.Memory:
-		sm, err = NewMemorySecretsManager(ctx)
+		sm, err = NewGcpSecretsManager(ctx, opts.GcpProjectID)
+	case types.Memory, types.Local:
+		sm, err = NewMemorySecretsManager(ctx, opts.TenantSecrets)
 	case types.AWS:
 		sm, err = NewAwsSecretsManager(ctx, opts.Config.(aws.Config))
 	case types.Mongo:
-		sm, err = NewMongoSecretsManager(ctx, realmID)
+		sm, err = NewMongoSecretsManager(ctx, opts.MongoURL, realmID)
+	case types.Postgres:
+		sm, err = NewPostgresSecretsManager(ctx, opts.PostgresURL, realmID)
+	case types.Vault:
+		sm, err = NewVaultSecretsManager(ctx, opts.Vault)
+	case types.File:
+		// The secrets are already in memory, and caching them would keep
+		// serving secrets that a reload removed or replaced.
+		fileSecretsManager, err := NewFileSecretsManager(ctx, opts.TenantSecretsPath, opts.TenantSecretsReloadInterval)
+		if err != nil {
+			return nil, otel.RecordOutcome(err, span)
+		}
+		return fileSecretsManager, nil
+	case types.JWKS:
+		// The keys are already cached, for as long as their key sets allow.
+		jwksSecretsManager, err := NewJWKSSecretsManager(ctx, opts.JWKSURLs, opts.JWKSRefreshInterval, opts.JWKSAllowInsecureHTTP)
+		if err != nil {
+			return nil, otel.RecordOutcome(err, span)
+		}
+		return jwksSecretsManager, nil
 	default:
 		err = fmt.Errorf("unexpected provider %v", provider)
 	}
 	if err != nil {
 		return nil, otel.RecordOutcome(err, span)
 	}
-	return newCachingSecretsManager(sm), nil
-}
-
-type cachingSecretsManager struct {
-	inner SecretsManager
-	lock  sync.Mutex
-	cache map[cacheKey][]byte
-}
-
-func newCachingSecretsManager(inner SecretsManager) SecretsManager {
-	return &cachingSecretsManager{
-		inner: inner,
-		cache: make(map[cacheKey][]byte),
-	}
-}
-
-func (c *cachingSecretsManager) GetSecret(ctx context.Context, name string, version uint64) ([]byte, error) {
-	key := cacheKey{
-		name:    name,
-		version: version,
-	}
-	secret, ok := c.getCached(key)
-	if ok {
-		return secret, nil
-	}
-	secret, err := c.inner.GetSecret(ctx, name, version)
-	if err != nil {
-		return nil, err
-	}
-	c.addToCache(key, secret)
-	return secret, nil
-}
-
-func (c *cachingSecretsManager) addToCache(key cacheKey, secret []byte) {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	_, exists := c.cache[key]
-	c.cache[key] = secret
-	if !exists {
-		go func() {
-			time.Sleep(time.Second * 60 * 60)
-			c.expire(key)
-		}()
-	}
-}
-
-func (c *cachingSecretsManager) getCached(k cacheKey) ([]byte, bool) {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	secret, ok := c.cache[k]
-	return secret, ok
-}
-
-func (c *cachingSecretsManager) expire(key cacheKey) {
-	c.lock.Lock()
-	defer c.lock.Unlock()
-	delete(c.cache, key)
-}
-
-type cacheKey struct {
-	name    string
-	version uint64
+	return newCachingSecretsManager(sm, secretsCacheSize, secretsCacheTTL, secretsCacheFailureTTL), nil
 }
@@ -0,0 +1,254 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/api/auth/approle"
+	"github.com/hashicorp/vault/api/auth/kubernetes"
+	"github.com/juicebox-systems/juicebox-software-realm/otel"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+)
+
+// VaultSecretsManager reads tenant secrets from a KV v2 secrets engine in
+// HashiCorp Vault. Each secret is stored at the path of its name, and each
+// of its versions is the KV version with the same number. The secret itself
+// is kept in the version's "secret" field.
+//
+// The token used to read secrets is renewed in the background for as long
+// as Vault allows. After that, or shortly before a token that can't be
+// renewed expires, a new token is obtained by logging in again, unless the
+// token was given directly.
+type VaultSecretsManager struct {
+	client     *vault.Client
+	kv         *vault.KVv2
+	pathPrefix string
+	method     vaultAuthMethod
+	// Whether the method can log in again once the token expires, which it
+	// can't when the token was given directly.
+	relogin bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+const vaultSecretField string = "secret"
+
+// How long to wait before logging in again after a failed attempt.
+const vaultLoginRetryDelay = 10 * time.Second
+
+// Returns how long to use a token that can't be renewed before logging in
+// again, leaving a tenth of its lifetime to log in before it expires.
+func vaultReloginDelay(auth *vault.SecretAuth) time.Duration {
+	return time.Duration(auth.LeaseDuration) * time.Second * 9 / 10
+}
+
+// A vaultAuthMethod logs the client in, returning the secret holding the
+// token's lease, or nil if the token can't be renewed.
+type vaultAuthMethod func(ctx context.Context, client *vault.Client) (*vault.Secret, error)
+
+func NewVaultSecretsManager(ctx context.Context, opts types.VaultOptions) (*VaultSecretsManager, error) {
+	ctx, span := otel.StartSpan(ctx, "NewVaultSecretsManager")
+	defer span.End()
+
+	if opts.Address == "" {
+		err := errors.New("unexpectedly missing vault address")
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	method, err := newVaultAuthMethod(opts)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	config := vault.DefaultConfig()
+	config.Address = opts.Address
+	client, err := vault.NewClient(config)
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+	// don't pick up a token from the environment or a token helper
+	client.ClearToken()
+
+	auth, err := method(ctx, client)
+	if err != nil {
+		err = fmt.Errorf("error logging in to vault: %w", err)
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	mount := opts.Mount
+	if mount == "" {
+		mount = "secret"
+	}
+
+	renewCtx, cancel := context.WithCancel(context.Background())
+	sm := &VaultSecretsManager{
+		client:     client,
+		kv:         client.KVv2(mount),
+		pathPrefix: opts.PathPrefix,
+		method:     method,
+		relogin:    opts.AuthMethod == "approle" || opts.AuthMethod == "kubernetes",
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	go sm.renewToken(renewCtx, auth)
+	return sm, nil
+}
+
+func newVaultAuthMethod(opts types.VaultOptions) (vaultAuthMethod, error) {
+	switch opts.AuthMethod {
+	case "", "token":
+		if opts.Token == "" {
+			return nil, errors.New("unexpectedly missing vault token")
+		}
+		return func(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+			client.SetToken(opts.Token)
+			self, err := client.Auth().Token().LookupSelfWithContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if renewable, _ := self.TokenIsRenewable(); !renewable {
+				// such as a root token, which never expires
+				return nil, nil
+			}
+			return client.Auth().Token().RenewSelfWithContext(ctx, 0)
+		}, nil
+
+	case "approle":
+		var loginOpts []approle.LoginOption
+		if opts.AuthMount != "" {
+			loginOpts = append(loginOpts, approle.WithMountPath(opts.AuthMount))
+		}
+		auth, err := approle.NewAppRoleAuth(opts.RoleID, &approle.SecretID{FromString: opts.SecretID}, loginOpts...)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+			return client.Auth().Login(ctx, auth)
+		}, nil
+
+	case "kubernetes":
+		var loginOpts []kubernetes.LoginOption
+		if opts.AuthMount != "" {
+			loginOpts = append(loginOpts, kubernetes.WithMountPath(opts.AuthMount))
+		}
+		if opts.KubernetesTokenPath != "" {
+			loginOpts = append(loginOpts, kubernetes.WithServiceAccountTokenPath(opts.KubernetesTokenPath))
+		}
+		auth, err := kubernetes.NewKubernetesAuth(opts.KubernetesRole, loginOpts...)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, client *vault.Client) (*vault.Secret, error) {
+			return client.Auth().Login(ctx, auth)
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unexpected vault auth method %q", opts.AuthMethod)
+}
+
+// Renews the token's lease until it can't be renewed any further, then logs
+// in again for a new one. A token from a login that can't be renewed is used
+// until shortly before it expires. It returns once the secrets manager is
+// closed.
+func (sm *VaultSecretsManager) renewToken(ctx context.Context, auth *vault.Secret) {
+	defer close(sm.done)
+
+	for {
+		switch {
+		case auth != nil && auth.Auth != nil && auth.Auth.Renewable:
+			watcher, err := sm.client.NewLifetimeWatcher(&vault.LifetimeWatcherInput{Secret: auth})
+			if err != nil {
+				fmt.Printf("Unable to renew the vault token: %v\n", err)
+				return
+			}
+			go watcher.Start()
+
+			select {
+			case <-ctx.Done():
+				watcher.Stop()
+				return
+			case err := <-watcher.DoneCh():
+				if err != nil {
+					fmt.Printf("Failed to renew the vault token: %v\n", err)
+				}
+			}
+			watcher.Stop()
+
+			if !sm.relogin {
+				fmt.Printf("The vault token can't be renewed any further, and will expire.\n")
+				return
+			}
+
+		case sm.relogin && auth != nil && auth.Auth != nil && auth.Auth.LeaseDuration > 0:
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(vaultReloginDelay(auth.Auth)):
+			}
+
+		default:
+			// there's nothing to renew, such as a root token that never
+			// expires
+			return
+		}
+
+		for {
+			var err error
+			auth, err = sm.method(ctx, sm.client)
+			if err == nil {
+				break
+			}
+			fmt.Printf("Failed to log in to vault: %v\n", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(vaultLoginRetryDelay):
+			}
+		}
+	}
+}
+
+func (sm *VaultSecretsManager) Close() {
+	sm.cancel()
+	<-sm.done
+}
+
+func (sm *VaultSecretsManager) CheckHealth(ctx context.Context) error {
+	ctx, span := otel.StartSpan(ctx, "CheckHealth")
+	defer span.End()
+
+	_, err := sm.client.Auth().Token().LookupSelfWithContext(ctx)
+	return otel.RecordOutcome(err, span)
+}
+
+func (sm *VaultSecretsManager) GetSecret(ctx context.Context, name string, version uint64) ([]byte, error) {
+	ctx, span := otel.StartSpan(ctx, "GetSecret")
+	defer span.End()
+
+	// version 0 would read the latest version
+	if version == 0 || version > math.MaxInt32 {
+		err := fmt.Errorf("vault secret version %d is out of range", version)
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	result, err := sm.kv.GetVersion(ctx, sm.pathPrefix+name, int(version))
+	if err != nil {
+		return nil, otel.RecordOutcome(err, span)
+	}
+	if result.Data == nil {
+		err := fmt.Errorf("%w: version %d of %s was deleted", vault.ErrSecretNotFound, version, name)
+		return nil, otel.RecordOutcome(err, span)
+	}
+
+	secret, ok := result.Data[vaultSecretField].(string)
+	if !ok {
+		err := errors.New("secret unexpectedly missing 'secret' field")
+		return nil, otel.RecordOutcome(err, span)
+	}
+	return []byte(secret), nil
+}
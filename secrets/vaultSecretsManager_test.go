@@ -0,0 +1,173 @@
+package secrets
+
+import (
+	"context"
+	cryptoRand "crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+	"github.com/juicebox-systems/juicebox-software-realm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// These run against a dev mode Vault, which has a KV v2 engine mounted at
+// secret/:
+//
+//	vault server -dev -dev-root-token-id=root
+//	TEST_VAULT_ADDR=http://127.0.0.1:8200 TEST_VAULT_TOKEN=root go test ./secrets
+func newTestVault(t *testing.T) (*vault.Client, string) {
+	addr := os.Getenv("TEST_VAULT_ADDR")
+	token := os.Getenv("TEST_VAULT_TOKEN")
+	if addr == "" || token == "" {
+		t.Skip("TEST_VAULT_ADDR or TEST_VAULT_TOKEN isn't set")
+	}
+
+	config := vault.DefaultConfig()
+	config.Address = addr
+	client, err := vault.NewClient(config)
+	assert.NoError(t, err)
+	client.SetToken(token)
+
+	// each test keeps its secrets under its own prefix
+	var id [8]byte
+	_, err = cryptoRand.Read(id[:])
+	assert.NoError(t, err)
+	prefix := "jb-sw-realm-test-" + hex.EncodeToString(id[:]) + "/"
+
+	ctx := context.Background()
+	kv := client.KVv2("secret")
+	name := prefix + types.JuiceboxTenantSecretPrefix + "acme"
+	for _, secret := range []string{"acme-key-1", "acme-key-2"} {
+		_, err := kv.Put(ctx, name, map[string]interface{}{"secret": secret})
+		assert.NoError(t, err)
+	}
+	return client, prefix
+}
+
+func testVaultSecrets(t *testing.T, sm *VaultSecretsManager) {
+	ctx := context.Background()
+	name := types.JuiceboxTenantSecretPrefix + "acme"
+
+	for version, expected := range map[uint64]string{1: "acme-key-1", 2: "acme-key-2"} {
+		secret, err := sm.GetSecret(ctx, name, version)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(secret))
+	}
+
+	_, err := sm.GetSecret(ctx, name, 3)
+	assert.Error(t, err)
+	_, err = sm.GetSecret(ctx, types.JuiceboxTenantSecretPrefix+"missing", 1)
+	assert.Error(t, err)
+	_, err = sm.GetSecret(ctx, name, 0)
+	assert.EqualError(t, err, "vault secret version 0 is out of range")
+
+	assert.NoError(t, sm.CheckHealth(ctx))
+}
+
+func TestVaultTokenAuth(t *testing.T) {
+	client, prefix := newTestVault(t)
+
+	sm, err := NewVaultSecretsManager(context.Background(), types.VaultOptions{
+		Address:    client.Address(),
+		PathPrefix: prefix,
+		Token:      client.Token(),
+	})
+	assert.NoError(t, err)
+	defer sm.Close()
+	testVaultSecrets(t, sm)
+
+	_, err = NewVaultSecretsManager(context.Background(), types.VaultOptions{
+		Address: client.Address(),
+		Token:   "not-a-token",
+	})
+	assert.ErrorContains(t, err, "error logging in to vault")
+}
+
+func TestVaultAppRoleAuth(t *testing.T) {
+	client, prefix := newTestVault(t)
+	ctx := context.Background()
+
+	err := client.Sys().EnableAuthWithOptionsWithContext(ctx, "approle", &vault.EnableAuthOptions{Type: "approle"})
+	if err != nil && !strings.Contains(err.Error(), "path is already in use") {
+		t.Fatal(err)
+	}
+
+	policy := strings.TrimSuffix(prefix, "/")
+	err = client.Sys().PutPolicyWithContext(ctx, policy, fmt.Sprintf(`path "secret/data/%s*" { capabilities = ["read"] }`, prefix))
+	assert.NoError(t, err)
+
+	// the token's short lifetime means it has to log in again during the test
+	role := "auth/approle/role/" + policy
+	_, err = client.Logical().WriteWithContext(ctx, role, map[string]interface{}{
+		"token_policies": policy,
+		"token_ttl":      "2s",
+		"token_max_ttl":  "4s",
+	})
+	assert.NoError(t, err)
+	roleID, err := client.Logical().ReadWithContext(ctx, role+"/role-id")
+	assert.NoError(t, err)
+	secretID, err := client.Logical().WriteWithContext(ctx, role+"/secret-id", nil)
+	assert.NoError(t, err)
+
+	sm, err := NewVaultSecretsManager(ctx, types.VaultOptions{
+		Address:    client.Address(),
+		PathPrefix: prefix,
+		AuthMethod: "approle",
+		RoleID:     roleID.Data["role_id"].(string),
+		SecretID:   secretID.Data["secret_id"].(string),
+	})
+	assert.NoError(t, err)
+	defer sm.Close()
+	testVaultSecrets(t, sm)
+
+	time.Sleep(6 * time.Second)
+	testVaultSecrets(t, sm)
+}
+
+func TestVaultReloginWithoutRenewal(t *testing.T) {
+	// a login can return a token that can't be renewed, such as a batch
+	// token, which is replaced shortly before it expires
+	logins := make(chan struct{}, 10)
+	notRenewable := &vault.Secret{Auth: &vault.SecretAuth{LeaseDuration: 1}}
+	ctx, cancel := context.WithCancel(context.Background())
+	sm := &VaultSecretsManager{
+		method: func(context.Context, *vault.Client) (*vault.Secret, error) {
+			logins <- struct{}{}
+			return notRenewable, nil
+		},
+		relogin: true,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	start := time.Now()
+	go sm.renewToken(ctx, notRenewable)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-logins:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting to log in again")
+		}
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 1800*time.Millisecond)
+	sm.Close()
+
+	// a token given directly isn't replaced
+	ctx, cancel = context.WithCancel(context.Background())
+	sm.relogin = false
+	sm.cancel = cancel
+	sm.done = make(chan struct{})
+	go sm.renewToken(ctx, notRenewable)
+	select {
+	case <-sm.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected renewal to stop")
+	}
+	assert.Empty(t, logins)
+	cancel()
+}
@@ -1,10 +1,13 @@
 package types
 
 import (
+	"context"
 	"crypto/subtle"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -16,10 +19,105 @@ const (
 	AWS
 	Mongo
 	Memory
+	Postgres
+	Local
+	Redis
+	S3
+	Vault
+	File
+	JWKS
 )
 
+func (p ProviderName) String() string {
+	switch p {
+	case GCP:
+		return "gcp"
+	case AWS:
+		return "aws"
+	case Mongo:
+		return "mongo"
+	case Memory:
+		return "memory"
+	case Postgres:
+		return "postgres"
+	case Local:
+		return "local"
+	case Redis:
+		return "redis"
+	case S3:
+		return "s3"
+	case Vault:
+		return "vault"
+	case File:
+		return "file"
+	case JWKS:
+		return "jwks"
+	default:
+		return fmt.Sprintf("ProviderName(%d)", int(p))
+	}
+}
+
+// HealthChecker is optionally implemented by record stores, secrets managers
+// and pub/sub systems that can cheaply check they're able to serve requests.
+type HealthChecker interface {
+	CheckHealth(ctx context.Context) error
+}
+
+// ErrHealthCheckUnsupported is returned by wrappers whose inner component
+// doesn't implement HealthChecker.
+var ErrHealthCheckUnsupported = errors.New("health check unsupported")
+
 type ProviderOptions struct {
 	Config interface{}
+
+	GcpProjectID       string
+	BigtableInstanceID string
+	AwsRegion          string
+	// Don't create the DynamoDB table if it doesn't exist.
+	AwsSkipTableCreation bool
+	MongoURL             string
+	PostgresURL          string
+	RedisURL             string
+	// The bucket used to store records when using the s3 provider, and the
+	// endpoint of the service if it isn't AWS S3.
+	S3Bucket         string
+	S3Endpoint       string
+	S3ForcePathStyle bool
+	// The file used to store records when using the local provider.
+	DataPath string
+	// The versioned tenant secrets, keyed by tenant name, used by the memory
+	// and local providers.
+	TenantSecrets map[string]map[uint64]string
+	// The file, or directory of files, holding the tenant secrets used by
+	// the file provider, and how often to check it for changes.
+	TenantSecretsPath           string
+	TenantSecretsReloadInterval time.Duration
+	// The URL of each tenant's JSON Web Key Set, keyed by tenant name, used
+	// by the jwks provider, the longest a set is kept before it's fetched
+	// again, and whether the sets can be fetched over plain http.
+	JWKSURLs              map[string]string
+	JWKSRefreshInterval   time.Duration
+	JWKSAllowInsecureHTTP bool
+	Vault                 VaultOptions
+}
+
+// VaultOptions configures how the vault provider reaches Vault and logs in.
+type VaultOptions struct {
+	Address string
+	// The mount path of the KV v2 secrets engine, and a prefix added to the
+	// path of each secret in it.
+	Mount      string
+	PathPrefix string
+	// One of "token", "approle" or "kubernetes".
+	AuthMethod string
+	// The mount path of the auth method, if it isn't mounted at its default
+	// path.
+	AuthMount           string
+	Token               string
+	RoleID              string
+	SecretID            string
+	KubernetesRole      string
+	KubernetesTokenPath string
 }
 
 type RealmID [16]byte
@@ -73,11 +171,32 @@ const (
 	HS256 AuthKeyAlgorithm = "HmacSha256"
 	/// Edwards-curve 25519 Digital Signature Algorithm
 	EdDSA AuthKeyAlgorithm = "Edwards25519"
+	/// ECDSA using P-256 and SHA-256
+	ES256 AuthKeyAlgorithm = "EcdsaP256Sha256"
+	/// ECDSA using P-384 and SHA-384
+	ES384 AuthKeyAlgorithm = "EcdsaP384Sha384"
+	/// RSASSA-PSS using SHA-256 and MGF1 with SHA-256
+	PS256 AuthKeyAlgorithm = "RsaPssSha256"
 )
 
+// Matches reports whether alg is the name of the algorithm in a jwt header.
 func (aka AuthKeyAlgorithm) Matches(alg string) bool {
-	lowerAlg := strings.ToLower(alg)
-	return (aka == HS256 && lowerAlg == "hs256") || (aka == RS256 && lowerAlg == "rs256") || (aka == EdDSA && lowerAlg == "eddsa")
+	switch strings.ToLower(alg) {
+	case "hs256":
+		return aka == HS256
+	case "rs256":
+		return aka == RS256
+	case "eddsa":
+		return aka == EdDSA
+	case "es256":
+		return aka == ES256
+	case "es384":
+		return aka == ES384
+	case "ps256":
+		return aka == PS256
+	default:
+		return false
+	}
 }
 
 type AuthKeyDataEncoding string
@@ -85,6 +204,8 @@ type AuthKeyDataEncoding string
 const (
 	Hex  AuthKeyDataEncoding = "Hex"
 	UTF8 AuthKeyDataEncoding = "UTF8"
+	/// A PEM encoded public key, such as a "PUBLIC KEY" block
+	PEM AuthKeyDataEncoding = "PEM"
 )
 
 type AuthKeyJSON struct {